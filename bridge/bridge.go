@@ -0,0 +1,419 @@
+// Package bridge converts between Go and Lisp values with reflection, so
+// that ordinary Go functions, methods and structs can be used from Lisp
+// without hand-written func([]Top) (Top, error) wrappers.
+//
+// Go values become Lisp values as follows: booleans, strings and numbers
+// map to their Lisp counterparts, slices and arrays to vectors, maps with
+// string keys to hash-maps and functions to callable builtins. Structs,
+// pointers and everything else are wrapped in a GoObject, whose fields
+// and methods scripts reach through go-field, go-set-field! and go-call.
+package bridge
+
+import (
+	"math/big"
+	"reflect"
+)
+
+import (
+	. "github.com/ntaoo/lispgo/types"
+)
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// ToLisp converts a Go value to a Lisp value. Lisp values are returned
+// unchanged.
+func ToLisp(x interface{}) Top {
+	switch x := x.(type) {
+	case nil, bool, int, float64, string, Symbol, Keyword, List, Vector, HashMap,
+		Func, Callable, *Atom, GoObject:
+		return x
+	case *big.Int:
+		return NormalizeBigInt(x)
+	case *big.Rat:
+		return NormalizeRat(x)
+	case func([]Top) (Top, error):
+		return NewFunc(x, nil)
+	}
+	return valueToLisp(reflect.ValueOf(x))
+}
+
+func valueToLisp(v reflect.Value) Top {
+	switch v.Kind() {
+	case reflect.Invalid:
+		return nil
+	case reflect.Bool:
+		return v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return NormalizeBigInt(big.NewInt(v.Int()))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return NormalizeBigInt(new(big.Int).SetUint64(v.Uint()))
+	case reflect.Float32, reflect.Float64:
+		return v.Float()
+	case reflect.String:
+		return v.String()
+	case reflect.Slice, reflect.Array:
+		lst := make([]Top, v.Len())
+		for i := range lst {
+			lst[i] = valueToLisp(v.Index(i))
+		}
+		return NewVector(lst...)
+	case reflect.Map:
+		hm := HashMap{}
+		for _, k := range v.MapKeys() {
+			hm = hm.Assoc(valueToLisp(k), valueToLisp(v.MapIndex(k)))
+		}
+		return hm
+	case reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return ToLisp(v.Elem().Interface())
+	case reflect.Ptr:
+		if v.IsNil() {
+			return nil
+		}
+		switch x := v.Interface().(type) {
+		case *big.Int, *big.Rat, *Atom:
+			return ToLisp(x)
+		}
+		return GoObject{v.Interface(), nil}
+	case reflect.Struct:
+		if v.CanInterface() {
+			switch x := v.Interface().(type) {
+			case Symbol, Keyword, List, Vector, HashMap, Func, GoObject:
+				return x
+			}
+		}
+		// Wrap a pointer so that go-set-field! and pointer methods
+		// work; a struct held in a slice or another struct is shared,
+		// anything else is copied.
+		if v.CanAddr() {
+			return GoObject{v.Addr().Interface(), nil}
+		}
+		p := reflect.New(v.Type())
+		p.Elem().Set(v)
+		return GoObject{p.Interface(), nil}
+	case reflect.Func:
+		if v.IsNil() {
+			return nil
+		}
+		fn, _ := WrapFunc("", v.Interface())
+		return fn
+	default:
+		return GoObject{v.Interface(), nil}
+	}
+}
+
+// FromLisp converts a Lisp value to a Go value of type t.
+func FromLisp(obj Top, t reflect.Type) (reflect.Value, error) {
+	return fromLisp(NoCaller, "", obj, t)
+}
+
+// keyName is the Go name of a string or keyword hash-map key.
+func keyName(k Top) (string, bool) {
+	switch k := k.(type) {
+	case string:
+		return k, true
+	case Keyword:
+		return k.Name(), true
+	}
+	return "", false
+}
+
+func fromLisp(c Caller, name string, obj Top, t reflect.Type) (reflect.Value, error) {
+	if g, ok := obj.(GoObject); ok {
+		v := reflect.ValueOf(g.Val)
+		if v.Type().AssignableTo(t) {
+			return v, nil
+		}
+		if v.Kind() == reflect.Ptr && v.Elem().Type().AssignableTo(t) {
+			return v.Elem(), nil
+		}
+		return reflect.Value{}, WrongType(name, t.String(), obj)
+	}
+	if t.Kind() == reflect.Interface {
+		if obj == nil {
+			return reflect.Zero(t), nil
+		}
+		v := reflect.ValueOf(obj)
+		if v.Type().AssignableTo(t) {
+			return v, nil
+		}
+		return reflect.Value{}, WrongType(name, t.String(), obj)
+	}
+
+	v := reflect.New(t).Elem()
+	switch t.Kind() {
+	case reflect.Bool:
+		if b, ok := obj.(bool); ok {
+			v.SetBool(b)
+			return v, nil
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if !IsInteger(obj) {
+			break
+		}
+		n := toBig(obj)
+		if !n.IsInt64() || v.OverflowInt(n.Int64()) {
+			return v, NewErrorf(ValueError, name, "%v is out of range for %v", n, t)
+		}
+		v.SetInt(n.Int64())
+		return v, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if !IsInteger(obj) {
+			break
+		}
+		n := toBig(obj)
+		if !n.IsUint64() || v.OverflowUint(n.Uint64()) {
+			return v, NewErrorf(ValueError, name, "%v is out of range for %v", n, t)
+		}
+		v.SetUint(n.Uint64())
+		return v, nil
+	case reflect.Float32, reflect.Float64:
+		if f, ok := ToFloat(obj); ok {
+			v.SetFloat(f)
+			return v, nil
+		}
+	case reflect.String:
+		if s, ok := obj.(string); ok {
+			v.SetString(s)
+			return v, nil
+		}
+	case reflect.Slice, reflect.Array:
+		if obj == nil && t.Kind() == reflect.Slice {
+			return v, nil
+		}
+		lst, e := GetSlice(obj)
+		if e != nil {
+			break
+		}
+		if t.Kind() == reflect.Slice {
+			v = reflect.MakeSlice(t, len(lst), len(lst))
+		} else if len(lst) != t.Len() {
+			return v, NewErrorf(ValueError, name, "expected %d elements for %v, got %d", t.Len(), t, len(lst))
+		}
+		for i, x := range lst {
+			elem, e := fromLisp(c, name, x, t.Elem())
+			if e != nil {
+				return v, e
+			}
+			v.Index(i).Set(elem)
+		}
+		return v, nil
+	case reflect.Map:
+		if obj == nil {
+			return v, nil
+		}
+		hm, ok := obj.(HashMap)
+		if !ok {
+			break
+		}
+		v = reflect.MakeMapWithSize(t, hm.Len())
+		var e error
+		hm.Each(func(k Top, x Top) bool {
+			var key, elem reflect.Value
+			if s, ok := keyName(k); ok && t.Key().Kind() == reflect.String {
+				key = reflect.ValueOf(s).Convert(t.Key())
+			} else if key, e = fromLisp(c, name, k, t.Key()); e != nil {
+				return false
+			}
+			if elem, e = fromLisp(c, name, x, t.Elem()); e != nil {
+				return false
+			}
+			v.SetMapIndex(key, elem)
+			return true
+		})
+		return v, e
+	case reflect.Struct:
+		// A hash-map such as {:Name "x"} fills in the named fields.
+		hm, ok := obj.(HashMap)
+		if !ok {
+			break
+		}
+		var e error
+		hm.Each(func(k Top, x Top) bool {
+			s, ok := keyName(k)
+			if !ok {
+				e = WrongType(name, "string or keyword field name", k)
+				return false
+			}
+			f, ok := t.FieldByName(s)
+			if !ok || f.PkgPath != "" {
+				e = NewErrorf(ValueError, name, "%v has no field %v", t, s)
+				return false
+			}
+			var fv reflect.Value
+			if fv, e = fromLisp(c, name, x, f.Type); e != nil {
+				return false
+			}
+			v.FieldByIndex(f.Index).Set(fv)
+			return true
+		})
+		return v, e
+	case reflect.Ptr:
+		if obj == nil {
+			return v, nil
+		}
+		if t.Elem().Kind() != reflect.Struct || !IsHashMap(obj) {
+			break
+		}
+		elem, e := fromLisp(c, name, obj, t.Elem())
+		if e != nil {
+			return v, e
+		}
+		v = reflect.New(t.Elem())
+		v.Elem().Set(elem)
+		return v, nil
+	case reflect.Func:
+		switch obj.(type) {
+		case Func, Callable, func([]Top) (Top, error):
+			return callback(c, name, obj, t), nil
+		}
+	}
+	return v, WrongType(name, t.String(), obj)
+}
+
+func toBig(obj Top) *big.Int {
+	if n, ok := obj.(int); ok {
+		return big.NewInt(int64(n))
+	}
+	return obj.(*big.Int)
+}
+
+// WrapFunc turns a Go function or method value into a builtin that
+// converts its arguments and results. Variadic functions take any number
+// of trailing arguments; a trailing error result is raised as a go-error
+// and several other results are returned as a vector. name is used in
+// error messages.
+func WrapFunc(name string, fn interface{}) (Top, error) {
+	if f, ok := fn.(func([]Top) (Top, error)); ok {
+		return NewFunc(f, nil), nil
+	}
+	v := reflect.ValueOf(fn)
+	if v.Kind() != reflect.Func {
+		return nil, WrongType(name, "Go function", ToLisp(fn))
+	}
+	return NewBuiltin(func(c Caller, a []Top) (Top, error) {
+		return call(c, name, v, a)
+	}, nil), nil
+}
+
+// call calls fn with arguments a from c, which Lisp functions passed to
+// it are called back from.
+func call(c Caller, name string, fn reflect.Value, a []Top) (res Top, err error) {
+	// A panicking Go function must not take the interpreter down.
+	defer func() {
+		if r := recover(); r != nil {
+			if lge, ok := r.(LGError); ok {
+				res, err = nil, lge
+				return
+			}
+			res, err = nil, NewErrorf(GoError, name, "panic: %v", r)
+		}
+	}()
+
+	t := fn.Type()
+	n := t.NumIn()
+	if t.IsVariadic() {
+		err = CheckArity(name, a, n-1, -1)
+	} else {
+		err = CheckArity(name, a, n, n)
+	}
+	if err != nil {
+		return nil, err
+	}
+	args := make([]reflect.Value, len(a))
+	for i, x := range a {
+		var pt reflect.Type
+		if t.IsVariadic() && i >= n-1 {
+			pt = t.In(n - 1).Elem()
+		} else {
+			pt = t.In(i)
+		}
+		if args[i], err = fromLisp(c, name, x, pt); err != nil {
+			return nil, err
+		}
+	}
+
+	out := fn.Call(args)
+	if k := len(out); k > 0 && t.Out(k-1) == errorType {
+		if e := out[k-1]; !e.IsNil() {
+			return nil, goError(name, e.Interface().(error))
+		}
+		out = out[:k-1]
+	}
+	switch len(out) {
+	case 0:
+		return nil, nil
+	case 1:
+		return valueToLisp(out[0]), nil
+	default:
+		lst := make([]Top, len(out))
+		for i, o := range out {
+			lst[i] = valueToLisp(o)
+		}
+		return NewVector(lst...), nil
+	}
+}
+
+func goError(name string, e error) error {
+	if lge, ok := e.(LGError); ok {
+		return lge
+	}
+	return NewError(GoError, name, nil, e.Error())
+}
+
+// callback makes a Go function of type t that calls a Lisp function back
+// from c. A Lisp error is returned through a trailing error result if t
+// has one and panics otherwise; WrapFunc turns such panics back into
+// errors.
+func callback(c Caller, name string, f Top, t reflect.Type) reflect.Value {
+	return reflect.MakeFunc(t, func(in []reflect.Value) []reflect.Value {
+		args := make([]Top, 0, len(in))
+		for i, x := range in {
+			if t.IsVariadic() && i == len(in)-1 {
+				for j := 0; j < x.Len(); j += 1 {
+					args = append(args, valueToLisp(x.Index(j)))
+				}
+			} else {
+				args = append(args, valueToLisp(x))
+			}
+		}
+
+		n := t.NumOut()
+		hasError := n > 0 && t.Out(n-1) == errorType
+		if hasError {
+			n -= 1
+		}
+		out := make([]reflect.Value, t.NumOut())
+		for i := range out {
+			out[i] = reflect.Zero(t.Out(i))
+		}
+		fail := func(e error) []reflect.Value {
+			if !hasError {
+				panic(e)
+			}
+			out[len(out)-1] = reflect.ValueOf(&e).Elem()
+			return out
+		}
+
+		res, e := c.Apply(f, args)
+		if e != nil {
+			return fail(e)
+		}
+		results := []Top{res}
+		if n > 1 {
+			if results, e = GetSlice(res); e != nil || len(results) != n {
+				return fail(NewErrorf(ValueError, name, "expected %d results from callback", n))
+			}
+		}
+		for i := 0; i < n; i += 1 {
+			v, e := fromLisp(c, name, results[i], t.Out(i))
+			if e != nil {
+				return fail(e)
+			}
+			out[i] = v
+		}
+		return out
+	})
+}
@@ -0,0 +1,222 @@
+package bridge
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+import (
+	"github.com/ntaoo/lispgo/printer"
+	. "github.com/ntaoo/lispgo/types"
+)
+
+type person struct {
+	Name    string
+	Age     int
+	Tags    []string
+	private int
+}
+
+func (p *person) Greet(greeting string) string {
+	return greeting + ", " + p.Name
+}
+
+func (p *person) Birthday() {
+	p.Age += 1
+}
+
+func TestToLisp(t *testing.T) {
+	cases := []struct {
+		value    interface{}
+		expected string
+	}{
+		{nil, "nil"},
+		{int8(-3), "-3"},
+		{uint64(math.MaxUint64), "18446744073709551615"},
+		{float32(1.5), "1.5"},
+		{"s", `"s"`},
+		{[]string{"a", "b"}, `["a" "b"]`},
+		{[2]bool{true, false}, "[true false]"},
+		{map[string]int{"a": 1}, `{"a" 1}`},
+		{[]interface{}{1, "x", nil}, `[1 "x" nil]`},
+		{person{Name: "Ann"}, "<go *bridge.person>"},
+		{map[int]string{1: "a"}, `{1 "a"}`},
+	}
+	for _, c := range cases {
+		actual := printer.PrintString(ToLisp(c.value), true)
+		if actual != c.expected {
+			t.Errorf("ToLisp(%#v): expected %v, actual %v", c.value, c.expected, actual)
+		}
+	}
+}
+
+func TestFromLisp(t *testing.T) {
+	kw := InternKeyword("Name")
+	cases := []struct {
+		obj      Top
+		expected interface{}
+	}{
+		{1, 1.0},
+		{NewVector(1, 2), []int{1, 2}},
+		{List{[]Top{"a"}, nil}, [1]string{"a"}},
+		{HashMap{}.Assoc(kw, 1), map[string]int{"Name": 1}},
+		{HashMap{}.Assoc(2, true), map[int8]bool{2: true}},
+		{HashMap{}.Assoc(kw, "Bo"), person{Name: "Bo"}},
+		{HashMap{}.Assoc(kw, "Bo"), &person{Name: "Bo"}},
+		{"x", interface{}("x")},
+	}
+	for _, c := range cases {
+		v, e := FromLisp(c.obj, reflect.TypeOf(c.expected))
+		if e != nil {
+			t.Errorf("FromLisp(%#v): %v", c.obj, e)
+		} else if !reflect.DeepEqual(v.Interface(), c.expected) {
+			t.Errorf("FromLisp(%#v): expected %#v, actual %#v", c.obj, c.expected, v.Interface())
+		}
+	}
+}
+
+func callWrapped(t *testing.T, fn interface{}, a ...Top) (Top, error) {
+	f, e := WrapFunc("f", fn)
+	if e != nil {
+		t.Fatal(e)
+	}
+	return Apply(f, a)
+}
+
+func TestWrapFunc(t *testing.T) {
+	cases := []struct {
+		fn       interface{}
+		args     []Top
+		expected string
+	}{
+		{strings.Repeat, []Top{"ab", 2}, `"abab"`},
+		{math.Sqrt, []Top{4}, "2.0"},
+		{strconv.Atoi, []Top{"12"}, "12"},
+		{func() {}, nil, "nil"},
+		{func() (int, string) { return 1, "a" }, nil, `[1 "a"]`},
+		{func(xs ...int) int { return len(xs) }, []Top{1, 2, 3}, "3"},
+		{fmt.Sprint, nil, `""`},
+		{func(p person) string { return p.Name }, []Top{ToLisp(person{Name: "Cy"})}, `"Cy"`},
+	}
+	for _, c := range cases {
+		res, e := callWrapped(t, c.fn, c.args...)
+		if e != nil {
+			t.Errorf("%T: %v", c.fn, e)
+		} else if actual := printer.PrintString(res, true); actual != c.expected {
+			t.Errorf("%T: expected %v, actual %v", c.fn, c.expected, actual)
+		}
+	}
+}
+
+func TestWrappedFuncsAreDistinct(t *testing.T) {
+	sqrt, _ := WrapFunc("sqrt", math.Sqrt)
+	cbrt, _ := WrapFunc("cbrt", math.Cbrt)
+	if Eq(sqrt, cbrt) || Hash(sqrt) == Hash(cbrt) {
+		t.Error("two wrapped functions are equal")
+	}
+	if !Eq(sqrt, sqrt) {
+		t.Error("a wrapped function is not equal to itself")
+	}
+}
+
+func TestWrapFuncErrors(t *testing.T) {
+	cases := []struct {
+		fn   interface{}
+		args []Top
+		kind string
+	}{
+		{strings.Repeat, []Top{"ab"}, ArityError},
+		{strings.Repeat, []Top{"ab", "2"}, TypeError},
+		{func(int8) {}, []Top{200}, ValueError},
+		{func(uint) {}, []Top{-1}, ValueError},
+		{strconv.Atoi, []Top{"x"}, GoError},
+		{func() { panic("boom") }, nil, GoError},
+		{func() error { return errors.New("failed") }, nil, GoError},
+	}
+	for _, c := range cases {
+		_, e := callWrapped(t, c.fn, c.args...)
+		lge, ok := e.(LGError)
+		if !ok {
+			t.Errorf("%T%v: expected an LGError, got %v", c.fn, c.args, e)
+			continue
+		}
+		expected, _ := NewKeyword(c.kind)
+		if kind, _ := ErrorField(lge.Obj, "kind"); kind != expected {
+			t.Errorf("%T%v: expected a %v, got %v", c.fn, c.args, c.kind, e)
+		}
+	}
+}
+
+func TestCallback(t *testing.T) {
+	double := NewFunc(func(a []Top) (Top, error) { return NumMul(a[0], 2) }, nil)
+	apply := func(f func(int) int, x int) int { return f(x) }
+	res, e := callWrapped(t, apply, double, 21)
+	if e != nil || res != 42 {
+		t.Errorf("expected 42, actual %v, %v", res, e)
+	}
+
+	fail := NewFunc(func(a []Top) (Top, error) { return nil, LGError{Obj: "stop"} }, nil)
+	_, e = callWrapped(t, apply, fail, 1)
+	if lge, ok := e.(LGError); !ok || lge.Obj != "stop" {
+		t.Errorf("callback error was not passed through: %v", e)
+	}
+	tryApply := func(f func(int) (int, error), x int) error {
+		_, e := f(x)
+		return e
+	}
+	_, e = callWrapped(t, tryApply, fail, 1)
+	if lge, ok := e.(LGError); !ok || lge.Obj != "stop" {
+		t.Errorf("callback error was not returned: %v", e)
+	}
+}
+
+func TestGoObjects(t *testing.T) {
+	p := &person{Name: "Ann", Age: 3, Tags: []string{"x"}}
+	obj := ToLisp(p)
+	call := func(name string, a ...Top) Top {
+		res, e := Apply(Functions[name], a)
+		if e != nil {
+			t.Fatalf("%v%v: %v", name, a, e)
+		}
+		return res
+	}
+	age, _ := NewKeyword("Age")
+	if res := call("go-field", obj, "Name"); res != "Ann" {
+		t.Errorf("expected Ann, actual %v", res)
+	}
+	call("go-set-field!", obj, age, 10)
+	call("go-call", obj, "Birthday")
+	if p.Age != 11 {
+		t.Errorf("expected the struct to be updated in place, Age = %v", p.Age)
+	}
+	if res := call("go-call", obj, "Greet", "hi"); res != "hi, Ann" {
+		t.Errorf("expected greeting, actual %v", res)
+	}
+	if res := printer.PrintString(call("go-field", obj, "Tags"), true); res != `["x"]` {
+		t.Errorf("expected tags, actual %v", res)
+	}
+
+	errorCases := [][]Top{
+		{obj, "private"},
+		{obj, "Missing"},
+		{1, "Name"},
+		{obj, "Age", "old"},
+	}
+	for _, a := range errorCases {
+		name := "go-field"
+		if len(a) == 3 {
+			name = "go-set-field!"
+		}
+		if _, e := Functions[name].(func([]Top) (Top, error))(a); e == nil {
+			t.Errorf("%v%v: expected an error", name, a)
+		}
+	}
+	if _, e := Apply(Functions["go-call"], []Top{obj, "Nope"}); e == nil {
+		t.Error("expected an error for a missing method")
+	}
+}
@@ -0,0 +1,112 @@
+package bridge
+
+import (
+	"reflect"
+)
+
+import (
+	. "github.com/ntaoo/lispgo/types"
+)
+
+// structValue returns the struct a GoObject points to.
+func structValue(name string, obj Top) (reflect.Value, error) {
+	g, ok := obj.(GoObject)
+	if !ok {
+		return reflect.Value{}, WrongType(name, "go object", obj)
+	}
+	v := reflect.ValueOf(g.Val)
+	for v.Kind() == reflect.Ptr && !v.IsNil() {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return reflect.Value{}, WrongType(name, "go struct", obj)
+	}
+	return v, nil
+}
+
+// memberName accepts a string or a keyword naming a field or method.
+func memberName(name string, obj Top) (string, error) {
+	s, ok := keyName(obj)
+	if !ok {
+		return "", WrongType(name, "string or keyword", obj)
+	}
+	return s, nil
+}
+
+func field(name string, a []Top) (reflect.Value, error) {
+	v, e := structValue(name, a[0])
+	if e != nil {
+		return v, e
+	}
+	fname, e := memberName(name, a[1])
+	if e != nil {
+		return v, e
+	}
+	f, ok := v.Type().FieldByName(fname)
+	if !ok || f.PkgPath != "" {
+		return v, NewErrorf(ValueError, name, "%v has no field %v", v.Type(), fname)
+	}
+	return v.FieldByIndex(f.Index), nil
+}
+
+func goField(a []Top) (Top, error) {
+	if e := CheckArity("go-field", a, 2, 2); e != nil {
+		return nil, e
+	}
+	f, e := field("go-field", a)
+	if e != nil {
+		return nil, e
+	}
+	return valueToLisp(f), nil
+}
+
+func goSetField(a []Top) (Top, error) {
+	if e := CheckArity("go-set-field!", a, 3, 3); e != nil {
+		return nil, e
+	}
+	f, e := field("go-set-field!", a)
+	if e != nil {
+		return nil, e
+	}
+	if !f.CanSet() {
+		return nil, NewError(ValueError, "go-set-field!", nil, "field is not settable")
+	}
+	v, e := fromLisp(NoCaller, "go-set-field!", a[2], f.Type())
+	if e != nil {
+		return nil, e
+	}
+	f.Set(v)
+	return a[2], nil
+}
+
+func goCall(c Caller, a []Top) (Top, error) {
+	if e := CheckArity("go-call", a, 2, -1); e != nil {
+		return nil, e
+	}
+	g, ok := a[0].(GoObject)
+	if !ok {
+		return nil, WrongType("go-call", "go object", a[0])
+	}
+	mname, e := memberName("go-call", a[1])
+	if e != nil {
+		return nil, e
+	}
+	m := reflect.ValueOf(g.Val).MethodByName(mname)
+	if !m.IsValid() {
+		return nil, NewErrorf(ValueError, "go-call", "%T has no method %v", g.Val, mname)
+	}
+	return call(c, mname, m, a[2:])
+}
+
+// Functions are the builtins for working with Go objects from Lisp.
+var Functions = map[string]Top{
+	"go-object?": func(a []Top) (Top, error) {
+		if e := CheckArity("go-object?", a, 1, 1); e != nil {
+			return nil, e
+		}
+		return IsGoObject(a[0]), nil
+	},
+	"go-field":      goField,
+	"go-set-field!": goSetField,
+	"go-call":       NewBuiltin(goCall, nil),
+}
@@ -1,3 +1,3 @@
 #!/usr/bin/env bash
 
-go build -o ./lisp lispgo/lispgo.go
\ No newline at end of file
+go build -o ./lisp ./lispgo
\ No newline at end of file
@@ -0,0 +1,349 @@
+package core
+
+import (
+	"reflect"
+	"time"
+)
+
+import (
+	. "github.com/ntaoo/lispgo/types"
+)
+
+// Concurrency
+//
+// spawn runs a function in a new goroutine and returns a future for its
+// result; the go macro of the prelude wraps a body in a thunk for it.
+// Goroutines talk through channels: >! and <! block like their Go
+// counterparts, close! wakes up blocked senders and receivers, and
+// alts! waits on several channel operations at once, which is what the
+// select macro expands to. Channels cannot carry nil, which is what <!
+// returns once a channel is closed. The builtins that wait, these and
+// deref and sleep, give up with an :interrupted error when their caller
+// is interrupted.
+
+func argChan(name string, a []Top, i int) (*Chan, error) {
+	c, ok := a[i].(*Chan)
+	if !ok {
+		return nil, WrongType(name, "channel", a[i])
+	}
+	return c, nil
+}
+
+func argMillis(name string, a []Top, i int) (time.Duration, error) {
+	ms, e := argInt(name, a, i)
+	if e != nil {
+		return 0, e
+	}
+	if ms < 0 {
+		return 0, NewErrorf(ValueError, name, "negative duration %d", ms)
+	}
+	return time.Duration(ms) * time.Millisecond, nil
+}
+
+// interrupted is the error of a builtin that gave up waiting.
+func interrupted(name string) error {
+	return NewError(InterruptError, name, nil, "interrupted")
+}
+
+func spawn(a []Top) (Top, error) {
+	if e := CheckArity("spawn", a, 1, -1); e != nil {
+		return nil, e
+	}
+	switch a[0].(type) {
+	case Func, Callable:
+	default:
+		return nil, WrongType("spawn", "function", a[0])
+	}
+	fut := NewFuture()
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				fut.Resolve(nil, NewErrorf(GoError, "spawn", "panic: %v", r))
+			}
+		}()
+		fut.Resolve(Apply(a[0], a[1:]))
+	}()
+	return fut, nil
+}
+
+// derefFuture waits for a future and returns its value or raises its
+// error. (deref future ms val) gives up after ms milliseconds and
+// returns val instead.
+func derefFuture(stop <-chan struct{}, fut *Future, a []Top) (Top, error) {
+	if len(a) == 2 {
+		return nil, NewErrorf(ArityError, "deref", "expected 1 or 3 arguments, got 2")
+	}
+	timeout := time.Duration(-1)
+	if len(a) == 3 {
+		var e error
+		if timeout, e = argMillis("deref", a, 1); e != nil {
+			return nil, e
+		}
+	}
+	val, e, ok := fut.Wait(timeout, stop)
+	if !ok {
+		if Stopped(stop) {
+			return nil, interrupted("deref")
+		}
+		return a[2], nil
+	}
+	return val, e
+}
+
+func makeChan(a []Top) (Top, error) {
+	if e := CheckArity("chan", a, 0, 1); e != nil {
+		return nil, e
+	}
+	size := 0
+	if len(a) == 1 {
+		var e error
+		if size, e = argInt("chan", a, 0); e != nil {
+			return nil, e
+		}
+		if size < 0 {
+			return nil, NewErrorf(ValueError, "chan", "negative buffer size %d", size)
+		}
+	}
+	return NewChan(size), nil
+}
+
+func put(caller Caller, a []Top) (Top, error) {
+	if e := CheckArity(">!", a, 2, 2); e != nil {
+		return nil, e
+	}
+	c, e := argChan(">!", a, 0)
+	if e != nil {
+		return nil, e
+	}
+	if a[1] == nil {
+		return nil, NewError(ValueError, ">!", nil, "cannot put nil on a channel")
+	}
+	sent := c.Send(a[1], caller.Stop())
+	if !sent && Stopped(caller.Stop()) {
+		return nil, interrupted(">!")
+	}
+	return sent, nil
+}
+
+func take(caller Caller, a []Top) (Top, error) {
+	if e := CheckArity("<!", a, 1, 1); e != nil {
+		return nil, e
+	}
+	c, e := argChan("<!", a, 0)
+	if e != nil {
+		return nil, e
+	}
+	val, ok := c.Recv(caller.Stop())
+	if !ok && Stopped(caller.Stop()) {
+		return nil, interrupted("<!")
+	}
+	return val, nil
+}
+
+func closeChan(a []Top) (Top, error) {
+	if e := CheckArity("close!", a, 1, 1); e != nil {
+		return nil, e
+	}
+	c, e := argChan("close!", a, 0)
+	if e != nil {
+		return nil, e
+	}
+	c.Close()
+	return nil, nil
+}
+
+// timeout returns a channel that closes after the given number of
+// milliseconds, for use with alts! and select.
+func timeout(a []Top) (Top, error) {
+	if e := CheckArity("timeout", a, 1, 1); e != nil {
+		return nil, e
+	}
+	d, e := argMillis("timeout", a, 0)
+	if e != nil {
+		return nil, e
+	}
+	c := NewChan(0)
+	time.AfterFunc(d, c.Close)
+	return c, nil
+}
+
+func sleep(caller Caller, a []Top) (Top, error) {
+	if e := CheckArity("sleep", a, 1, 1); e != nil {
+		return nil, e
+	}
+	d, e := argMillis("sleep", a, 0)
+	if e != nil {
+		return nil, e
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil, nil
+	case <-caller.Stop():
+		return nil, interrupted("sleep")
+	}
+}
+
+// alts! performs whichever of several channel operations is ready first.
+// Each operation is a channel to receive from or a [channel value] pair
+// to send. It returns [result index], where result is the value received
+// (nil if the channel was closed) or whether the value was sent, and
+// index is the position of the operation. With :default val it does not
+// block and returns [val -1] when no operation is ready.
+func alts(caller Caller, a []Top) (Top, error) {
+	if e := CheckArity("alts!", a, 1, 3); e != nil {
+		return nil, e
+	}
+	ops, e := argSlice("alts!", a, 0)
+	if e != nil {
+		return nil, e
+	}
+	hasDefault := len(a) > 1
+	if hasDefault {
+		if kw, _ := NewKeyword("default"); len(a) != 3 || !Eq(a[1], kw) {
+			return nil, NewError(SyntaxError, "alts!", nil, "expected :default val after the operations")
+		}
+	}
+	if len(ops) == 0 && !hasDefault {
+		return nil, NewError(ValueError, "alts!", nil, "no operations to wait for")
+	}
+
+	// Every operation waits on its channel and on the channel being
+	// closed; op maps a select case back to its operation.
+	cases := make([]reflect.SelectCase, 0, 2*len(ops)+1)
+	op := make([]int, 0, cap(cases))
+	chans := make([]*Chan, len(ops))
+	for i, o := range ops {
+		if c, ok := o.(*Chan); ok {
+			chans[i] = c
+			cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(c.C)})
+		} else {
+			pair, e := GetSlice(o)
+			if e != nil || len(pair) != 2 || !IsChan(pair[0]) {
+				return nil, WrongType("alts!", "channel or [channel value]", o)
+			}
+			if pair[1] == nil {
+				return nil, NewError(ValueError, "alts!", nil, "cannot put nil on a channel")
+			}
+			chans[i] = pair[0].(*Chan)
+			cases = append(cases, reflect.SelectCase{Dir: reflect.SelectSend,
+				Chan: reflect.ValueOf(chans[i].C), Send: reflect.ValueOf(&pair[1]).Elem()})
+		}
+		cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(chans[i].Done)})
+		op = append(op, i, i)
+	}
+	if hasDefault {
+		cases = append(cases, reflect.SelectCase{Dir: reflect.SelectDefault})
+	} else {
+		cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(caller.Stop())})
+	}
+
+	chosen, recv, _ := reflect.Select(cases)
+	if chosen == len(op) {
+		if !hasDefault {
+			return nil, interrupted("alts!")
+		}
+		return NewVector(a[2], -1), nil
+	}
+	i := op[chosen]
+	var res Top
+	switch {
+	case cases[chosen].Dir == reflect.SelectSend:
+		res = true
+	case chosen%2 == 0:
+		res = recv.Interface()
+	case IsChan(ops[i]):
+		res, _ = chans[i].Drain()
+	default:
+		res = false
+	}
+	return NewVector(res, i), nil
+}
+
+// expandSelect expands the select macro:
+//
+//	(select
+//	  [v ch] (f v)       ; receive from ch
+//	  [ok ch x] (g ok)   ; send x on ch
+//	  :default (h))      ; when nothing is ready
+//
+// runs the body of the first operation that is ready with its result
+// bound to the symbol, using alts!. The functions the expansion calls
+// are qualified with the core namespace, so that the globals of the
+// namespace it is expanded in cannot shadow them, and its temporaries
+// are gensyms, so that they cannot capture the symbols of the clauses.
+func expandSelect(clauses []Top) (Top, error) {
+	if len(clauses)%2 != 0 {
+		return nil, NewError(SyntaxError, "select", nil, "expected an operation and a body for every clause")
+	}
+	res, idx := NewGensym("res"), NewGensym("idx")
+	kw, _ := NewKeyword("default")
+
+	ops := []Top{}
+	var body Top
+	hasDefault := false
+	for i := len(clauses) - 2; i >= 0; i -= 2 {
+		spec, expr := clauses[i], clauses[i+1]
+		if Eq(spec, kw) {
+			if i != len(clauses)-2 {
+				return nil, NewError(SyntaxError, "select", nil, ":default must be the last clause")
+			}
+			hasDefault, body = true, expr
+			continue
+		}
+		parts, e := GetSlice(spec)
+		if e != nil || !IsVector(spec) || len(parts) < 2 || len(parts) > 3 || !IsSymbol(parts[0]) {
+			return nil, NewError(SyntaxError, "select", spec, "expected [symbol channel] or [symbol channel value]")
+		}
+		op := parts[1]
+		if len(parts) == 3 {
+			op = NewVector(parts[1:]...)
+		}
+		ops = append([]Top{op}, ops...)
+		body = NewList(Symbol{"if"}, NewList(coreSymbol("="), idx, i/2),
+			NewList(Symbol{"let*"}, NewList(parts[0], NewList(coreSymbol("nth"), res, 0)), expr),
+			body)
+	}
+
+	call := []Top{coreSymbol("alts!"), NewVector(ops...)}
+	if hasDefault {
+		call = append(call, kw, nil)
+	}
+	return NewList(Symbol{"let*"}, NewList(res, List{call, nil}),
+		NewList(Symbol{"let*"}, NewList(idx, NewList(coreSymbol("nth"), res, 1)), body)), nil
+}
+
+var concurrentFunctions = map[string]Top{
+	"spawn":   spawn,
+	"future?": predicate("future?", IsFuture),
+	"realized?": func(a []Top) (Top, error) {
+		if e := CheckArity("realized?", a, 1, 1); e != nil {
+			return nil, e
+		}
+		switch x := a[0].(type) {
+		case *Future:
+			return x.Realized(), nil
+		case *Delay:
+			return x.Realized(), nil
+		case *LazySeq:
+			return x.Realized(), nil
+		}
+		return nil, WrongType("realized?", "future, delay or lazy-seq", a[0])
+	},
+	"chan":    makeChan,
+	"chan?":   predicate("chan?", IsChan),
+	">!":      NewBuiltin(put, nil),
+	"<!":      NewBuiltin(take, nil),
+	"close!":  closeChan,
+	"timeout": timeout,
+	"sleep":   NewBuiltin(sleep, nil),
+	"alts!":   NewBuiltin(alts, nil),
+	"select":  Macro{expandSelect, nil},
+}
+
+func init() {
+	for k, v := range concurrentFunctions {
+		GlobalFunctions[k] = v
+	}
+}
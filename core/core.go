@@ -1,23 +1,91 @@
 package core
 
 import (
-	"errors"
-	"fmt"
 	"io/ioutil"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
 import (
 	"github.com/ntaoo/lispgo/printer"
 	"github.com/ntaoo/lispgo/reader"
-	"github.com/ntaoo/lispgo/readline"
 	. "github.com/ntaoo/lispgo/types"
 )
 
+// gensymCounter numbers the symbols made by gensym.
+var gensymCounter int64
+
+// Argument checking
+//
+// Builtins never type-assert their arguments blindly: they check arity
+// and types up front and report problems as runtime errors, so a bad
+// call cannot take the whole process down.
+
+func argString(name string, a []Top, i int) (string, error) {
+	s, ok := a[i].(string)
+	if !ok {
+		return "", WrongType(name, "string", a[i])
+	}
+	return s, nil
+}
+
+func argInt(name string, a []Top, i int) (int, error) {
+	n, ok := a[i].(int)
+	if !ok {
+		return 0, WrongType(name, "integer", a[i])
+	}
+	return n, nil
+}
+
+func argHashMap(name string, a []Top, i int) (HashMap, error) {
+	hm, ok := a[i].(HashMap)
+	if !ok {
+		return HashMap{}, WrongType(name, "hash-map", a[i])
+	}
+	return hm, nil
+}
+
+func argAtom(name string, a []Top, i int) (*Atom, error) {
+	atm, ok := a[i].(*Atom)
+	if !ok {
+		return nil, WrongType(name, "atom", a[i])
+	}
+	return atm, nil
+}
+
+func argFunc(name string, a []Top, i int) (Top, error) {
+	switch a[i].(type) {
+	case Func, Callable, func([]Top) (Top, error):
+		return a[i], nil
+	default:
+		return nil, WrongType(name, "function", a[i])
+	}
+}
+
+// argSlice accepts a list, a vector, a lazy sequence, which it realizes,
+// or nil (the empty sequence).
+func argSlice(name string, a []Top, i int) ([]Top, error) {
+	if a[i] == nil {
+		return nil, nil
+	}
+	if IsLazy(a[i]) {
+		return GetSlice(a[i])
+	}
+	slc, e := GetSlice(a[i])
+	if e != nil {
+		return nil, WrongType(name, "list or vector", a[i])
+	}
+	return slc, nil
+}
+
 // Errors/Exceptions
 func throw(a []Top) (Top, error) {
-	return nil, LGError{a[0]}
+	if e := CheckArity("throw", a, 1, 1); e != nil {
+		return nil, e
+	}
+	return nil, LGError{Obj: a[0]}
 }
 
 func printStr(a []Top) (Top, error) {
@@ -28,130 +96,149 @@ func str(a []Top) (Top, error) {
 	return printer.PrintList(a, false, "", "", ""), nil
 }
 
-func prn(a []Top) (Top, error) {
-	fmt.Println(printer.PrintList(a, true, "", "", " "))
-	return nil, nil
-}
-
-func printLine(a []Top) (Top, error) {
-	fmt.Println(printer.PrintList(a, false, "", "", " "))
-	return nil, nil
-}
-
 func slurp(a []Top) (Top, error) {
-	b, e := ioutil.ReadFile(a[0].(string))
+	if e := CheckArity("slurp", a, 1, 1); e != nil {
+		return nil, e
+	}
+	path, e := argString("slurp", a, 0)
 	if e != nil {
 		return nil, e
 	}
+	b, e := ioutil.ReadFile(path)
+	if e != nil {
+		return nil, NewError(IOError, "slurp", nil, e.Error())
+	}
 	return string(b), nil
 }
 
 // Number functions
 func time_ms(a []Top) (Top, error) {
-	return int(time.Now().UnixNano() / int64(time.Millisecond)), nil
-}
-
-func copyHashMap(hm HashMap) HashMap {
-	new_hm := HashMap{map[string]Top{}, nil}
-	for k, v := range hm.Val {
-		new_hm.Val[k] = v
+	if e := CheckArity("time-ms", a, 0, 0); e != nil {
+		return nil, e
 	}
-	return new_hm
+	return int(time.Now().UnixNano() / int64(time.Millisecond)), nil
 }
 
 func assoc(a []Top) (Top, error) {
-	if len(a) < 3 {
-		return nil, errors.New("assoc requires at least 3 arguments")
+	if e := CheckArity("assoc", a, 3, -1); e != nil {
+		return nil, e
 	}
 	if len(a)%2 != 1 {
-		return nil, errors.New("assoc requires odd number of arguments")
+		return nil, NewError(ArityError, "assoc", nil, "expected a collection and key/value pairs")
 	}
-	if !IsHashMap(a[0]) {
-		return nil, errors.New("assoc called on non-hash map")
+	if vec, ok := a[0].(Vector); ok {
+		return assocVector(vec, a)
+	}
+	hm, e := argHashMap("assoc", a, 0)
+	if e != nil {
+		return nil, WrongType("assoc", "hash-map or vector", a[0])
 	}
-	newHM := copyHashMap(a[0].(HashMap))
 	for i := 1; i < len(a); i += 2 {
-		key := a[i]
-		if !IsString(key) {
-			return nil, errors.New("assoc called with non-string key")
+		hm = hm.Assoc(a[i], a[i+1])
+	}
+	return hm, nil
+}
+
+// assocVector replaces elements of a vector by index; an index equal to
+// the length appends.
+func assocVector(vec Vector, a []Top) (Top, error) {
+	for i := 1; i < len(a); i += 2 {
+		idx, e := argInt("assoc", a, i)
+		if e != nil {
+			return nil, e
+		}
+		if idx < 0 || idx > vec.Len() {
+			return nil, NewErrorf(ValueError, "assoc", "index %d out of range", idx)
 		}
-		newHM.Val[key.(string)] = a[i+1]
+		vec = vec.Assoc(idx, a[i+1])
 	}
-	return newHM, nil
+	return vec, nil
 }
 
 func dissoc(a []Top) (Top, error) {
-	if len(a) < 2 {
-		return nil, errors.New("dissoc requires at least 3 arguments")
+	if e := CheckArity("dissoc", a, 1, -1); e != nil {
+		return nil, e
 	}
-	if !IsHashMap(a[0]) {
-		return nil, errors.New("dissoc called on non-hash map")
+	hm, e := argHashMap("dissoc", a, 0)
+	if e != nil {
+		return nil, e
 	}
-	newHM := copyHashMap(a[0].(HashMap))
 	for i := 1; i < len(a); i += 1 {
-		key := a[i]
-		if !IsString(key) {
-			return nil, errors.New("dissoc called with non-string key")
-		}
-		delete(newHM.Val, key.(string))
+		hm = hm.Dissoc(a[i])
 	}
-	return newHM, nil
+	return hm, nil
 }
 
 func get(a []Top) (Top, error) {
-	if len(a) != 2 {
-		return nil, errors.New("get requires 2 arguments")
+	if e := CheckArity("get", a, 2, 2); e != nil {
+		return nil, e
 	}
 	if IsNil(a[0]) {
 		return nil, nil
 	}
-	if !IsHashMap(a[0]) {
-		return nil, errors.New("get called on non-hash map")
-	}
-	if !IsString(a[1]) {
-		return nil, errors.New("get called with non-string key")
+	hm, e := argHashMap("get", a, 0)
+	if e != nil {
+		return nil, e
 	}
-	return a[0].(HashMap).Val[a[1].(string)], nil
+	val, _ := hm.Get(a[1])
+	return val, nil
 }
 
-func contains_Q(hm Top, key Top) (Top, error) {
-	if IsNil(hm) {
-		return false, nil
+func contains_Q(a []Top) (Top, error) {
+	if e := CheckArity("contains?", a, 2, 2); e != nil {
+		return nil, e
 	}
-	if !IsHashMap(hm) {
-		return nil, errors.New("get called on non-hash map")
+	if IsNil(a[0]) {
+		return false, nil
 	}
-	if !IsString(key) {
-		return nil, errors.New("get called with non-string key")
+	hm, e := argHashMap("contains?", a, 0)
+	if e != nil {
+		return nil, e
 	}
-	_, ok := hm.(HashMap).Val[key.(string)]
+	_, ok := hm.Get(a[1])
 	return ok, nil
 }
 
 func keys(a []Top) (Top, error) {
-	if !IsHashMap(a[0]) {
-		return nil, errors.New("keys called on non-hash map")
+	if e := CheckArity("keys", a, 1, 1); e != nil {
+		return nil, e
 	}
-	slc := []Top{}
-	for k := range a[0].(HashMap).Val {
-		slc = append(slc, k)
+	hm, e := argHashMap("keys", a, 0)
+	if e != nil {
+		return nil, e
 	}
+	slc := make([]Top, 0, hm.Len())
+	hm.Each(func(k Top, v Top) bool {
+		slc = append(slc, k)
+		return true
+	})
 	return List{Val: slc, Meta: nil}, nil
 }
 func vals(a []Top) (Top, error) {
-	if !IsHashMap(a[0]) {
-		return nil, errors.New("keys called on non-hash map")
+	if e := CheckArity("vals", a, 1, 1); e != nil {
+		return nil, e
 	}
-	slc := []Top{}
-	for _, v := range a[0].(HashMap).Val {
-		slc = append(slc, v)
+	hm, e := argHashMap("vals", a, 0)
+	if e != nil {
+		return nil, e
 	}
+	slc := make([]Top, 0, hm.Len())
+	hm.Each(func(k Top, v Top) bool {
+		slc = append(slc, v)
+		return true
+	})
 	return List{slc, nil}, nil
 }
 
 func cons(a []Top) (Top, error) {
+	if e := CheckArity("cons", a, 2, 2); e != nil {
+		return nil, e
+	}
 	val := a[0]
-	lst, e := GetSlice(a[1])
+	if IsLazy(a[1]) {
+		return Cons{val, a[1]}, nil
+	}
+	lst, e := argSlice("cons", a, 1)
 	if e != nil {
 		return nil, e
 	}
@@ -160,44 +247,59 @@ func cons(a []Top) (Top, error) {
 }
 
 func concat(a []Top) (Top, error) {
-	if len(a) == 0 {
-		return List{}, nil
-	}
-	slc1, e := GetSlice(a[0])
-	if e != nil {
-		return nil, e
-	}
-	for i := 1; i < len(a); i += 1 {
-		slc2, e := GetSlice(a[i])
+	slc := []Top{}
+	for i := 0; i < len(a); i += 1 {
+		slc2, e := argSlice("concat", a, i)
 		if e != nil {
 			return nil, e
 		}
-		slc1 = append(slc1, slc2...)
+		slc = append(slc, slc2...)
 	}
-	return List{Val: slc1, Meta: nil}, nil
+	return List{Val: slc, Meta: nil}, nil
 }
 
 func nth(a []Top) (Top, error) {
-	slc, e := GetSlice(a[0])
+	if e := CheckArity("nth", a, 2, 2); e != nil {
+		return nil, e
+	}
+	idx, e := argInt("nth", a, 1)
+	if e != nil {
+		return nil, e
+	}
+	if vec, ok := a[0].(Vector); ok {
+		if idx >= 0 && idx < vec.Len() {
+			return vec.Nth(idx), nil
+		}
+		return nil, NewErrorf(ValueError, "nth", "index %d out of range", idx)
+	}
+	if IsLazy(a[0]) {
+		return lazyNth(a[0], idx)
+	}
+	slc, e := argSlice("nth", a, 0)
 	if e != nil {
 		return nil, e
 	}
-	idx := a[1].(int)
-	if idx < len(slc) {
+	if idx >= 0 && idx < len(slc) {
 		return slc[idx], nil
 	} else {
-		return nil, errors.New("nth: index out of range")
+		return nil, NewErrorf(ValueError, "nth", "index %d out of range", idx)
 	}
 }
 
 func first(a []Top) (Top, error) {
-	if len(a) == 0 {
-		return nil, nil
+	if e := CheckArity("first", a, 1, 1); e != nil {
+		return nil, e
 	}
-	if a[0] == nil {
-		return nil, nil
+	if vec, ok := a[0].(Vector); ok {
+		if vec.Len() == 0 {
+			return nil, nil
+		}
+		return vec.Nth(0), nil
+	}
+	if IsLazy(a[0]) {
+		return First(a[0])
 	}
-	slc, e := GetSlice(a[0])
+	slc, e := argSlice("first", a, 0)
 	if e != nil {
 		return nil, e
 	}
@@ -208,10 +310,13 @@ func first(a []Top) (Top, error) {
 }
 
 func rest(a []Top) (Top, error) {
-	if a[0] == nil {
-		return List{}, nil
+	if e := CheckArity("rest", a, 1, 1); e != nil {
+		return nil, e
 	}
-	slc, e := GetSlice(a[0])
+	if IsLazy(a[0]) {
+		return Rest(a[0])
+	}
+	slc, e := argSlice("rest", a, 0)
 	if e != nil {
 		return nil, e
 	}
@@ -222,73 +327,113 @@ func rest(a []Top) (Top, error) {
 }
 
 func isEmpty(a []Top) (Top, error) {
+	if e := CheckArity("empty?", a, 1, 1); e != nil {
+		return nil, e
+	}
 	switch obj := a[0].(type) {
 	case List:
 		return len(obj.Val) == 0, nil
 	case Vector:
-		return len(obj.Val) == 0, nil
+		return obj.Len() == 0, nil
+	case HashMap:
+		return obj.Len() == 0, nil
+	case *LazySeq, Cons:
+		s, e := Seq(obj)
+		return s == nil, e
 	case nil:
 		return true, nil
 	default:
-		return nil, errors.New("empty? called on non-sequence")
+		return nil, WrongType("empty?", "sequence", a[0])
 	}
 }
 
 func count(a []Top) (Top, error) {
+	if e := CheckArity("count", a, 1, 1); e != nil {
+		return nil, e
+	}
 	switch obj := a[0].(type) {
 	case List:
 		return len(obj.Val), nil
 	case Vector:
-		return len(obj.Val), nil
-	case map[string]Top:
-		return len(obj), nil
+		return obj.Len(), nil
+	case HashMap:
+		return obj.Len(), nil
+	case *LazySeq, Cons:
+		slc, e := GetSlice(obj)
+		return len(slc), e
 	case nil:
 		return 0, nil
 	default:
-		return nil, errors.New("count called on non-sequence")
+		return nil, WrongType("count", "sequence", a[0])
 	}
 }
 
 func apply(a []Top) (Top, error) {
-	if len(a) < 2 {
-		return nil, errors.New("apply requires at least 2 args")
+	if e := CheckArity("apply", a, 2, -1); e != nil {
+		return nil, e
 	}
 	f := a[0]
 	args := []Top{}
 	for _, b := range a[1 : len(a)-1] {
 		args = append(args, b)
 	}
-	last, e := GetSlice(a[len(a)-1])
+	last, e := argSlice("apply", a, len(a)-1)
 	if e != nil {
 		return nil, e
 	}
 	args = append(args, last...)
-	return Apply(f, args)
+	return TailCall{f, args}, nil
 }
 
-func mapFunc(a []Top) (Top, error) {
-	if len(a) != 2 {
-		return nil, errors.New("map requires 2 args")
+func callCC(c Caller, a []Top) (Top, error) {
+	if e := CheckArity("call/cc", a, 1, 1); e != nil {
+		return nil, e
+	}
+	f, e := argFunc("call/cc", a, 0)
+	if e != nil {
+		return nil, e
+	}
+	return CallCC(c, f)
+}
+
+func dynamicWind(c Caller, a []Top) (Top, error) {
+	if e := CheckArity("dynamic-wind", a, 3, 3); e != nil {
+		return nil, e
+	}
+	for i := range a {
+		if _, e := argFunc("dynamic-wind", a, i); e != nil {
+			return nil, e
+		}
+	}
+	return DynamicWind(c, a[0], a[1], a[2])
+}
+
+func mapFunc(c Caller, a []Top) (Top, error) {
+	if e := CheckArity("map", a, 2, 2); e != nil {
+		return nil, e
 	}
 	f := a[0]
+	if IsLazy(a[1]) {
+		return lazyMap(c.Later(), f, a[1]), nil
+	}
 	results := []Top{}
-	args, e := GetSlice(a[1])
+	args, e := argSlice("map", a, 1)
 	if e != nil {
 		return nil, e
 	}
 	for _, arg := range args {
-		res, e := Apply(f, []Top{arg})
-		results = append(results, res)
+		res, e := c.Apply(f, []Top{arg})
 		if e != nil {
 			return nil, e
 		}
+		results = append(results, res)
 	}
 	return List{Val: results, Meta: nil}, nil
 }
 
 func conj(a []Top) (Top, error) {
-	if len(a) < 2 {
-		return nil, errors.New("conj requires at least 2 arguments")
+	if e := CheckArity("conj", a, 2, -1); e != nil {
+		return nil, e
 	}
 	switch seq := a[0].(type) {
 	case List:
@@ -298,42 +443,61 @@ func conj(a []Top) (Top, error) {
 		}
 		return List{append(new_slc, seq.Val...), nil}, nil
 	case Vector:
-		new_slc := seq.Val
-		for _, x := range a[1:] {
-			new_slc = append(new_slc, x)
+		return seq.Conj(a[1:]...), nil
+	case *LazySeq, Cons:
+		var res Top = seq
+		for i := 1; i < len(a); i += 1 {
+			res = Cons{a[i], res}
 		}
-		return Vector{new_slc, nil}, nil
-	}
-
-	if !IsHashMap(a[0]) {
-		return nil, errors.New("dissoc called on non-hash map")
-	}
-	new_hm := copyHashMap(a[0].(HashMap))
-	for i := 1; i < len(a); i += 1 {
-		key := a[i]
-		if !IsString(key) {
-			return nil, errors.New("dissoc called with non-string key")
+		return res, nil
+	case HashMap:
+		// Entries are [key value] vectors or whole hash-maps.
+		new_hm := seq
+		for i := 1; i < len(a); i += 1 {
+			switch entry := a[i].(type) {
+			case Vector:
+				if entry.Len() != 2 {
+					return nil, NewError(ValueError, "conj", nil, "hash-map entry must be a [key value] vector")
+				}
+				new_hm = new_hm.Assoc(entry.Nth(0), entry.Nth(1))
+			case HashMap:
+				entry.Each(func(k Top, v Top) bool {
+					new_hm = new_hm.Assoc(k, v)
+					return true
+				})
+			default:
+				return nil, WrongType("conj", "[key value] vector or hash-map", a[i])
+			}
 		}
-		delete(new_hm.Val, key.(string))
+		return new_hm, nil
+	default:
+		return nil, WrongType("conj", "list, vector or hash-map", a[0])
 	}
-	return new_hm, nil
 }
 
 func seq(a []Top) (Top, error) {
-	if a[0] == nil {
-		return nil, nil
+	if e := CheckArity("seq", a, 1, 1); e != nil {
+		return nil, e
 	}
 	switch arg := a[0].(type) {
+	case nil:
+		return nil, nil
 	case List:
 		if len(arg.Val) == 0 {
 			return nil, nil
 		}
 		return arg, nil
 	case Vector:
-		if len(arg.Val) == 0 {
+		if arg.Len() == 0 {
 			return nil, nil
 		}
-		return List{Val: arg.Val, Meta: nil}, nil
+		return List{Val: arg.Slice(), Meta: nil}, nil
+	case *LazySeq, Cons:
+		s, e := Seq(arg)
+		if vec, ok := s.(Vector); ok {
+			return List{Val: vec.Slice(), Meta: nil}, e
+		}
+		return s, e
 	case string:
 		if len(arg) == 0 {
 			return nil, nil
@@ -344,13 +508,13 @@ func seq(a []Top) (Top, error) {
 		}
 		return List{Val: newSlc, Meta: nil}, nil
 	}
-	return nil, errors.New("seq requires string or list or vector or nil")
+	return nil, WrongType("seq", "string, list, vector, lazy-seq or nil", a[0])
 }
 
 // Metadata functions
 func with_meta(a []Top) (Top, error) {
-	if len(a) != 2 {
-		return nil, errors.New("with-meta requires 2 args")
+	if e := CheckArity("with-meta", a, 2, 2); e != nil {
+		return nil, e
 	}
 	obj := a[0]
 	m := a[1]
@@ -358,21 +522,24 @@ func with_meta(a []Top) (Top, error) {
 	case List:
 		return List{tobj.Val, m}, nil
 	case Vector:
-		return Vector{tobj.Val, m}, nil
+		tobj.Meta = m
+		return tobj, nil
 	case HashMap:
-		return HashMap{tobj.Val, m}, nil
+		tobj.Meta = m
+		return tobj, nil
 	case Func:
-		return Func{tobj.Fn, m}, nil
-	case MalFunc:
-		fn := tobj
-		fn.Meta = m
-		return fn, nil
+		return NewFunc(tobj.Fn, m), nil
+	case Callable:
+		return tobj.WithMeta(m), nil
 	default:
-		return nil, errors.New("with-meta not supported on type")
+		return nil, WrongType("with-meta", "collection or function", obj)
 	}
 }
 
 func meta(a []Top) (Top, error) {
+	if e := CheckArity("meta", a, 1, 1); e != nil {
+		return nil, e
+	}
 	obj := a[0]
 	switch tobj := obj.(type) {
 	case List:
@@ -383,171 +550,226 @@ func meta(a []Top) (Top, error) {
 		return tobj.Meta, nil
 	case Func:
 		return tobj.Meta, nil
-	case MalFunc:
-		return tobj.Meta, nil
+	case Callable:
+		return tobj.GetMeta(), nil
 	default:
-		return nil, errors.New("meta not supported on type")
+		return nil, WrongType("meta", "collection or function", obj)
 	}
 }
 
 // Atom functions
-func deref(a []Top) (Top, error) {
-	if !IsAtom(a[0]) {
-		return nil, errors.New("deref called with non-atom")
+
+// deref reads an atom, waits for a future, see derefFuture, or forces a
+// delay.
+func deref(c Caller, a []Top) (Top, error) {
+	if e := CheckArity("deref", a, 1, 3); e != nil {
+		return nil, e
+	}
+	if fut, ok := a[0].(*Future); ok {
+		return derefFuture(c.Stop(), fut, a)
 	}
-	return a[0].(*Atom).Val, nil
+	if d, ok := a[0].(*Delay); ok {
+		if e := CheckArity("deref", a, 1, 1); e != nil {
+			return nil, e
+		}
+		return d.Force(c)
+	}
+	atm, ok := a[0].(*Atom)
+	if !ok {
+		return nil, WrongType("deref", "atom, future or delay", a[0])
+	}
+	if e := CheckArity("deref", a, 1, 1); e != nil {
+		return nil, e
+	}
+	return atm.Deref(), nil
 }
 
-func reset_BANG(a []Top) (Top, error) {
-	if !IsAtom(a[0]) {
-		return nil, errors.New("reset! called with non-atom")
+func reset_BANG(c Caller, a []Top) (Top, error) {
+	if e := CheckArity("reset!", a, 2, 2); e != nil {
+		return nil, e
 	}
-	a[0].(*Atom).Set(a[1])
-	return a[1], nil
+	atm, e := argAtom("reset!", a, 0)
+	if e != nil {
+		return nil, e
+	}
+	return atm.Set(c, a[1])
 }
 
-func swap_BANG(a []Top) (Top, error) {
-	if !IsAtom(a[0]) {
-		return nil, errors.New("swap! called with non-atom")
+func swap_BANG(c Caller, a []Top) (Top, error) {
+	if e := CheckArity("swap!", a, 2, -1); e != nil {
+		return nil, e
 	}
-	if len(a) < 2 {
-		return nil, errors.New("swap! requires at least 2 args")
+	atm, e := argAtom("swap!", a, 0)
+	if e != nil {
+		return nil, e
 	}
-	atm := a[0].(*Atom)
-	args := []Top{atm.Val}
 	f := a[1]
-	args = append(args, a[2:]...)
-	res, e := Apply(f, args)
+	return atm.Swap(c, func(val Top) (Top, error) {
+		args := []Top{val}
+		args = append(args, a[2:]...)
+		return c.Apply(f, args)
+	})
+}
+
+func compare_and_set_BANG(c Caller, a []Top) (Top, error) {
+	if e := CheckArity("compare-and-set!", a, 3, 3); e != nil {
+		return nil, e
+	}
+	atm, e := argAtom("compare-and-set!", a, 0)
+	if e != nil {
+		return nil, e
+	}
+	return atm.CompareAndSet(c, a[1], a[2])
+}
+
+func add_watch(a []Top) (Top, error) {
+	if e := CheckArity("add-watch", a, 3, 3); e != nil {
+		return nil, e
+	}
+	atm, e := argAtom("add-watch", a, 0)
 	if e != nil {
 		return nil, e
 	}
-	atm.Set(res)
-	return res, nil
+	switch a[2].(type) {
+	case Func, Callable:
+	default:
+		return nil, WrongType("add-watch", "function", a[2])
+	}
+	atm.AddWatch(a[1], a[2])
+	return atm, nil
+}
+
+func remove_watch(a []Top) (Top, error) {
+	if e := CheckArity("remove-watch", a, 2, 2); e != nil {
+		return nil, e
+	}
+	atm, e := argAtom("remove-watch", a, 0)
+	if e != nil {
+		return nil, e
+	}
+	atm.RemoveWatch(a[1])
+	return atm, nil
+}
+
+// Namespace is the name of the namespace the builtins are defined in.
+const Namespace = "lispgo.core"
+
+// coreSymbol returns the symbol naming the builtin name in Namespace,
+// for the expansions of macros written in Go.
+func coreSymbol(name string) Symbol {
+	return Symbol{Namespace + "/" + name}
 }
 
 var GlobalFunctions = map[string]Top{
-	"=": func(a []Top) (Top, error) {
-		return Eq(a[0], a[1]), nil
-	},
-	"throw": throw,
-	"nil?": func(a []Top) (Top, error) {
-		return IsNil(a[0]), nil
-	},
-	"true?": func(a []Top) (Top, error) {
-		return IsTrue(a[0]), nil
-	},
-	"false?": func(a []Top) (Top, error) {
-		return IsFalse(a[0]), nil
-	},
+	"throw":    throw,
+	"nil?":     predicate("nil?", IsNil),
+	"true?":    predicate("true?", IsTrue),
+	"false?":   predicate("false?", IsFalse),
+	"symbol?":  predicate("symbol?", IsSymbol),
+	"keyword?": predicate("keyword?", IsKeyword),
 	"symbol": func(a []Top) (Top, error) {
-		return Symbol{a[0].(string)}, nil
-	},
-	"symbol?": func(a []Top) (Top, error) {
-		return IsSymbol(a[0]), nil
+		if e := CheckArity("symbol", a, 1, 1); e != nil {
+			return nil, e
+		}
+		name, e := argString("symbol", a, 0)
+		if e != nil {
+			return nil, e
+		}
+		return Symbol{name}, nil
 	},
-	"string?": func(a []Top) (Top, error) {
-		return (IsString(a[0]) && !IsKeyword(a[0])), nil
+	"gensym": func(a []Top) (Top, error) {
+		if e := CheckArity("gensym", a, 0, 0); e != nil {
+			return nil, e
+		}
+		return Symbol{"G__" + strconv.FormatInt(atomic.AddInt64(&gensymCounter, 1), 10)}, nil
 	},
+	"string?": predicate("string?", IsString),
 	"keyword": func(a []Top) (Top, error) {
+		if e := CheckArity("keyword", a, 1, 1); e != nil {
+			return nil, e
+		}
 		if IsKeyword(a[0]) {
 			return a[0], nil
-		} else {
-			return NewKeyword(a[0].(string))
 		}
-	},
-	"keyword?": func(a []Top) (Top, error) {
-		return IsKeyword(a[0]), nil
+		name, e := argString("keyword", a, 0)
+		if e != nil {
+			return nil, e
+		}
+		return NewKeyword(name)
 	},
 
-	"pr-str":    func(a []Top) (Top, error) { return printStr(a) },
-	"str":       func(a []Top) (Top, error) { return str(a) },
-	"prn":       func(a []Top) (Top, error) { return prn(a) },
-	"printLine": func(a []Top) (Top, error) { return printLine(a) },
+	"pr-str": func(a []Top) (Top, error) { return printStr(a) },
+	"str":    func(a []Top) (Top, error) { return str(a) },
 	"read-string": func(a []Top) (Top, error) {
-		return reader.Read_str(a[0].(string))
+		if e := CheckArity("read-string", a, 1, 2); e != nil {
+			return nil, e
+		}
+		s, e := argString("read-string", a, 0)
+		if e != nil {
+			return nil, e
+		}
+		file := ""
+		if len(a) == 2 {
+			if file, e = argString("read-string", a, 1); e != nil {
+				return nil, e
+			}
+		}
+		return reader.Read_str_file(s, file)
 	},
 	"slurp": slurp,
-	"readline": func(a []Top) (Top, error) {
-		return readline.Readline(a[0].(string))
-	},
 
-	"<": func(a []Top) (Top, error) {
-		return a[0].(int) < a[1].(int), nil
-	},
-	"<=": func(a []Top) (Top, error) {
-		return a[0].(int) <= a[1].(int), nil
-	},
-	">": func(a []Top) (Top, error) {
-		return a[0].(int) > a[1].(int), nil
-	},
-	">=": func(a []Top) (Top, error) {
-		return a[0].(int) >= a[1].(int), nil
-	},
-	"+": func(a []Top) (Top, error) {
-		return a[0].(int) + a[1].(int), nil
-	},
-	"-": func(a []Top) (Top, error) {
-		return a[0].(int) - a[1].(int), nil
-	},
-	"*": func(a []Top) (Top, error) {
-		return a[0].(int) * a[1].(int), nil
-	},
-	"/": func(a []Top) (Top, error) {
-		return a[0].(int) / a[1].(int), nil
-	},
 	"time-ms": time_ms,
 
 	"list": func(a []Top) (Top, error) {
 		return List{a, nil}, nil
 	},
-	"list?": func(a []Top) (Top, error) {
-		return IsList(a[0]), nil
-	},
+	"list?": predicate("list?", IsList),
 	"vector": func(a []Top) (Top, error) {
-		return Vector{a, nil}, nil
-	},
-	"vector?": func(a []Top) (Top, error) {
-		return IsVector(a[0]), nil
+		return NewVector(a...), nil
 	},
+	"vector?": predicate("vector?", IsVector),
 	"hash-map": func(a []Top) (Top, error) {
 		return NewHashMap(List{a, nil})
 	},
-	"map?": func(a []Top) (Top, error) {
-		return IsHashMap(a[0]), nil
-	},
-	"assoc":  assoc,
-	"dissoc": dissoc,
-	"get":    get,
-	"contains?": func(a []Top) (Top, error) {
-		return contains_Q(a[0], a[1])
-	},
-	"keys": keys,
-	"vals": vals,
-
-	"sequential?": func(a []Top) (Top, error) {
-		return IsSeq(a[0]), nil
-	},
-	"cons":   cons,
-	"concat": concat,
-	"nth":    nth,
-	"first":  first,
-	"rest":   rest,
-	"empty?": isEmpty,
-	"count":  count,
-	"apply":  apply,
-	"map":    mapFunc,
-	"conj":   conj,
-	"seq":    seq,
+	"map?":      predicate("map?", IsHashMap),
+	"assoc":     assoc,
+	"dissoc":    dissoc,
+	"get":       get,
+	"contains?": contains_Q,
+	"keys":      keys,
+	"vals":      vals,
+
+	"sequential?": predicate("sequential?", IsSeq),
+	"cons":        cons,
+	"concat":      concat,
+	"nth":         nth,
+	"first":       first,
+	"rest":        rest,
+	"empty?":      isEmpty,
+	"count":       count,
+	"apply":       apply,
+	"map":         NewBuiltin(mapFunc, nil),
+	"conj":        conj,
+	"seq":         seq,
+
+	"call/cc":                        NewBuiltin(callCC, nil),
+	"call-with-current-continuation": NewBuiltin(callCC, nil),
+	"dynamic-wind":                   NewBuiltin(dynamicWind, nil),
 
 	"with-meta": with_meta,
 	"meta":      meta,
 	"atom": func(a []Top) (Top, error) {
-		return &Atom{a[0], nil}, nil
-	},
-	"atom?": func(a []Top) (Top, error) {
-		return IsAtom(a[0]), nil
+		if e := CheckArity("atom", a, 1, 1); e != nil {
+			return nil, e
+		}
+		return NewAtom(a[0]), nil
 	},
-	"deref":  deref,
-	"reset!": reset_BANG,
-	"swap!":  swap_BANG,
+	"atom?":  predicate("atom?", IsAtom),
+	"deref":  NewBuiltin(deref, nil),
+	"reset!": NewBuiltin(reset_BANG, nil),
+	"swap!":  NewBuiltin(swap_BANG, nil),
+
+	"compare-and-set!": NewBuiltin(compare_and_set_BANG, nil),
+	"add-watch":        add_watch,
+	"remove-watch":     remove_watch,
 }
@@ -0,0 +1,22 @@
+package core
+
+import (
+	"testing"
+)
+
+import (
+	. "github.com/ntaoo/lispgo/types"
+)
+
+func TestBuiltinErrorsAreStructured(t *testing.T) {
+	_, e := GlobalFunctions["nth"].(func([]Top) (Top, error))([]Top{List{}, "a"})
+	lge, ok := e.(LGError)
+	if !ok {
+		t.Fatalf("expected LGError, got %#v", e)
+	}
+	kind, _ := ErrorField(lge.Obj, "kind")
+	builtin, _ := ErrorField(lge.Obj, "builtin")
+	if kind != InternKeyword(TypeError) || builtin != "nth" {
+		t.Errorf("unexpected error value: %#v", lge.Obj)
+	}
+}
@@ -0,0 +1,130 @@
+package core
+
+// Doc documents a builtin for the doc function: the parameters it takes,
+// written as those of a lambda, and what it does.
+type Doc struct {
+	Args string
+	Text string
+}
+
+// Docs documents the builtins of GlobalFunctions by name.
+var Docs = map[string]Doc{
+	"throw":    {"[x]", "Raises x as an error, for try* to catch."},
+	"nil?":     {"[x]", "Returns true if x is nil."},
+	"true?":    {"[x]", "Returns true if x is true."},
+	"false?":   {"[x]", "Returns true if x is false."},
+	"symbol?":  {"[x]", "Returns true if x is a symbol."},
+	"keyword?": {"[x]", "Returns true if x is a keyword."},
+	"string?":  {"[x]", "Returns true if x is a string."},
+	"symbol":   {"[name]", "Returns the symbol named by the string name."},
+	"keyword":  {"[name]", "Returns the keyword named by the string name, or name if it is one."},
+	"gensym":   {"[]", "Returns a new symbol, unlike any other, for macros to bind."},
+
+	"pr-str":      {"[& xs]", "Prints xs readably, separated by spaces, to a string."},
+	"str":         {"[& xs]", "Prints xs for display, one after the other, to a string."},
+	"prn":         {"[& xs]", "Prints xs readably, separated by spaces, and a newline."},
+	"printLine":   {"[& xs]", "Prints xs for display, separated by spaces, and a newline."},
+	"readline":    {"[prompt]", "Prints prompt and reads a line, without its newline. Returns nil at the end of input."},
+	"read-string": {"[s file]", "Reads the first form of the string s. The optional file name is given to the positions of what is read."},
+	"slurp":       {"[path]", "Returns the contents of the file at path as a string."},
+	"time-ms":     {"[]", "Returns the current time in milliseconds since the Unix epoch."},
+
+	"list":        {"[& xs]", "Returns a list of xs."},
+	"list?":       {"[x]", "Returns true if x is a list."},
+	"vector":      {"[& xs]", "Returns a vector of xs."},
+	"vector?":     {"[x]", "Returns true if x is a vector."},
+	"hash-map":    {"[& kvs]", "Returns a hash-map of the keys and values kvs, which alternate."},
+	"map?":        {"[x]", "Returns true if x is a hash-map."},
+	"assoc":       {"[coll k v & kvs]", "Returns coll, a hash-map, with the keys k mapped to the values v, or coll, a vector, with the elements at the indexes k replaced by v. The index after the last element appends."},
+	"dissoc":      {"[m & ks]", "Returns the hash-map m without the keys ks."},
+	"get":         {"[m k]", "Returns the value of the key k in the hash-map m, or nil if it has none or m is nil."},
+	"contains?":   {"[m k]", "Returns true if the hash-map m has the key k."},
+	"keys":        {"[m]", "Returns a list of the keys of the hash-map m."},
+	"vals":        {"[m]", "Returns a list of the values of the hash-map m."},
+	"sequential?": {"[x]", "Returns true if x is a list or a vector."},
+
+	"cons":   {"[x coll]", "Returns coll with x in front. It is lazy if coll is."},
+	"concat": {"[& colls]", "Returns a list of the elements of colls, one after the other."},
+	"nth":    {"[coll i]", "Returns the element of coll at index i, counting from 0."},
+	"first":  {"[coll]", "Returns the first element of coll, or nil if it is empty."},
+	"rest":   {"[coll]", "Returns coll without its first element."},
+	"empty?": {"[coll]", "Returns true if coll has no elements."},
+	"count":  {"[coll]", "Returns the number of elements of coll. A lazy sequence is realized to count it."},
+	"apply":  {"[f & args coll]", "Calls f with args followed by the elements of coll as its arguments."},
+	"map":    {"[f coll]", "Returns a list of the values of f called on each element of coll, or a lazy sequence of them if coll is lazy."},
+	"conj":   {"[coll & xs]", "Returns coll with xs added where they go fastest: in front of a list or lazy sequence, at the end of a vector. To a hash-map, xs are [key value] vectors or hash-maps to merge."},
+	"seq":    {"[coll]", "Returns the elements of coll, or the characters of a string, as a list, or nil if there are none."},
+
+	"call/cc":                        {"[f]", "Calls f with the current continuation, a function that returns its argument from call/cc when called."},
+	"call-with-current-continuation": {"[f]", "Calls f with the current continuation, a function that returns its argument from call/cc when called."},
+	"dynamic-wind":                   {"[before thunk after]", "Calls before, then thunk, then after, also when thunk is left by an error or a continuation. Returns the value of thunk."},
+
+	"with-meta": {"[x m]", "Returns x, a collection or function, with the metadata m."},
+	"meta":      {"[x]", "Returns the metadata of x, a collection or function."},
+
+	"atom":             {"[x]", "Returns a new atom holding x."},
+	"atom?":            {"[x]", "Returns true if x is an atom."},
+	"deref":            {"[ref ms val]", "Returns the value of an atom, forces a delay, or waits for a future and returns its value. Given ms and val, it waits for a future at most ms milliseconds and then returns val."},
+	"reset!":           {"[atom x]", "Sets the value of atom to x and returns x."},
+	"swap!":            {"[atom f & args]", "Sets the value of atom to (f value args ...), retrying f if another goroutine changed it meanwhile, and returns the new value."},
+	"compare-and-set!": {"[atom old new]", "Sets the value of atom to new if it is old, the same object rather than an equal one. Returns true if it did."},
+	"add-watch":        {"[atom key f]", "Calls (f key atom old new) after every change of atom, replacing any watch with the same key."},
+	"remove-watch":     {"[atom key]", "Removes the watch with key from atom."},
+
+	"+":         {"[& xs]", "Returns the sum of xs."},
+	"-":         {"[x & xs]", "Returns x minus xs, or x negated."},
+	"*":         {"[& xs]", "Returns the product of xs."},
+	"/":         {"[x & xs]", "Returns x divided by xs, or 1 divided by x. Exact numbers give an exact ratio."},
+	"=":         {"[x & xs]", "Returns true if every argument equals the next: numerically for numbers, so (= 1 1.0), and by structure for everything else."},
+	"<":         {"[x & xs]", "Returns true if every number is less than the next."},
+	"<=":        {"[x & xs]", "Returns true if every number is at most the next."},
+	">":         {"[x & xs]", "Returns true if every number is greater than the next."},
+	">=":        {"[x & xs]", "Returns true if every number is at least the next."},
+	"min":       {"[x & xs]", "Returns the least of the numbers."},
+	"max":       {"[x & xs]", "Returns the greatest of the numbers."},
+	"quotient":  {"[n d]", "Divides the integer n by d, rounding towards zero."},
+	"remainder": {"[n d]", "Returns the remainder of dividing the integer n by d, with the sign of n."},
+	"modulo":    {"[n d]", "Returns n modulo the integer d, with the sign of d."},
+	"expt":      {"[base power]", "Returns base raised to power, exactly if both are exact and power is an integer."},
+	"abs":       {"[x]", "Returns the absolute value of x."},
+	"sqrt":      {"[x]", "Returns the square root of x, exactly for an exact perfect square."},
+	"floor":     {"[x]", "Rounds x down to an integer."},
+	"ceiling":   {"[x]", "Rounds x up to an integer."},
+	"round":     {"[x]", "Rounds x to the nearest integer, ties to even."},
+	"truncate":  {"[x]", "Rounds x towards zero to an integer."},
+	"exp":       {"[x]", "Returns e raised to x."},
+	"log":       {"[x base]", "Returns the natural logarithm of x, or its logarithm to base."},
+	"sin":       {"[x]", "Returns the sine of x, in radians."},
+	"cos":       {"[x]", "Returns the cosine of x, in radians."},
+	"tan":       {"[x]", "Returns the tangent of x, in radians."},
+	"asin":      {"[x]", "Returns the arc sine of x, in radians."},
+	"acos":      {"[x]", "Returns the arc cosine of x, in radians."},
+	"atan":      {"[y x]", "Returns the arc tangent of y, or of y/x using the signs of both to find the quadrant, in radians."},
+
+	"exact->inexact": {"[x]", "Returns x as a float."},
+	"inexact->exact": {"[x]", "Returns x as an exact number."},
+	"number?":        {"[x]", "Returns true if x is a number."},
+	"integer?":       {"[x]", "Returns true if x is an integer."},
+	"rational?":      {"[x]", "Returns true if x is an exact number."},
+	"float?":         {"[x]", "Returns true if x is a float."},
+	"zero?":          {"[x]", "Returns true if the number x is zero."},
+
+	"lazy-seq*": {"[f]", "Returns a lazy sequence of the elements of the sequence f returns when it is first needed. The lazy-seq macro wraps its body in f."},
+	"lazy-seq?": {"[x]", "Returns true if x is a lazy sequence."},
+	"delay*":    {"[f]", "Returns a delay that calls f when it is first forced, and keeps its value. The delay macro wraps its body in f."},
+	"delay?":    {"[x]", "Returns true if x is a delay."},
+	"force":     {"[x]", "Returns the value of x if it is a delay, and x otherwise."},
+	"realized?": {"[x]", "Returns true if the future, delay or lazy sequence x has its value."},
+
+	"spawn":   {"[f & args]", "Calls f with args in a new goroutine and returns a future of its result, for deref to wait for. The go macro wraps its body in f."},
+	"future?": {"[x]", "Returns true if x is a future."},
+	"chan":    {"[size]", "Returns a new channel, buffering size values, or none."},
+	"chan?":   {"[x]", "Returns true if x is a channel."},
+	">!":      {"[ch x]", "Sends x, which must not be nil, on the channel ch, waiting until it is taken or buffered. Returns false if ch is closed."},
+	"<!":      {"[ch]", "Receives a value from the channel ch, waiting for one. Returns nil once ch is closed."},
+	"close!":  {"[ch]", "Closes the channel ch, waking those waiting on it."},
+	"timeout": {"[ms]", "Returns a channel that closes after ms milliseconds."},
+	"sleep":   {"[ms]", "Waits ms milliseconds."},
+	"alts!":   {"[ops & opts]", "Performs whichever of the channel operations ops is ready first: a channel to receive from or a [channel value] pair to send. Returns [result index], where result is the value received or whether it was sent. With :default val it does not wait, and returns [val -1] if none is ready."},
+	"select":  {"[clauses ...]", "Runs the body of the first clause whose channel operation is ready, as alts! does. A clause is [v ch] body, receiving v from ch, [ok ch x] body, sending x on ch, or :default body, run when nothing is ready."},
+}
@@ -0,0 +1,66 @@
+package core
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+import (
+	"github.com/ntaoo/lispgo/printer"
+	"github.com/ntaoo/lispgo/readline"
+	. "github.com/ntaoo/lispgo/types"
+)
+
+// IOFunctions returns the builtins that read from and write to the
+// terminal, bound to in and out so that every interpreter can have its
+// own streams. A nil in reads lines with the readline package.
+func IOFunctions(in io.Reader, out io.Writer) map[string]Top {
+	readLine := readline.Readline
+	if in != nil {
+		buf := bufio.NewReader(in)
+		readLine = func(prompt string) (string, error) {
+			fmt.Fprint(out, prompt)
+			line, e := buf.ReadString('\n')
+			if e == io.EOF && line != "" {
+				e = nil
+			}
+			return strings.TrimRight(line, "\r\n"), e
+		}
+	}
+	return map[string]Top{
+		"prn": func(a []Top) (Top, error) {
+			fmt.Fprintln(out, printer.PrintList(a, true, "", "", " "))
+			return nil, nil
+		},
+		"printLine": func(a []Top) (Top, error) {
+			fmt.Fprintln(out, printer.PrintList(a, false, "", "", " "))
+			return nil, nil
+		},
+		"readline": func(a []Top) (Top, error) {
+			if e := CheckArity("readline", a, 1, 1); e != nil {
+				return nil, e
+			}
+			prompt, e := argString("readline", a, 0)
+			if e != nil {
+				return nil, e
+			}
+			line, e := readLine(prompt)
+			if e == io.EOF {
+				return nil, nil
+			}
+			if e != nil {
+				return nil, NewError(IOError, "readline", nil, e.Error())
+			}
+			return line, nil
+		},
+	}
+}
+
+func init() {
+	for k, v := range IOFunctions(nil, os.Stdout) {
+		GlobalFunctions[k] = v
+	}
+}
@@ -0,0 +1,107 @@
+package core
+
+import (
+	. "github.com/ntaoo/lispgo/types"
+)
+
+// Lazy sequences and delays
+//
+// lazy-seq* and delay* take a function of no arguments; the lazy-seq
+// and delay macros of the prelude wrap their body in one. map is lazy
+// when the sequence it maps is, and eager otherwise, so mapping a list
+// for its side effects still runs them at once. A lazy sequence is
+// realized wherever it is first looked at, which may be in Go, so its
+// function is called back later from where the sequence was made.
+
+// later returns f as a function that calls it back later from c.
+func later(c Caller, f Top) Func {
+	c = c.Later()
+	return NewFunc(func(a []Top) (Top, error) {
+		return c.Apply(f, a)
+	}, nil)
+}
+
+// lazyMap is (map f coll) for a lazy coll, calling f back from c.
+func lazyMap(c Caller, f Top, coll Top) *LazySeq {
+	return NewLazySeq(func(a []Top) (Top, error) {
+		s, e := Seq(coll)
+		if e != nil || s == nil {
+			return nil, e
+		}
+		x, e := First(s)
+		if e != nil {
+			return nil, e
+		}
+		y, e := c.Apply(f, []Top{x})
+		if e != nil {
+			return nil, e
+		}
+		more, e := Rest(s)
+		if e != nil {
+			return nil, e
+		}
+		return Cons{y, lazyMap(c, f, more)}, nil
+	})
+}
+
+// lazyNth walks a lazy sequence to its idx-th element, realizing no
+// more of it than that.
+func lazyNth(coll Top, idx int) (Top, error) {
+	for i := 0; idx >= 0; i += 1 {
+		s, e := Seq(coll)
+		if e != nil {
+			return nil, e
+		}
+		if s == nil {
+			break
+		}
+		if i == idx {
+			return First(s)
+		}
+		if coll, e = Rest(s); e != nil {
+			return nil, e
+		}
+	}
+	return nil, NewErrorf(ValueError, "nth", "index %d out of range", idx)
+}
+
+var lazyFunctions = map[string]Top{
+	"lazy-seq*": NewBuiltin(func(c Caller, a []Top) (Top, error) {
+		if e := CheckArity("lazy-seq*", a, 1, 1); e != nil {
+			return nil, e
+		}
+		f, e := argFunc("lazy-seq*", a, 0)
+		if e != nil {
+			return nil, e
+		}
+		return NewLazySeq(later(c, f)), nil
+	}, nil),
+	"lazy-seq?": predicate("lazy-seq?", IsLazy),
+	"delay*": func(a []Top) (Top, error) {
+		if e := CheckArity("delay*", a, 1, 1); e != nil {
+			return nil, e
+		}
+		f, e := argFunc("delay*", a, 0)
+		if e != nil {
+			return nil, e
+		}
+		return NewDelay(f), nil
+	},
+	"delay?": predicate("delay?", IsDelay),
+	// force returns the value of a delay and anything else as it is.
+	"force": NewBuiltin(func(c Caller, a []Top) (Top, error) {
+		if e := CheckArity("force", a, 1, 1); e != nil {
+			return nil, e
+		}
+		if d, ok := a[0].(*Delay); ok {
+			return d.Force(c)
+		}
+		return a[0], nil
+	}, nil),
+}
+
+func init() {
+	for k, v := range lazyFunctions {
+		GlobalFunctions[k] = v
+	}
+}
@@ -0,0 +1,257 @@
+package core
+
+import (
+	"math"
+)
+
+import (
+	. "github.com/ntaoo/lispgo/types"
+)
+
+// Arithmetic
+//
+// The arithmetic builtins follow Scheme: they take any number of
+// arguments, `-` and `/` negate or invert a single argument and the
+// comparisons are chained, so (< 1 2 3) checks every adjacent pair.
+
+func checkNumbers(name string, a []Top) error {
+	for _, x := range a {
+		if !IsNumber(x) {
+			return WrongType(name, "number", x)
+		}
+	}
+	return nil
+}
+
+func fold(name string, a []Top, acc Top, op func(Top, Top) (Top, error)) (Top, error) {
+	if e := checkNumbers(name, a); e != nil {
+		return nil, e
+	}
+	var e error
+	for _, x := range a {
+		if acc, e = op(acc, x); e != nil {
+			return nil, e
+		}
+	}
+	return acc, nil
+}
+
+func add(a []Top) (Top, error) {
+	return fold("+", a, 0, NumAdd)
+}
+
+func mul(a []Top) (Top, error) {
+	return fold("*", a, 1, NumMul)
+}
+
+func sub(a []Top) (Top, error) {
+	if e := CheckArity("-", a, 1, -1); e != nil {
+		return nil, e
+	}
+	if len(a) == 1 {
+		return fold("-", a, 0, NumSub)
+	}
+	return fold("-", a[1:], a[0], NumSub)
+}
+
+func div(a []Top) (Top, error) {
+	if e := CheckArity("/", a, 1, -1); e != nil {
+		return nil, e
+	}
+	if len(a) == 1 {
+		return fold("/", a, 1, NumDiv)
+	}
+	return fold("/", a[1:], a[0], NumDiv)
+}
+
+func numCompare(name string, a []Top, test func(int) bool) (Top, error) {
+	if e := CheckArity(name, a, 1, -1); e != nil {
+		return nil, e
+	}
+	if e := checkNumbers(name, a); e != nil {
+		return nil, e
+	}
+	for i := 1; i < len(a); i += 1 {
+		c, e := NumCompare(a[i-1], a[i])
+		if e != nil {
+			return nil, e
+		}
+		if !test(c) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// equal is numeric equality for numbers, so (= 1 1.0) is true, and
+// structural equality for everything else.
+func equal(a []Top) (Top, error) {
+	if e := CheckArity("=", a, 1, -1); e != nil {
+		return nil, e
+	}
+	for i := 1; i < len(a); i += 1 {
+		if IsNumber(a[i-1]) && IsNumber(a[i]) {
+			if c, _ := NumCompare(a[i-1], a[i]); c != 0 {
+				return false, nil
+			}
+		} else if !Eq(a[i-1], a[i]) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func extremum(name string, a []Top, test func(int) bool) (Top, error) {
+	if e := CheckArity(name, a, 1, -1); e != nil {
+		return nil, e
+	}
+	if e := checkNumbers(name, a); e != nil {
+		return nil, e
+	}
+	res := a[0]
+	inexact := false
+	for _, x := range a {
+		inexact = inexact || !IsExact(x)
+		if c, _ := NumCompare(x, res); test(c) {
+			res = x
+		}
+	}
+	if inexact {
+		return ToInexact(res)
+	}
+	return res, nil
+}
+
+func unary(name string, f func(Top) (Top, error)) func([]Top) (Top, error) {
+	return func(a []Top) (Top, error) {
+		if e := CheckArity(name, a, 1, 1); e != nil {
+			return nil, e
+		}
+		return f(a[0])
+	}
+}
+
+func binary(name string, f func(Top, Top) (Top, error)) func([]Top) (Top, error) {
+	return func(a []Top) (Top, error) {
+		if e := CheckArity(name, a, 2, 2); e != nil {
+			return nil, e
+		}
+		return f(a[0], a[1])
+	}
+}
+
+// floatFunc lifts a float64 function such as math.Sin to a builtin.
+func floatFunc(name string, f func(float64) float64) func([]Top) (Top, error) {
+	return unary(name, func(x Top) (Top, error) {
+		fx, ok := ToFloat(x)
+		if !ok {
+			return nil, WrongType(name, "number", x)
+		}
+		return f(fx), nil
+	})
+}
+
+func atan(a []Top) (Top, error) {
+	if len(a) == 1 {
+		return floatFunc("atan", math.Atan)(a)
+	}
+	if e := CheckArity("atan", a, 1, 2); e != nil {
+		return nil, e
+	}
+	if e := checkNumbers("atan", a); e != nil {
+		return nil, e
+	}
+	y, _ := ToFloat(a[0])
+	x, _ := ToFloat(a[1])
+	return math.Atan2(y, x), nil
+}
+
+func logFunc(a []Top) (Top, error) {
+	if len(a) == 1 {
+		return floatFunc("log", math.Log)(a)
+	}
+	if e := CheckArity("log", a, 1, 2); e != nil {
+		return nil, e
+	}
+	if e := checkNumbers("log", a); e != nil {
+		return nil, e
+	}
+	x, _ := ToFloat(a[0])
+	base, _ := ToFloat(a[1])
+	return math.Log(x) / math.Log(base), nil
+}
+
+func predicate(name string, f func(Top) bool) func([]Top) (Top, error) {
+	return func(a []Top) (Top, error) {
+		if e := CheckArity(name, a, 1, 1); e != nil {
+			return nil, e
+		}
+		return f(a[0]), nil
+	}
+}
+
+var mathFunctions = map[string]Top{
+	"+": add,
+	"-": sub,
+	"*": mul,
+	"/": div,
+	"=": equal,
+	"<": func(a []Top) (Top, error) {
+		return numCompare("<", a, func(c int) bool { return c < 0 })
+	},
+	"<=": func(a []Top) (Top, error) {
+		return numCompare("<=", a, func(c int) bool { return c <= 0 })
+	},
+	">": func(a []Top) (Top, error) {
+		return numCompare(">", a, func(c int) bool { return c > 0 })
+	},
+	">=": func(a []Top) (Top, error) {
+		return numCompare(">=", a, func(c int) bool { return c >= 0 })
+	},
+	"min": func(a []Top) (Top, error) {
+		return extremum("min", a, func(c int) bool { return c < 0 })
+	},
+	"max": func(a []Top) (Top, error) {
+		return extremum("max", a, func(c int) bool { return c > 0 })
+	},
+
+	"quotient":  binary("quotient", NumQuotient),
+	"remainder": binary("remainder", NumRemainder),
+	"modulo":    binary("modulo", NumModulo),
+	"expt":      binary("expt", NumExpt),
+	"abs":       unary("abs", NumAbs),
+	"sqrt":      unary("sqrt", NumSqrt),
+	"floor":     unary("floor", NumFloor),
+	"ceiling":   unary("ceiling", NumCeiling),
+	"round":     unary("round", NumRound),
+	"truncate":  unary("truncate", NumTruncate),
+
+	"exp":  floatFunc("exp", math.Exp),
+	"log":  logFunc,
+	"sin":  floatFunc("sin", math.Sin),
+	"cos":  floatFunc("cos", math.Cos),
+	"tan":  floatFunc("tan", math.Tan),
+	"asin": floatFunc("asin", math.Asin),
+	"acos": floatFunc("acos", math.Acos),
+	"atan": atan,
+
+	"exact->inexact": unary("exact->inexact", ToInexact),
+	"inexact->exact": unary("inexact->exact", ToExact),
+	"number?":        predicate("number?", IsNumber),
+	"integer?":       predicate("integer?", IsInteger),
+	"rational?":      predicate("rational?", IsExact),
+	"float?":         predicate("float?", IsFloat),
+	"zero?": unary("zero?", func(x Top) (Top, error) {
+		if !IsNumber(x) {
+			return nil, WrongType("zero?", "number", x)
+		}
+		c, _ := NumCompare(x, 0)
+		return c == 0, nil
+	}),
+}
+
+func init() {
+	for k, v := range mathFunctions {
+		GlobalFunctions[k] = v
+	}
+}
@@ -1,20 +1,47 @@
 package env
 
 import (
-	"errors"
+	"sync"
+	"sync/atomic"
 )
 
 import (
 	. "github.com/ntaoo/lispgo/types"
 )
 
+// Env is safe for concurrent use, so that goroutines started by spawn
+// can share the global environment and closures.
 type Env struct {
-	data  map[string]Top
+	mu    sync.RWMutex
+	data  map[string]*Var
 	outer EnvType
 }
 
+// Var is where an Env keeps the value of a name. Setting the name again
+// changes the value of the same Var, so a Var found once can be read
+// without looking the name up again.
+type Var struct {
+	val atomic.Value
+}
+
+// boxed lets a Var hold values of any type, and nil.
+type boxed struct {
+	x Top
+}
+
+func newVar(value Top) *Var {
+	v := &Var{}
+	v.val.Store(boxed{value})
+	return v
+}
+
+// Get returns the value of v.
+func (v *Var) Get() Top {
+	return v.val.Load().(boxed).x
+}
+
 func NewEnv(outer EnvType, binds_mt Top, exprs_mt Top) (EnvType, error) {
-	env := Env{map[string]Top{}, outer}
+	env := &Env{data: map[string]*Var{}, outer: outer}
 
 	if binds_mt != nil && exprs_mt != nil {
 		binds, e := GetSlice(binds_mt)
@@ -28,20 +55,50 @@ func NewEnv(outer EnvType, binds_mt Top, exprs_mt Top) (EnvType, error) {
 		// Return a new Env with symbols in binds boudn to
 		// corresponding values in exprs
 		for i := 0; i < len(binds); i += 1 {
-			if IsSymbol(binds[i]) && binds[i].(Symbol).Val == "&" {
-				env.data[binds[i+1].(Symbol).Val] = List{exprs[i:], nil}
-				break
-			} else {
-				env.data[binds[i].(Symbol).Val] = exprs[i]
+			sym, ok := binds[i].(Symbol)
+			if !ok {
+				return nil, NewErrorf(SyntaxError, "", "parameter must be a symbol, got %s", TypeName(binds[i]))
+			}
+			if sym.Val == "&" {
+				if i+2 != len(binds) || !IsSymbol(binds[i+1]) {
+					return nil, NewError(SyntaxError, "", binds_mt, "'&' must be followed by exactly one symbol")
+				}
+				if e := CheckArity("", exprs, i, -1); e != nil {
+					return nil, e
+				}
+				env.data[binds[i+1].(Symbol).Val] = newVar(List{exprs[i:], nil})
+				return env, nil
 			}
+			if i >= len(exprs) {
+				return nil, CheckArity("", exprs, len(binds), len(binds))
+			}
+			env.data[sym.Val] = newVar(exprs[i])
+		}
+		if e := CheckArity("", exprs, len(binds), len(binds)); e != nil {
+			return nil, e
 		}
 	}
 	//return &et, nil
 	return env, nil
 }
 
-func (e Env) Find(key Symbol) EnvType {
-	if _, ok := e.data[key.Val]; ok {
+func (e *Env) lookup(key string) (Top, bool) {
+	if v := e.Var(Symbol{key}); v != nil {
+		return v.Get(), true
+	}
+	return nil, false
+}
+
+// Var returns the Var of key in e itself, not in the environments around
+// it, or nil if key is not bound there.
+func (e *Env) Var(key Symbol) *Var {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.data[key.Val]
+}
+
+func (e *Env) Find(key Symbol) EnvType {
+	if _, ok := e.lookup(key.Val); ok {
 		return e
 	} else if e.outer != nil {
 		return e.outer.Find(key)
@@ -50,15 +107,34 @@ func (e Env) Find(key Symbol) EnvType {
 	}
 }
 
-func (e Env) Set(key Symbol, value Top) Top {
-	e.data[key.Val] = value
+func (e *Env) Set(key Symbol, value Top) Top {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if v, ok := e.data[key.Val]; ok {
+		v.val.Store(boxed{value})
+	} else {
+		e.data[key.Val] = newVar(value)
+	}
 	return value
 }
 
-func (e Env) Get(key Symbol) (Top, error) {
-	env := e.Find(key)
-	if env == nil {
-		return nil, errors.New("'" + key.Val + "' not found")
+func (e *Env) Get(key Symbol) (Top, error) {
+	if val, ok := e.lookup(key.Val); ok {
+		return val, nil
+	} else if e.outer != nil {
+		return e.outer.Get(key)
+	}
+	return nil, NewError(UnboundError, "", key, "'"+key.Val+"' not found")
+}
+
+// Names returns the names bound in e itself, not in the environments
+// around it, in no particular order.
+func (e *Env) Names() []string {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	names := make([]string, 0, len(e.data))
+	for name := range e.data {
+		names = append(names, name)
 	}
-	return env.(Env).data[key.Val], nil
+	return names
 }
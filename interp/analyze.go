@@ -0,0 +1,547 @@
+package interp
+
+import (
+	. "github.com/ntaoo/lispgo/types"
+)
+
+// Analyzer
+//
+// The tree walker does not evaluate read forms directly. As with SICP's
+// analyze, a top-level form is first turned into code, a tree of Go
+// closures, and then run. Macros are expanded and special forms
+// recognised once, while analyzing, and the body of every lambda is
+// analyzed along with the form that contains it, so calling a function
+// does not look at the forms of its body again. As in the compiler, a
+// macro must therefore be defined before a form using it is analyzed.
+//
+// A call in tail position does not call a closure but returns a tailCall
+// for tailCall.run to make, so that tail calls do not grow the Go stack.
+// Tail positions are the body of a lambda and, within one, the last form
+// of a do, the body of a let*, either branch of an if, the body of a
+// catch* and that of a try* without one; macros such as cond and or
+// expand into those. The body of a try* with a catch* is not one, since
+// its handler stays in place until it returns, so recursing through it
+// counts towards MaxDepth.
+// Forms that do not analyze, such as (if), become code raising their
+// error, so that try* can catch it where the tree walker used to reach it.
+// Variables are resolved to slots as described in closure.go.
+
+// code is an analyzed form; it evaluates the form in the activation of
+// the function or top-level form it is part of.
+type code func(act *activation) (Top, error)
+
+// tailCall is a call to a closure left for the caller to make.
+type tailCall struct {
+	name string
+	body code
+	act  *activation
+	form Top
+}
+
+// run makes a call and the tail calls it ends in. An error unwinding
+// out of it gets a backtrace frame for the last function called, at the
+// form that made the first call.
+func (tc *tailCall) run() (Top, error) {
+	callForm := tc.form
+	for {
+		res, e := tc.body(tc.act)
+		if e != nil {
+			return nil, PushFrame(e, tc.name, callForm)
+		}
+		next, ok := res.(*tailCall)
+		if !ok {
+			return res, nil
+		}
+		tc = next
+	}
+}
+
+// exec runs code analyzed in tail position.
+func exec(c code, act *activation) (Top, error) {
+	res, e := c(act)
+	if tc, ok := res.(*tailCall); ok {
+		return tc.run()
+	}
+	return res, e
+}
+
+func raise(e error) code {
+	return func(*activation) (Top, error) {
+		return nil, e
+	}
+}
+
+func constant(x Top) code {
+	return func(*activation) (Top, error) {
+		return x, nil
+	}
+}
+
+// analyzer holds the lexical bindings in scope and env, the root
+// environment, where globals and macros are looked up. Macros are called
+// from from, and the closures of lambdas may nest max calls deep.
+type analyzer struct {
+	env   EnvType
+	max   int
+	from  caller
+	scope *fnScope
+}
+
+func newAnalyzer(env EnvType, max int, from caller, outer *fnScope) *analyzer {
+	return &analyzer{env: env, max: max, from: from, scope: &fnScope{outer: outer}}
+}
+
+// analyze returns code for ast. tail is set if its value is the value of
+// the enclosing function or top-level form.
+func (a *analyzer) analyze(ast Top, tail bool) code {
+	expanded, e := macroExpand(ast, a.scope, a.env, a.from)
+	if e != nil {
+		return raise(Locate(e, ast))
+	}
+	return a.analyzeExpanded(expanded, tail)
+}
+
+// analyzeExpanded returns code for ast, which is not a macro call. An
+// error from a form with a source position is located there.
+func (a *analyzer) analyzeExpanded(ast Top, tail bool) code {
+	n := len(a.scope.names)
+	c, e := a.form(ast, tail)
+	if e != nil {
+		a.scope.names = a.scope.names[:n]
+		return raise(Locate(e, ast))
+	}
+	if _, ok := FormPos(ast); !ok {
+		return c
+	}
+	return func(act *activation) (Top, error) {
+		res, e := c(act)
+		if e != nil {
+			return nil, Locate(e, ast)
+		}
+		return res, nil
+	}
+}
+
+func (a *analyzer) analyzeAll(forms []Top) []code {
+	res := make([]code, 0, len(forms))
+	for _, x := range forms {
+		res = append(res, a.analyze(x, false))
+	}
+	return res
+}
+
+func evalAll(cs []code, act *activation) ([]Top, error) {
+	res := make([]Top, len(cs))
+	for i, c := range cs {
+		x, e := c(act)
+		if e != nil {
+			return nil, e
+		}
+		res[i] = x
+	}
+	return res, nil
+}
+
+func (a *analyzer) form(ast Top, tail bool) (code, error) {
+	switch x := ast.(type) {
+	case Symbol, Gensym, renamed:
+		return a.variable(ast)
+	case Vector:
+		elems := a.analyzeAll(x.Slice())
+		return func(act *activation) (Top, error) {
+			vals, e := evalAll(elems, act)
+			if e != nil {
+				return nil, e
+			}
+			return NewVector(vals...), nil
+		}, nil
+	case HashMap:
+		var kvs []code
+		x.Each(func(k Top, v Top) bool {
+			kvs = append(kvs, a.analyze(k, false), a.analyze(v, false))
+			return true
+		})
+		return func(act *activation) (Top, error) {
+			vals, e := evalAll(kvs, act)
+			if e != nil {
+				return nil, e
+			}
+			hm := HashMap{}
+			for i := 0; i < len(vals); i += 2 {
+				hm = hm.Assoc(vals[i], vals[i+1])
+			}
+			return hm, nil
+		}, nil
+	case List:
+		if len(x.Val) == 0 {
+			return constant(x), nil
+		}
+	default:
+		return constant(ast), nil
+	}
+
+	lst := ast.(List).Val
+	var a1, a2 Top
+	if len(lst) > 1 {
+		a1 = lst[1]
+	}
+	if len(lst) > 2 {
+		a2 = lst[2]
+	}
+	if name := a.scope.formName(lst[0]); name != "" {
+		switch name {
+		case "define":
+			if e := checkForm(ast, 3, 3); e != nil {
+				return nil, e
+			}
+			sym, ok := symbolOf(a1)
+			if !ok {
+				return nil, NewError(SyntaxError, "define", ast, "expected a symbol to define, got "+TypeName(a1))
+			}
+			return a.define(a1, func() code {
+				value := a.analyze(a2, false)
+				return func(act *activation) (Top, error) {
+					res, e := value(act)
+					if e != nil {
+						return nil, e
+					}
+					return named(res, sym.Val), nil
+				}
+			}), nil
+		case "let*":
+			return a.let(ast, a1, a2, tail)
+		case "quote":
+			if e := checkForm(ast, 2, 2); e != nil {
+				return nil, e
+			}
+			return constant(stripSyntax(a1)), nil
+		case "quasiquote":
+			if e := checkForm(ast, 2, 2); e != nil {
+				return nil, e
+			}
+			qq, e := quasiquote(a1)
+			if e != nil {
+				return nil, e
+			}
+			return a.analyze(qq, tail), nil
+		case "defmacro!":
+			if e := checkForm(ast, 3, 3); e != nil {
+				return nil, e
+			}
+			sym, ok := symbolOf(a1)
+			if !ok {
+				return nil, NewError(SyntaxError, "defmacro!", ast, "expected a symbol to define, got "+TypeName(a1))
+			}
+			return a.define(a1, func() code {
+				value := a.analyze(a2, false)
+				return func(act *activation) (Top, error) {
+					fn, e := value(act)
+					if e != nil {
+						return nil, e
+					}
+					mac, ok := asMacro(fn, sym.Val)
+					if !ok {
+						return nil, NewError(TypeError, "defmacro!", ast, "expected a lambda, got "+TypeName(fn))
+					}
+					return mac, nil
+				}
+			}), nil
+		case "macroExpand":
+			if e := checkForm(ast, 2, 2); e != nil {
+				return nil, e
+			}
+			expanded, e := macroExpand(a1, a.scope, a.env, a.from)
+			if e != nil {
+				return nil, e
+			}
+			return constant(expanded), nil
+		case "macroexpand-1":
+			if e := checkForm(ast, 2, 2); e != nil {
+				return nil, e
+			}
+			expanded, _, e := macroExpand1(a1, a.scope, a.env, a.from)
+			if e != nil {
+				return nil, e
+			}
+			return constant(expanded), nil
+		case "macroexpand-all":
+			if e := checkForm(ast, 2, 2); e != nil {
+				return nil, e
+			}
+			expanded, e := macroExpandAll(a1, a.scope, a.env, a.from)
+			if e != nil {
+				return nil, e
+			}
+			return constant(expanded), nil
+		case "define-syntax":
+			if !a.scope.toplevel() {
+				mac, e := defineSyntax(ast, a.scope, a.env)
+				if e != nil {
+					return nil, e
+				}
+				return constant(mac), nil
+			}
+			mac, e := defineSyntax(ast, nil, a.env)
+			if e != nil {
+				return nil, e
+			}
+			return a.define(a1, func() code {
+				return constant(mac)
+			}), nil
+		case "let-syntax", "letrec-syntax":
+			n := len(a.scope.names)
+			if e := bindSyntax(ast, a.scope, a.env); e != nil {
+				return nil, e
+			}
+			c := a.analyze(a2, tail)
+			a.scope.names = a.scope.names[:n]
+			return c, nil
+		case "try*":
+			return a.try(ast, a1, a2, tail)
+		case "do":
+			if len(lst) == 1 {
+				return constant(nil), nil
+			}
+			body := a.analyzeAll(lst[1 : len(lst)-1])
+			last := a.analyze(lst[len(lst)-1], tail)
+			return func(act *activation) (Top, error) {
+				for _, c := range body {
+					if _, e := c(act); e != nil {
+						return nil, e
+					}
+				}
+				return last(act)
+			}, nil
+		case "if":
+			if e := checkForm(ast, 3, 4); e != nil {
+				return nil, e
+			}
+			cond := a.analyze(a1, false)
+			then := a.analyze(a2, tail)
+			otherwise := constant(nil)
+			if len(lst) == 4 {
+				otherwise = a.analyze(lst[3], tail)
+			}
+			return func(act *activation) (Top, error) {
+				c, e := cond(act)
+				if e != nil {
+					return nil, e
+				}
+				if c == nil || c == false {
+					return otherwise(act)
+				}
+				return then(act)
+			}, nil
+		case "lambda":
+			return a.lambda(ast, a1, a2)
+		}
+	}
+
+	fn := a.analyze(lst[0], false)
+	args := a.analyzeAll(lst[1:])
+	return func(act *activation) (Top, error) {
+		f, e := fn(act)
+		if e != nil {
+			return nil, e
+		}
+		return apply(f, args, act, ast, tail)
+	}, nil
+}
+
+// apply calls f from form with the values of args. In tail position a
+// closure is not called but returned as a tailCall.
+func apply(f Top, args []code, act *activation, form Top, tail bool) (Top, error) {
+	if cl, ok := f.(*Closure); ok && cl.proto.run != nil && !cl.proto.rest && cl.proto.nparams == len(args) {
+		// Evaluate the arguments straight into their slots.
+		slots := make([]Top, cl.proto.nslots)
+		for i, arg := range args {
+			x, e := arg(act)
+			if e != nil {
+				return nil, e
+			}
+			slots[i] = x
+		}
+		return enter(cl, &activation{slots: slots, outer: cl.outer}, act, form, tail)
+	}
+	vals, e := evalAll(args, act)
+	if e != nil {
+		return nil, e
+	}
+	return call(f, vals, act, form, tail)
+}
+
+// call calls f with arguments a from form. Anything but a closure is
+// called at once, and then the function of a TailCall it returns in its
+// place.
+func call(f Top, a []Top, act *activation, form Top, tail bool) (Top, error) {
+	for {
+		if cl, ok := f.(*Closure); ok && cl.proto.run != nil {
+			callee, e := cl.bind(a)
+			if e != nil {
+				return nil, WithForm(e, form)
+			}
+			return enter(cl, callee, act, form, tail)
+		}
+		res, e := callOther(f, a, form, caller{act.depth, act.task})
+		tc, ok := res.(TailCall)
+		if e != nil || !ok {
+			return res, e
+		}
+		f, a = tc.Fn, tc.Args
+	}
+}
+
+// enter starts a call of cl from act, with callee as its activation.
+func enter(cl *Closure, callee *activation, act *activation, form Top, tail bool) (Top, error) {
+	if e := act.task.interrupted(form); e != nil {
+		return nil, e
+	}
+	callee.task = act.task
+	tc := &tailCall{cl.Name, cl.proto.run, callee, form}
+	if tail {
+		callee.depth = act.depth
+		return tc, nil
+	}
+	callee.depth = act.depth + 1
+	if max := cl.proto.max; callee.depth > max {
+		return nil, stackOverflow(form, max)
+	}
+	return tc.run()
+}
+
+// variable returns code looking up the identifier id: in its slot if it
+// is bound lexically and in the root environment otherwise.
+func (a *analyzer) variable(id Top) (code, error) {
+	depth, slot, e := a.scope.resolve(id, id)
+	if e != nil {
+		return nil, e
+	}
+	switch {
+	case depth < 0:
+		ref := refGlobal(id, a.env)
+		return func(*activation) (Top, error) {
+			return ref.get()
+		}, nil
+	case depth == 0:
+		return func(act *activation) (Top, error) {
+			return act.slots[slot], nil
+		}, nil
+	default:
+		return func(act *activation) (Top, error) {
+			return act.lookup(depth, slot), nil
+		}, nil
+	}
+}
+
+// define analyzes a define, defmacro! or define-syntax, whose value is
+// analyzed by value: at the top level the identifier id is bound as a
+// global, and anywhere else in a new slot.
+func (a *analyzer) define(id Top, value func() code) code {
+	if a.scope.toplevel() {
+		sym, _ := symbolOf(id)
+		c, env := value(), a.env
+		return func(act *activation) (Top, error) {
+			res, e := c(act)
+			if e != nil {
+				return nil, e
+			}
+			return env.Set(sym, res), nil
+		}
+	}
+	slot := a.scope.declare(id)
+	c := value()
+	a.scope.names[len(a.scope.names)-1].pending = false
+	return func(act *activation) (Top, error) {
+		res, e := c(act)
+		if e != nil {
+			return nil, e
+		}
+		act.slots[slot] = res
+		return res, nil
+	}
+}
+
+func (a *analyzer) let(ast Top, bindings Top, body Top, tail bool) (code, error) {
+	if e := checkForm(ast, 3, 3); e != nil {
+		return nil, e
+	}
+	binds, e := GetSlice(bindings)
+	if e != nil || len(binds)%2 != 0 {
+		return nil, NewError(SyntaxError, "let*", ast, "bindings must be a list of symbol/value pairs")
+	}
+	for i := 0; i < len(binds); i += 2 {
+		if !isIdent(binds[i]) {
+			return nil, NewError(SyntaxError, "let*", ast, "non-symbol bind value")
+		}
+	}
+	n := len(a.scope.names)
+	slots := make([]int, 0, len(binds)/2)
+	vals := make([]code, 0, len(binds)/2)
+	for i := 0; i < len(binds); i += 2 {
+		slots = append(slots, a.scope.declare(binds[i]))
+		vals = append(vals, a.analyze(binds[i+1], false))
+		a.scope.names[len(a.scope.names)-1].pending = false
+	}
+	c := a.analyze(body, tail)
+	a.scope.names = a.scope.names[:n]
+	return func(act *activation) (Top, error) {
+		for i, val := range vals {
+			x, e := val(act)
+			if e != nil {
+				return nil, e
+			}
+			act.slots[slots[i]] = x
+		}
+		return c(act)
+	}, nil
+}
+
+func (a *analyzer) try(ast Top, body Top, handler Top, tail bool) (code, error) {
+	if e := checkForm(ast, 2, 3); e != nil {
+		return nil, e
+	}
+	if handler == nil {
+		return a.analyze(body, tail), nil
+	}
+	h, _ := GetSlice(handler)
+	if !IsList(handler) || len(h) != 3 || !isNamed(h[0], "catch*") || !isIdent(h[1]) {
+		return nil, NewError(SyntaxError, "try*", ast, "expected (catch* symbol body)")
+	}
+	c := a.analyze(body, false)
+	n := len(a.scope.names)
+	slot := a.scope.declare(h[1])
+	a.scope.names[n].pending = false
+	catch := a.analyze(h[2], tail)
+	a.scope.names = a.scope.names[:n]
+	return func(act *activation) (Top, error) {
+		res, e := c(act)
+		if e == nil {
+			return res, nil
+		}
+		if _, ok := e.(Escape); ok {
+			return nil, e
+		}
+		if lge, ok := e.(LGError); ok {
+			act.slots[slot] = lge.Obj
+		} else {
+			act.slots[slot] = e.Error()
+		}
+		return catch(act)
+	}, nil
+}
+
+func (a *analyzer) lambda(ast Top, params Top, body Top) (code, error) {
+	if e := checkForm(ast, 3, 3); e != nil {
+		return nil, e
+	}
+	fa := newAnalyzer(a.env, a.max, a.from, a.scope)
+	p := &proto{body: body, globals: a.env, max: a.max}
+	if e := declareParams(ast, params, fa.scope, p); e != nil {
+		return nil, e
+	}
+	p.run = fa.analyze(body, true)
+	p.nslots = fa.scope.nslots
+	return func(act *activation) (Top, error) {
+		return &Closure{proto: p, outer: act}, nil
+	}, nil
+}
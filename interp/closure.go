@@ -0,0 +1,226 @@
+package interp
+
+import (
+	"fmt"
+)
+
+import (
+	"github.com/ntaoo/lispgo/printer"
+	. "github.com/ntaoo/lispgo/types"
+)
+
+// Closures
+//
+// Both backends resolve the variables bound by lambda, let*, catch* and
+// define inside a function to slots of an activation record when a form
+// is analyzed or compiled, so a variable is found by how many functions
+// out it was bound and its index. Only globals are still looked up by
+// name, in the interpreter's root environment, and only the first time;
+// see namespace.go. A closure pairs the proto
+// of a lambda with the activation it was created in.
+//
+// Calls that are not tail calls nest, and may not nest deeper than the
+// MaxDepth of the interpreter that made the callee. How deep a call is
+// nested is kept in its activation, along with the task it belongs to;
+// see task.go. A builtin that calls functions back, as map does, is a
+// Builtin, and is told its caller: the calls it makes count on from the
+// depth it was called at, so recursion through builtins is limited too,
+// and belong to the same task. The functions of lazy sequences, called
+// back once the builtin has returned, start at depth 1 in its task. A
+// closure called from Go otherwise, as by a future or an embedding
+// program, starts at depth 1 in no task.
+
+// caller is where a call is made from: an activation depth calls deep,
+// belonging to task. The zero caller is Go.
+type caller struct {
+	depth int
+	task  *task
+}
+
+// callerOf returns the caller a Builtin was told of.
+func callerOf(c Caller) caller {
+	if c, ok := c.(caller); ok {
+		return c
+	}
+	return caller{}
+}
+
+func (c caller) Apply(f Top, a []Top) (Top, error) {
+	for {
+		if cl, ok := f.(*Closure); ok {
+			return cl.call(a, c)
+		}
+		res, e := callOther(f, a, nil, c)
+		tc, ok := res.(TailCall)
+		if e != nil || !ok {
+			return res, e
+		}
+		f, a = tc.Fn, tc.Args
+	}
+}
+
+func (c caller) Stop() <-chan struct{} {
+	return c.task.stopped()
+}
+
+func (c caller) Later() Caller {
+	return caller{task: c.task}
+}
+
+// activation holds the slots of one call of a proto, and a link to the
+// activation the closure was created in. depth counts the calls it is
+// nested in, and task is the task the call belongs to.
+type activation struct {
+	slots []Top
+	outer *activation
+	depth int
+	task  *task
+}
+
+// lookup returns slot i of the activation depth functions out.
+func (act *activation) lookup(depth int, i int) Top {
+	for ; depth > 0; depth -= 1 {
+		act = act.outer
+	}
+	return act.slots[i]
+}
+
+// Closure is a function defined in Lisp by either backend.
+type Closure struct {
+	proto *proto
+	outer *activation
+	Name  string
+	Macro bool
+	Meta  Top
+}
+
+func (cl *Closure) Call(a []Top) (Top, error) {
+	return cl.call(a, caller{})
+}
+
+// call calls cl with arguments a from c.
+func (cl *Closure) call(a []Top, c caller) (Top, error) {
+	act, e := cl.bind(a)
+	if e != nil {
+		return nil, PushFrame(e, cl.Name, nil)
+	}
+	act.depth, act.task = c.depth+1, c.task
+	if max := cl.proto.max; act.depth > max {
+		return nil, PushFrame(stackOverflow(nil, max), cl.Name, nil)
+	}
+	if e := c.task.interrupted(nil); e != nil {
+		return nil, PushFrame(e, cl.Name, nil)
+	}
+	if cl.proto.run != nil {
+		res, e := exec(cl.proto.run, act)
+		if e != nil {
+			return nil, PushFrame(e, cl.Name, nil)
+		}
+		return res, nil
+	}
+	m := &machine{base: act.depth, task: act.task}
+	m.frames = append(m.frames, frame{p: cl.proto, act: act, cl: cl, applied: true})
+	return m.run()
+}
+
+func (cl *Closure) GetMacro() bool {
+	return cl.Macro
+}
+
+func (cl *Closure) GetMeta() Top {
+	return cl.Meta
+}
+
+func (cl *Closure) WithMeta(m Top) Top {
+	res := *cl
+	res.Meta = m
+	return &res
+}
+
+func (cl *Closure) String() string {
+	return "(lambda " +
+		printer.PrintString(cl.proto.params, true) + " " +
+		printer.PrintString(cl.proto.body, true) + ")"
+}
+
+// bind makes the activation for a call with arguments a, which it does
+// not keep.
+func (cl *Closure) bind(a []Top) (*activation, error) {
+	p := cl.proto
+	if p.rest {
+		if e := CheckArity("", a, p.nparams-1, -1); e != nil {
+			return nil, e
+		}
+	} else if e := CheckArity("", a, p.nparams, p.nparams); e != nil {
+		return nil, e
+	}
+	slots := make([]Top, p.nslots)
+	if p.rest {
+		n := p.nparams - 1
+		copy(slots, a[:n])
+		slots[n] = List{append(make([]Top, 0, len(a)-n), a[n:]...), nil}
+	} else {
+		copy(slots, a)
+	}
+	return &activation{slots: slots, outer: cl.outer}, nil
+}
+
+// stackOverflow is the error for a call from form nested deeper than
+// max.
+func stackOverflow(form Top, max int) error {
+	return NewError(StackOverflowError, "", form, fmt.Sprintf("stack overflow: more than %d nested calls", max))
+}
+
+// named returns x named name if it is an anonymous closure, and x
+// otherwise.
+func named(x Top, name string) Top {
+	cl, ok := x.(*Closure)
+	if !ok || cl.Name != "" {
+		return x
+	}
+	res := *cl
+	res.Name = name
+	return &res
+}
+
+// asMacro returns a macro made from the closure x, named name unless it
+// already has a name.
+func asMacro(x Top, name string) (Top, bool) {
+	cl, ok := x.(*Closure)
+	if !ok {
+		return nil, false
+	}
+	mac := *cl
+	if mac.Name == "" {
+		mac.Name = name
+	}
+	mac.Macro = true
+	return &mac, true
+}
+
+// declareParams checks the parameters of the lambda ast and declares
+// them in s, the scope of its body. It sets nparams, rest and params of
+// p.
+func declareParams(ast Top, params Top, s *fnScope, p *proto) error {
+	ps, e := GetSlice(params)
+	if e != nil {
+		return NewError(SyntaxError, "lambda", ast, "parameters must be a list or vector")
+	}
+	for i, x := range ps {
+		if !isIdent(x) {
+			return NewError(SyntaxError, "lambda", ast, "parameter must be a symbol, got "+TypeName(x))
+		}
+		if isNamed(x, "&") {
+			if i+2 != len(ps) {
+				return NewError(SyntaxError, "lambda", ast, "'&' must be followed by exactly one symbol")
+			}
+			p.rest = true
+			continue
+		}
+		s.declare(x)
+		s.names[len(s.names)-1].pending = false
+	}
+	p.nparams = len(s.names)
+	p.params = params
+	return nil
+}
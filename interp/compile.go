@@ -0,0 +1,528 @@
+package interp
+
+import (
+	"github.com/ntaoo/lispgo/printer"
+	. "github.com/ntaoo/lispgo/types"
+)
+
+// Bytecode compiler
+//
+// The VM backend compiles every top-level form into a proto: a flat
+// sequence of instructions for the stack machine in vm.go together with
+// its constants. Macros are expanded once, at compile time, and special
+// forms are recognised then instead of on every evaluation. Variables
+// are resolved to slots as described in closure.go.
+//
+// Errors that the tree walker reports when it reaches a malformed form,
+// such as (if) or a failing macro expansion, compile to an instruction
+// that raises them at the same point, so that they can be caught by
+// try* just the same.
+
+// Instructions are an opcode followed by its operands, all int32.
+const (
+	opConst       = iota // k: push consts[k]
+	opLocal              // i: push slot i of the current activation
+	opOuter              // d i: push slot i of the activation d functions out
+	opSetLocal           // i: pop into slot i of the current activation
+	opGlobal             // k: push the global consts[k], a *globalRef
+	opDefGlobal          // k: bind the global consts[k] to the top of the stack
+	opName               // k: name an anonymous closure on top of the stack
+	opMacro              // k: turn the closure on top of the stack into a macro named consts[k]
+	opPop                // drop the top of the stack
+	opDup                // duplicate the top of the stack
+	opJump               // t: continue at t
+	opJumpIfFalse        // t: pop and continue at t if it was nil or false
+	opCall               // n: call the function below n arguments
+	opTailCall           // n: the same, replacing the current call
+	opReturn             // return the top of the stack
+	opClosure            // k: push a closure of the proto consts[k]
+	opVector             // n: replace n values with a vector of them
+	opHashMap            // n: replace n keys and values with a hash-map
+	opTry                // t: catch errors at t until opEndTry
+	opEndTry             // remove the innermost handler
+	opRaise              // k: raise the error consts[k]
+)
+
+// proto is compiled code: a top-level form or the body of a lambda. The
+// tree walker makes protos for lambdas too, with their analyzed body in
+// run and no instructions.
+type proto struct {
+	code   []int32
+	run    code
+	consts []Top
+	// forms and at are indexed by instruction. forms holds the form an
+	// instruction was compiled from, at the innermost form around it
+	// whose source position is known.
+	forms []Top
+	at    []Top
+	// nparams counts the parameters including a rest parameter, which
+	// collects the remaining arguments if rest is set.
+	nparams int
+	rest    bool
+	nslots  int
+	params  Top
+	body    Top
+	globals EnvType
+	// max limits how deeply calls of closures of the proto nest.
+	max int
+}
+
+// binding binds name, a symbol or a renamed symbol (see syntax.go), to
+// a slot or, if macro is set, to a local macro.
+type binding struct {
+	name Top
+	slot int
+	// pending is set while the value of the binding is being compiled:
+	// the binding is only visible from lambdas inside that value, which
+	// cannot run before it is set.
+	pending bool
+	macro   *syntaxRules
+}
+
+// fnScope holds the bindings visible in the function being compiled.
+type fnScope struct {
+	outer  *fnScope
+	names  []binding
+	nslots int
+}
+
+func (s *fnScope) declare(name Top) int {
+	slot := s.nslots
+	s.nslots += 1
+	s.names = append(s.names, binding{name: name, slot: slot, pending: true})
+	return slot
+}
+
+func (s *fnScope) declareSyntax(name Top, mac *syntaxRules) {
+	s.names = append(s.names, binding{name: name, macro: mac})
+}
+
+// toplevel reports whether a define here binds a global, which it does
+// outside of any function or local binding.
+func (s *fnScope) toplevel() bool {
+	return s.outer == nil && len(s.names) == 0
+}
+
+// lookup finds the lexical binding of the identifier id. It returns the
+// binding and the number of functions out it was made in, or nil if id
+// is global.
+func (s *fnScope) lookup(id Top) (*binding, int) {
+	return s.lookupIn(id, -1)
+}
+
+// lookupIn is lookup with only the first n bindings of s visible, or all
+// of them if n is negative. A renamed symbol that no binding of its own
+// expansion binds is looked up where its macro was defined.
+func (s *fnScope) lookupIn(id Top, n int) (*binding, int) {
+	depth := 0
+	for sc := s; sc != nil; sc, n, depth = sc.outer, -1, depth+1 {
+		names := sc.names
+		if n >= 0 {
+			names = names[:n]
+		}
+		for i := len(names) - 1; i >= 0; i -= 1 {
+			if names[i].name == id && (depth > 0 || !names[i].pending) {
+				return &names[i], depth
+			}
+		}
+	}
+	r, ok := id.(renamed)
+	if !ok {
+		return nil, -1
+	}
+	def := r.mark.rules
+	depth = 0
+	for sc := s; sc != def.scope; sc = sc.outer {
+		if sc == nil {
+			return nil, -1
+		}
+		depth += 1
+	}
+	b, d := def.scope.lookupIn(r.id, def.n)
+	if b == nil {
+		return nil, -1
+	}
+	return b, depth + d
+}
+
+// resolve finds a lexical variable. It returns the number of functions
+// out it was bound in and its slot there, or a depth of -1 for a global.
+// It fails for a local macro.
+func (s *fnScope) resolve(id Top, ast Top) (int, int, error) {
+	b, depth := s.lookup(id)
+	switch {
+	case b == nil:
+		return -1, 0, nil
+	case b.macro != nil:
+		return 0, 0, NewError(SyntaxError, "", ast, "cannot take the value of macro "+printer.PrintString(id, true))
+	}
+	return depth, b.slot, nil
+}
+
+// formName returns the name of the special form that the head of a list
+// names, or "" if it names none. A renamed symbol only names one if it
+// is not bound locally.
+func (s *fnScope) formName(head Top) string {
+	switch x := head.(type) {
+	case Symbol:
+		return x.Val
+	case renamed:
+		if b, _ := s.lookup(x); b == nil {
+			sym, _ := symbolOf(x)
+			return sym.Val
+		}
+	}
+	return ""
+}
+
+type compiler struct {
+	p     *proto
+	scope *fnScope
+	// from is where macros are called from.
+	from caller
+	// cur is the form being compiled and at the innermost one with a
+	// source position; see proto.
+	cur Top
+	at  Top
+}
+
+func newCompiler(env EnvType, max int, from caller, outer *fnScope) *compiler {
+	return &compiler{p: &proto{globals: env, max: max}, scope: &fnScope{outer: outer}, from: from}
+}
+
+// compileTop compiles a top-level form evaluated from from.
+func compileTop(ast Top, env EnvType, max int, from caller) *proto {
+	c := newCompiler(env, max, from, nil)
+	c.compile(ast, true)
+	c.emit(opReturn)
+	c.p.nslots = c.scope.nslots
+	return c.p
+}
+
+func (c *compiler) emit(op int, args ...int) int {
+	at := len(c.p.code)
+	c.p.code = append(c.p.code, int32(op))
+	for _, a := range args {
+		c.p.code = append(c.p.code, int32(a))
+	}
+	for i := at; i < len(c.p.code); i += 1 {
+		c.p.forms = append(c.p.forms, c.cur)
+		c.p.at = append(c.p.at, c.at)
+	}
+	return at
+}
+
+// patch makes the jump at ip continue at the next instruction.
+func (c *compiler) patch(ip int) {
+	c.p.code[ip+1] = int32(len(c.p.code))
+}
+
+func (c *compiler) constant(x Top) int {
+	c.p.consts = append(c.p.consts, x)
+	return len(c.p.consts) - 1
+}
+
+// compile emits code leaving the value of ast on the stack. A form that
+// does not compile raises its error when it is run.
+func (c *compiler) compile(ast Top, tail bool) {
+	cur, at := c.cur, c.at
+	ncode, nnames := len(c.p.code), len(c.scope.names)
+	if e := c.form(ast, tail); e != nil {
+		c.p.code = c.p.code[:ncode]
+		c.p.forms = c.p.forms[:ncode]
+		c.p.at = c.p.at[:ncode]
+		c.scope.names = c.scope.names[:nnames]
+		c.cur = ast
+		if _, ok := FormPos(ast); ok {
+			c.at = ast
+		}
+		c.emit(opRaise, c.constant(e))
+	}
+	c.cur, c.at = cur, at
+}
+
+func (c *compiler) form(ast Top, tail bool) error {
+	if IsList(ast) {
+		expanded, e := macroExpand(ast, c.scope, c.p.globals, c.from)
+		if e != nil {
+			return e
+		}
+		ast = expanded
+	}
+	c.cur = ast
+	if _, ok := FormPos(ast); ok {
+		c.at = ast
+	}
+
+	switch x := ast.(type) {
+	case Symbol, Gensym, renamed:
+		depth, slot, e := c.scope.resolve(x, x)
+		if e != nil {
+			return e
+		}
+		if depth == 0 {
+			c.emit(opLocal, slot)
+		} else if depth > 0 {
+			c.emit(opOuter, depth, slot)
+		} else {
+			c.emit(opGlobal, c.constant(refGlobal(x, c.p.globals)))
+		}
+		return nil
+	case Vector:
+		for _, v := range x.Slice() {
+			c.compile(v, false)
+		}
+		c.emit(opVector, x.Len())
+		return nil
+	case HashMap:
+		x.Each(func(k Top, v Top) bool {
+			c.compile(k, false)
+			c.compile(v, false)
+			return true
+		})
+		c.emit(opHashMap, x.Len())
+		return nil
+	case List:
+		if len(x.Val) == 0 {
+			c.emit(opConst, c.constant(x))
+			return nil
+		}
+	default:
+		c.emit(opConst, c.constant(ast))
+		return nil
+	}
+
+	lst := ast.(List).Val
+	var a1, a2 Top
+	if len(lst) > 1 {
+		a1 = lst[1]
+	}
+	if len(lst) > 2 {
+		a2 = lst[2]
+	}
+	if name := c.scope.formName(lst[0]); name != "" {
+		switch name {
+		case "define":
+			if e := checkForm(ast, 3, 3); e != nil {
+				return e
+			}
+			sym, ok := symbolOf(a1)
+			if !ok {
+				return NewError(SyntaxError, "define", ast, "expected a symbol to define, got "+TypeName(a1))
+			}
+			c.define(a1, func() {
+				c.compile(a2, false)
+				c.emit(opName, c.constant(sym))
+			})
+			return nil
+		case "let*":
+			if e := checkForm(ast, 3, 3); e != nil {
+				return e
+			}
+			binds, e := GetSlice(a1)
+			if e != nil || len(binds)%2 != 0 {
+				return NewError(SyntaxError, "let*", ast, "bindings must be a list of symbol/value pairs")
+			}
+			for i := 0; i < len(binds); i += 2 {
+				if !isIdent(binds[i]) {
+					return NewError(SyntaxError, "let*", ast, "non-symbol bind value")
+				}
+			}
+			n := len(c.scope.names)
+			for i := 0; i < len(binds); i += 2 {
+				slot := c.scope.declare(binds[i])
+				c.compile(binds[i+1], false)
+				c.scope.names[len(c.scope.names)-1].pending = false
+				c.emit(opSetLocal, slot)
+			}
+			c.compile(a2, tail)
+			c.scope.names = c.scope.names[:n]
+			return nil
+		case "quote":
+			if e := checkForm(ast, 2, 2); e != nil {
+				return e
+			}
+			c.emit(opConst, c.constant(stripSyntax(a1)))
+			return nil
+		case "quasiquote":
+			if e := checkForm(ast, 2, 2); e != nil {
+				return e
+			}
+			qq, e := quasiquote(a1)
+			if e != nil {
+				return e
+			}
+			c.compile(qq, tail)
+			return nil
+		case "defmacro!":
+			if e := checkForm(ast, 3, 3); e != nil {
+				return e
+			}
+			sym, ok := symbolOf(a1)
+			if !ok {
+				return NewError(SyntaxError, "defmacro!", ast, "expected a symbol to define, got "+TypeName(a1))
+			}
+			c.define(a1, func() {
+				c.compile(a2, false)
+				c.emit(opMacro, c.constant(sym))
+			})
+			return nil
+		case "macroExpand":
+			if e := checkForm(ast, 2, 2); e != nil {
+				return e
+			}
+			expanded, e := macroExpand(a1, c.scope, c.p.globals, c.from)
+			if e != nil {
+				return e
+			}
+			c.emit(opConst, c.constant(expanded))
+			return nil
+		case "macroexpand-1":
+			if e := checkForm(ast, 2, 2); e != nil {
+				return e
+			}
+			expanded, _, e := macroExpand1(a1, c.scope, c.p.globals, c.from)
+			if e != nil {
+				return e
+			}
+			c.emit(opConst, c.constant(expanded))
+			return nil
+		case "macroexpand-all":
+			if e := checkForm(ast, 2, 2); e != nil {
+				return e
+			}
+			expanded, e := macroExpandAll(a1, c.scope, c.p.globals, c.from)
+			if e != nil {
+				return e
+			}
+			c.emit(opConst, c.constant(expanded))
+			return nil
+		case "define-syntax":
+			if !c.scope.toplevel() {
+				mac, e := defineSyntax(ast, c.scope, c.p.globals)
+				if e != nil {
+					return e
+				}
+				c.emit(opConst, c.constant(mac))
+				return nil
+			}
+			mac, e := defineSyntax(ast, nil, c.p.globals)
+			if e != nil {
+				return e
+			}
+			c.define(a1, func() {
+				c.emit(opConst, c.constant(mac))
+			})
+			return nil
+		case "let-syntax", "letrec-syntax":
+			n := len(c.scope.names)
+			if e := bindSyntax(ast, c.scope, c.p.globals); e != nil {
+				return e
+			}
+			c.compile(a2, tail)
+			c.scope.names = c.scope.names[:n]
+			return nil
+		case "try*":
+			return c.try(ast, a1, a2, tail)
+		case "do":
+			if len(lst) == 1 {
+				c.emit(opConst, c.constant(nil))
+				return nil
+			}
+			for _, x := range lst[1 : len(lst)-1] {
+				c.compile(x, false)
+				c.emit(opPop)
+			}
+			c.compile(lst[len(lst)-1], tail)
+			return nil
+		case "if":
+			if e := checkForm(ast, 3, 4); e != nil {
+				return e
+			}
+			c.compile(a1, false)
+			jf := c.emit(opJumpIfFalse, 0)
+			c.compile(a2, tail)
+			j := c.emit(opJump, 0)
+			c.patch(jf)
+			if len(lst) == 4 {
+				c.compile(lst[3], tail)
+			} else {
+				c.emit(opConst, c.constant(nil))
+			}
+			c.patch(j)
+			return nil
+		case "lambda":
+			return c.lambda(ast, a1, a2)
+		}
+	}
+
+	for _, x := range lst {
+		c.compile(x, false)
+	}
+	c.cur = ast
+	if tail {
+		c.emit(opTailCall, len(lst)-1)
+	} else {
+		c.emit(opCall, len(lst)-1)
+	}
+	return nil
+}
+
+// define binds the identifier id to the value that value emits: as a
+// global at the top level and in a new slot anywhere else.
+func (c *compiler) define(id Top, value func()) {
+	if c.scope.toplevel() {
+		sym, _ := symbolOf(id)
+		value()
+		c.emit(opDefGlobal, c.constant(sym))
+		return
+	}
+	slot := c.scope.declare(id)
+	value()
+	c.scope.names[len(c.scope.names)-1].pending = false
+	c.emit(opDup)
+	c.emit(opSetLocal, slot)
+}
+
+func (c *compiler) try(ast Top, body Top, handler Top, tail bool) error {
+	if e := checkForm(ast, 2, 3); e != nil {
+		return e
+	}
+	if handler == nil {
+		c.compile(body, tail)
+		return nil
+	}
+	h, _ := GetSlice(handler)
+	if !IsList(handler) || len(h) != 3 || !isNamed(h[0], "catch*") || !isIdent(h[1]) {
+		return NewError(SyntaxError, "try*", ast, "expected (catch* symbol body)")
+	}
+	try := c.emit(opTry, 0)
+	c.compile(body, false)
+	c.emit(opEndTry)
+	j := c.emit(opJump, 0)
+	c.patch(try)
+	n := len(c.scope.names)
+	slot := c.scope.declare(h[1])
+	c.scope.names[n].pending = false
+	c.emit(opSetLocal, slot)
+	c.compile(h[2], tail)
+	c.scope.names = c.scope.names[:n]
+	c.patch(j)
+	return nil
+}
+
+func (c *compiler) lambda(ast Top, params Top, body Top) error {
+	if e := checkForm(ast, 3, 3); e != nil {
+		return e
+	}
+	fc := newCompiler(c.p.globals, c.p.max, c.from, c.scope)
+	fc.p.body = body
+	if e := declareParams(ast, params, fc.scope, fc.p); e != nil {
+		return e
+	}
+	fc.compile(body, true)
+	fc.emit(opReturn)
+	fc.p.nslots = fc.scope.nslots
+	c.emit(opClosure, c.constant(fc.p))
+	return nil
+}
@@ -0,0 +1,225 @@
+package interp
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+import (
+	"github.com/ntaoo/lispgo/core"
+	"github.com/ntaoo/lispgo/printer"
+	. "github.com/ntaoo/lispgo/types"
+)
+
+// Documentation
+//
+// A function defined with defn keeps its docstring as :doc in its
+// metadata and the defn form as :source. A builtin keeps its name as
+// :name, and its parameters and documentation from core.Docs as :args and
+// :doc. (doc f) prints the name, parameters and docstring of f and
+// (source f) its definition; either also takes a quoted symbol, naming
+// the global to describe. (apropos
+// "str") lists the names bound in the current namespace that contain
+// str, and Complete gives the REPL the names a prefix may complete to.
+
+// Complete returns the names that prefix may be completed to in the
+// current namespace, sorted: the names usable unqualified, the prefixes
+// ns/ of qualified names, and, after such a prefix, the names that
+// namespace exports.
+func (it *Interpreter) Complete(prefix string) []string {
+	return complete(it.current(), prefix)
+}
+
+func complete(ns *Namespace, prefix string) []string {
+	var res []string
+	if i := strings.Index(prefix, "/"); i > 0 {
+		target := ns.resolve(prefix[:i])
+		if target == nil {
+			return nil
+		}
+		for _, name := range target.publicNames() {
+			if strings.HasPrefix(name, prefix[i+1:]) {
+				res = append(res, prefix[:i+1]+name)
+			}
+		}
+		sort.Strings(res)
+		return res
+	}
+	for _, name := range ns.Names() {
+		if strings.HasPrefix(name, prefix) {
+			res = append(res, name)
+		}
+	}
+	seen := map[string]bool{}
+	for _, p := range ns.prefixes() {
+		if strings.HasPrefix(p, prefix) && !seen[p] {
+			seen[p] = true
+			res = append(res, p+"/")
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+// builtinDocs documents the builtins the interpreter defines itself.
+var builtinDocs = map[string]core.Doc{
+	"eval":      {"[form]", "Evaluates form in the current namespace."},
+	"in-ns":     {"[name]", "Makes the namespace named by the symbol name current, creating it if needed."},
+	"require":   {"[& specs]", "Makes modules available to the current namespace, loading them first. A spec is a module name, or a vector of it followed by :as alias or :refer [names ...] or :refer :all."},
+	"export":    {"[& names]", "Exports the globals names from the current namespace, rather than all of them."},
+	"doc":       {"[f]", "Prints the name, parameters and documentation of f, or of the global named by a quoted symbol."},
+	"source":    {"[f]", "Prints the definition of the function f, or of the global named by a quoted symbol."},
+	"apropos":   {"[str]", "Returns the names usable in the current namespace that contain the string or symbol str."},
+	"load-file": {"[path]", "Evaluates the forms of the file at path in turn and returns the value of the last one."},
+}
+
+// documentBuiltins gives the builtins defined in ns their names and
+// documentation as metadata.
+func documentBuiltins(ns *Namespace) {
+	for _, name := range ns.Defined() {
+		meta := HashMap{}.Assoc(InternKeyword("name"), Symbol{name})
+		d, ok := core.Docs[name]
+		if !ok {
+			d, ok = builtinDocs[name]
+		}
+		if ok {
+			meta = meta.Assoc(InternKeyword("args"), d.Args).Assoc(InternKeyword("doc"), d.Text)
+		}
+		val, _ := ns.globals.Get(Symbol{name})
+		switch f := val.(type) {
+		case Func:
+			ns.Set(Symbol{name}, NewFunc(f.Fn, meta))
+		case Macro, Builtin:
+			ns.Set(Symbol{name}, f.(Callable).WithMeta(meta))
+		}
+	}
+}
+
+// described returns the value doc or source describes, and its name if
+// it is known.
+func (it *Interpreter) described(builtin string, a []Top) (Top, string, error) {
+	if e := CheckArity(builtin, a, 1, 1); e != nil {
+		return nil, "", e
+	}
+	if sym, ok := a[0].(Symbol); ok {
+		val, e := it.current().Get(sym)
+		return val, sym.Val, e
+	}
+	if cl, ok := a[0].(*Closure); ok {
+		return cl, cl.Name, nil
+	}
+	return a[0], "", nil
+}
+
+// metaOf returns the metadata of x, or nil if it has none.
+func metaOf(x Top) Top {
+	switch x := x.(type) {
+	case Func:
+		return x.Meta
+	case Callable:
+		return x.GetMeta()
+	case List:
+		return x.Meta
+	case Vector:
+		return x.Meta
+	case HashMap:
+		return x.Meta
+	}
+	return nil
+}
+
+func metaField(x Top, key string) (Top, bool) {
+	hm, ok := metaOf(x).(HashMap)
+	if !ok {
+		return nil, false
+	}
+	return hm.Get(InternKeyword(key))
+}
+
+func (it *Interpreter) doc(a []Top) (Top, error) {
+	val, name, e := it.described("doc", a)
+	if e != nil {
+		return nil, e
+	}
+	if name == "" {
+		if sym, ok := metaField(val, "name"); ok {
+			name = printer.PrintString(sym, false)
+		}
+	}
+	var b strings.Builder
+	header := []string{}
+	if name != "" {
+		header = append(header, name)
+	}
+	args, hasArgs := metaField(val, "args")
+	switch f := val.(type) {
+	case *Closure:
+		header = append(header, printer.PrintString(f.proto.params, true))
+	case Func, Macro, Builtin, func([]Top) (Top, error):
+		if hasArgs {
+			header = append(header, printer.PrintString(args, false))
+		} else {
+			header = append(header, "builtin function")
+		}
+	default:
+		header = append(header, TypeName(val))
+	}
+	b.WriteString(strings.Join(header, " ") + "\n")
+	if f, ok := val.(Callable); ok && f.GetMacro() {
+		b.WriteString("Macro\n")
+	}
+	if doc, ok := metaField(val, "doc"); ok {
+		for _, line := range strings.Split(fmt.Sprint(doc), "\n") {
+			b.WriteString("  " + strings.TrimSpace(line) + "\n")
+		}
+	} else {
+		b.WriteString("  No documentation.\n")
+	}
+	fmt.Fprint(it.stdout, b.String())
+	return nil, nil
+}
+
+func (it *Interpreter) source(a []Top) (Top, error) {
+	val, name, e := it.described("source", a)
+	if e != nil {
+		return nil, e
+	}
+	form, defined := metaField(val, "source")
+	cl, isClosure := val.(*Closure)
+	var src string
+	switch {
+	case defined:
+		src = printer.PrintString(form, true)
+	case isClosure && name != "":
+		src = "(define " + name + " " + cl.String() + ")"
+	case isClosure:
+		src = cl.String()
+	default:
+		return nil, NewError(ValueError, "source", a[0], "no source for "+TypeName(val))
+	}
+	fmt.Fprintln(it.stdout, src)
+	return nil, nil
+}
+
+func (it *Interpreter) apropos(a []Top) (Top, error) {
+	if e := CheckArity("apropos", a, 1, 1); e != nil {
+		return nil, e
+	}
+	var query string
+	switch q := a[0].(type) {
+	case string:
+		query = q
+	case Symbol:
+		query = q.Val
+	default:
+		return nil, WrongType("apropos", "string or symbol", a[0])
+	}
+	res := []Top{}
+	for _, name := range it.current().Names() {
+		if strings.Contains(name, query) {
+			res = append(res, Symbol{name})
+		}
+	}
+	return List{res, nil}, nil
+}
@@ -0,0 +1,296 @@
+package interp
+
+import (
+	"fmt"
+)
+
+import (
+	. "github.com/ntaoo/lispgo/types"
+)
+
+// eval
+func isPair(x Top) bool {
+	slc, e := GetSlice(x)
+	if e != nil {
+		return false
+	}
+	return len(slc) > 0
+}
+
+func quasiquote(ast Top) (Top, error) {
+	if !isPair(ast) {
+		return List{Val: []Top{Symbol{Val: "quote"}, ast}, Meta: nil}, nil
+	} else {
+		slc, _ := GetSlice(ast)
+		a0 := slc[0]
+		if isNamed(a0, "unquote") {
+			if len(slc) != 2 {
+				return nil, NewError(SyntaxError, "unquote", ast, "expected 1 argument")
+			}
+			return slc[1], nil
+		} else if isPair(a0) {
+			slc0, _ := GetSlice(a0)
+			a00 := slc0[0]
+			if isNamed(a00, "splice-unquote") {
+				if len(slc0) != 2 {
+					return nil, NewError(SyntaxError, "splice-unquote", a0, "expected 1 argument")
+				}
+				tail, e := quasiquote(List{Val: slc[1:], Meta: nil})
+				if e != nil {
+					return nil, e
+				}
+				return List{Val: []Top{Symbol{Val: "concat"},
+					slc0[1],
+					tail}, Meta: nil}, nil
+			}
+		}
+		head, e := quasiquote(a0)
+		if e != nil {
+			return nil, e
+		}
+		tail, e := quasiquote(List{Val: slc[1:], Meta: nil})
+		if e != nil {
+			return nil, e
+		}
+		return List{Val: []Top{Symbol{Val: "cons"}, head, tail}, Meta: nil}, nil
+	}
+}
+
+// macro returns the macro that the identifier id names: a local macro
+// bound in scope, which may be nil, or a global one in env. It returns
+// nil if id names none, as when it is a local variable.
+func macro(id Top, scope *fnScope, env EnvType) Top {
+	if b, _ := scope.lookup(id); b != nil {
+		if b.macro == nil {
+			return nil
+		}
+		return b.macro
+	}
+	env, sym := globalOf(id, env)
+	if env.Find(sym) == nil {
+		return nil
+	}
+	mac, _ := env.Get(sym)
+	if f, ok := mac.(Callable); ok && f.GetMacro() {
+		return f
+	}
+	return nil
+}
+
+// macroExpand1 expands ast once if it is a macro call, and reports
+// whether it was.
+func macroExpand1(ast Top, scope *fnScope, env EnvType, from caller) (Top, bool, error) {
+	lst, ok := ast.(List)
+	if !ok || len(lst.Val) == 0 || !isIdent(lst.Val[0]) {
+		return ast, false, nil
+	}
+	mac := macro(lst.Val[0], scope, env)
+	if mac == nil {
+		return ast, false, nil
+	}
+	var res Top
+	var e error
+	if sr, ok := mac.(*syntaxRules); ok {
+		res, e = sr.expand(lst, scope)
+	} else {
+		res, e = from.Apply(mac, lst.Val[1:])
+	}
+	if e != nil {
+		return nil, false, e
+	}
+	return res, true, nil
+}
+
+// macroExpand expands macro calls until ast is not one. Macros are
+// called from from, as are those of macroExpand1 and macroExpandAll.
+func macroExpand(ast Top, scope *fnScope, env EnvType, from caller) (Top, error) {
+	for {
+		expanded, ok, e := macroExpand1(ast, scope, env, from)
+		if e != nil || !ok {
+			return expanded, e
+		}
+		ast = expanded
+	}
+}
+
+// macroExpandAll expands every macro call in ast, which is in scope,
+// and in the forms it contains that are evaluated. A let-syntax or
+// letrec-syntax is replaced by its expanded body.
+func macroExpandAll(ast Top, scope *fnScope, env EnvType, from caller) (Top, error) {
+	ast, e := macroExpand(ast, scope, env, from)
+	if e != nil {
+		return nil, e
+	}
+	switch x := ast.(type) {
+	case Vector:
+		vals, e := expandEach(x.Slice(), scope, env, from)
+		if e != nil {
+			return nil, e
+		}
+		return NewVector(vals...), nil
+	case HashMap:
+		res := HashMap{}
+		x.Each(func(k Top, v Top) bool {
+			var ek, ev Top
+			if ek, e = macroExpandAll(k, scope, env, from); e != nil {
+				return false
+			}
+			if ev, e = macroExpandAll(v, scope, env, from); e != nil {
+				return false
+			}
+			res = res.Assoc(ek, ev)
+			return true
+		})
+		return res, e
+	case List:
+		if len(x.Val) == 0 {
+			return ast, nil
+		}
+	default:
+		return ast, nil
+	}
+
+	// Bindings are declared in a scope of their own, so that they shadow
+	// macros as they do when the form is analyzed.
+	lst := ast.(List).Val
+	s := &fnScope{outer: scope}
+	switch scope.formName(lst[0]) {
+	case "quote", "quasiquote", "define-syntax", "macroExpand", "macroexpand-1", "macroexpand-all":
+		return ast, nil
+	case "let-syntax", "letrec-syntax":
+		if e := bindSyntax(ast, s, env); e != nil {
+			return nil, e
+		}
+		return macroExpandAll(lst[2], s, env, from)
+	case "lambda":
+		if len(lst) != 3 {
+			return ast, nil
+		}
+		if e := declareParams(ast, lst[1], s, &proto{}); e != nil {
+			return nil, e
+		}
+		body, e := macroExpandAll(lst[2], s, env, from)
+		if e != nil {
+			return nil, e
+		}
+		return List{[]Top{lst[0], lst[1], body}, nil}, nil
+	case "let*":
+		binds, e := GetSlice(lst[1])
+		if len(lst) != 3 || e != nil || len(binds)%2 != 0 {
+			return ast, nil
+		}
+		for i := 0; i < len(binds); i += 2 {
+			if !isIdent(binds[i]) {
+				return ast, nil
+			}
+		}
+		res := make([]Top, len(binds))
+		for i := 0; i < len(binds); i += 2 {
+			s.declare(binds[i])
+			s.names[len(s.names)-1].pending = false
+			res[i] = binds[i]
+			if res[i+1], e = macroExpandAll(binds[i+1], s, env, from); e != nil {
+				return nil, e
+			}
+		}
+		body, e := macroExpandAll(lst[2], s, env, from)
+		if e != nil {
+			return nil, e
+		}
+		return List{[]Top{lst[0], List{res, nil}, body}, nil}, nil
+	case "define", "defmacro!":
+		if len(lst) != 3 {
+			return ast, nil
+		}
+		val, e := macroExpandAll(lst[2], scope, env, from)
+		if e != nil {
+			return nil, e
+		}
+		return List{[]Top{lst[0], lst[1], val}, nil}, nil
+	case "try*":
+		if len(lst) == 2 {
+			body, e := macroExpandAll(lst[1], scope, env, from)
+			if e != nil {
+				return nil, e
+			}
+			return List{[]Top{lst[0], body}, nil}, nil
+		}
+		h, ok := lst[len(lst)-1].(List)
+		if len(lst) != 3 || !ok || len(h.Val) != 3 || !isNamed(h.Val[0], "catch*") || !isIdent(h.Val[1]) {
+			return ast, nil
+		}
+		body, e := macroExpandAll(lst[1], scope, env, from)
+		if e != nil {
+			return nil, e
+		}
+		s.declare(h.Val[1])
+		s.names[0].pending = false
+		catch, e := macroExpandAll(h.Val[2], s, env, from)
+		if e != nil {
+			return nil, e
+		}
+		return List{[]Top{lst[0], body, List{[]Top{h.Val[0], h.Val[1], catch}, nil}}, nil}, nil
+	}
+	vals, e := expandEach(lst, scope, env, from)
+	if e != nil {
+		return nil, e
+	}
+	return List{vals, nil}, nil
+}
+
+func expandEach(xs []Top, scope *fnScope, env EnvType, from caller) ([]Top, error) {
+	res := make([]Top, len(xs))
+	for i, x := range xs {
+		var e error
+		if res[i], e = macroExpandAll(x, scope, env, from); e != nil {
+			return nil, e
+		}
+	}
+	return res, nil
+}
+
+// checkForm validates the number of elements of a special form,
+// including the form's own name.
+func checkForm(ast Top, min int, max int) error {
+	lst := ast.(List).Val
+	if len(lst) >= min && len(lst) <= max {
+		return nil
+	}
+	name, _ := symbolOf(lst[0])
+	return NewError(SyntaxError, name.Val, ast,
+		fmt.Sprintf("wrong number of arguments (%d)", len(lst)-1))
+}
+
+// Eval evaluates ast in env with the tree walker, allowing calls to nest
+// DefaultMaxDepth deep. It needs no Interpreter, so functions and
+// environments built by one interpreter can be evaluated directly.
+func Eval(ast Top, env EnvType) (Top, error) {
+	return evalTree(ast, env, DefaultMaxDepth, caller{})
+}
+
+// evalTree evaluates ast in env, the root environment, from c, allowing
+// calls to nest max deep. The form is analyzed before it is run; see
+// analyze.go. The forms of a top-level do are analyzed and run one by
+// one, so that a macro defined by one can be used by the next.
+func evalTree(ast Top, env EnvType, max int, c caller) (Top, error) {
+	expanded, e := macroExpand(ast, nil, env, c)
+	if e != nil {
+		return nil, Locate(e, ast)
+	}
+	if lst, ok := expanded.(List); ok && len(lst.Val) > 1 && isNamed(lst.Val[0], "do") {
+		var res Top
+		for _, x := range lst.Val[1:] {
+			if res, e = evalTree(x, env, max, c); e != nil {
+				return nil, e
+			}
+		}
+		return res, nil
+	}
+	depth := c.depth + 1
+	if depth > max {
+		return nil, stackOverflow(ast, max)
+	}
+	a := newAnalyzer(env, max, c, nil)
+	run := a.analyzeExpanded(expanded, true)
+	return exec(run, &activation{slots: make([]Top, a.scope.nslots), depth: depth, task: c.task})
+}
@@ -0,0 +1,304 @@
+// Package interp is the lispgo interpreter as a library. Each Interpreter
+// has its own namespaces and standard streams, so several can live
+// in one process:
+//
+//	it := interp.New(interp.Options{Stdout: &buf})
+//	it.Define("double", func(n int) int { return n * 2 })
+//	res, err := it.EvalString("(double 21)")
+package interp
+
+import (
+	_ "embed"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sync"
+)
+
+import (
+	"github.com/ntaoo/lispgo/bridge"
+	"github.com/ntaoo/lispgo/core"
+	"github.com/ntaoo/lispgo/reader"
+	. "github.com/ntaoo/lispgo/types"
+)
+
+// Backend selects how an Interpreter runs code.
+type Backend int
+
+const (
+	// TreeWalker evaluates forms directly with Eval.
+	TreeWalker Backend = iota
+	// VM compiles every top-level form to bytecode and runs it on a
+	// stack machine; see compile.go and vm.go.
+	VM
+)
+
+// DefaultMaxDepth is how deeply calls may nest unless Options.MaxDepth
+// says otherwise.
+const DefaultMaxDepth = 100000
+
+func (b Backend) String() string {
+	if b == VM {
+		return "vm"
+	}
+	return "tree"
+}
+
+// ParseBackend returns the backend named "tree" or "vm".
+func ParseBackend(name string) (Backend, error) {
+	switch name {
+	case "tree":
+		return TreeWalker, nil
+	case "vm":
+		return VM, nil
+	}
+	return 0, fmt.Errorf("unknown backend %q", name)
+}
+
+// Options configures a new Interpreter. The zero value reads the
+// terminal with readline, writes to os.Stdout and os.Stderr and uses the
+// tree walker.
+type Options struct {
+	Stdin   io.Reader
+	Stdout  io.Writer
+	Stderr  io.Writer
+	Backend Backend
+	// MaxDepth limits how deeply calls that are not tail calls may
+	// nest. A call beyond it raises a stack-overflow error. Zero means
+	// DefaultMaxDepth.
+	MaxDepth int
+	// Args is bound to *ARGV*.
+	Args []string
+	// LoadPath lists the directories require looks for modules in. Nil
+	// means the current directory.
+	LoadPath []string
+}
+
+type Interpreter struct {
+	namespaces *registry
+	mu         sync.Mutex
+	ns         *Namespace
+	// turn is held by the session evaluating a form; see Session.
+	turn    sync.Mutex
+	stdout  *output
+	stderr  io.Writer
+	backend Backend
+	// max limits how deeply calls nest.
+	max int
+}
+
+// prelude is the part of the standard library written in lispgo itself.
+//
+//go:embed prelude.lisp
+var prelude string
+
+// New creates an interpreter with the builtins and the prelude loaded.
+func New(opts Options) *Interpreter {
+	it := &Interpreter{stdout: &output{w: opts.Stdout}, stderr: opts.Stderr, backend: opts.Backend, max: opts.MaxDepth}
+	if it.max == 0 {
+		it.max = DefaultMaxDepth
+	}
+	if opts.Stdout == nil {
+		it.stdout.w = os.Stdout
+	}
+	if it.stderr == nil {
+		it.stderr = os.Stderr
+	}
+	loadPath := opts.LoadPath
+	if loadPath == nil {
+		loadPath = []string{"."}
+	}
+	it.namespaces = newRegistry(loadPath)
+	it.ns = it.namespaces.intern(CoreNamespace)
+
+	// core.go: defined using go
+	for k, v := range core.GlobalFunctions {
+		it.Define(k, v)
+	}
+	for k, v := range core.IOFunctions(opts.Stdin, it.stdout) {
+		it.Define(k, v)
+	}
+	for k, v := range bridge.Functions {
+		it.Define(k, v)
+	}
+	it.Define("eval", NewBuiltin(func(c Caller, a []Top) (Top, error) {
+		if e := CheckArity("eval", a, 1, 1); e != nil {
+			return nil, e
+		}
+		return it.evalForm(a[0], callerOf(c))
+	}, nil))
+	it.Define("in-ns", func(a []Top) (Top, error) {
+		if e := CheckArity("in-ns", a, 1, 1); e != nil {
+			return nil, e
+		}
+		sym, ok := a[0].(Symbol)
+		if !ok {
+			return nil, WrongType("in-ns", "symbol", a[0])
+		}
+		it.switchTo(it.namespaces.intern(sym.Val))
+		return nil, nil
+	})
+	it.Define("require", NewBuiltin(func(c Caller, a []Top) (Top, error) {
+		ns := it.current()
+		for _, spec := range a {
+			if e := it.require(ns, spec, callerOf(c)); e != nil {
+				return nil, e
+			}
+		}
+		return nil, nil
+	}, nil))
+	it.Define("export", func(a []Top) (Top, error) {
+		return nil, it.current().export(a)
+	})
+	it.Define("doc", it.doc)
+	it.Define("source", it.source)
+	it.Define("apropos", it.apropos)
+	it.Define("load-file", NewBuiltin(func(c Caller, a []Top) (Top, error) {
+		if e := CheckArity("load-file", a, 1, 1); e != nil {
+			return nil, e
+		}
+		path, ok := a[0].(string)
+		if !ok {
+			return nil, WrongType("load-file", "string", a[0])
+		}
+		return it.loadFile(path, callerOf(c))
+	}, nil))
+	args := make([]Top, 0, len(opts.Args))
+	for _, a := range opts.Args {
+		args = append(args, a)
+	}
+	it.Define("*ARGV*", List{args, nil})
+	// Bound by sessions; see session.go.
+	for _, name := range historyNames {
+		it.current().Set(name, nil)
+	}
+	it.current().Set(lastError, nil)
+	it.current().Set(printLength, defaultPrintLength)
+	documentBuiltins(it.current())
+
+	// prelude.lisp: defined using the language itself
+	if _, e := it.evalSource(prelude, "prelude.lisp", caller{}); e != nil {
+		panic(fmt.Sprintf("interp: prelude: %v", e))
+	}
+	it.ns = it.namespaces.intern("user")
+	return it
+}
+
+// Env returns the current namespace of the interpreter, where forms are
+// evaluated.
+func (it *Interpreter) Env() EnvType {
+	return it.current()
+}
+
+// Namespace returns the current namespace of the interpreter.
+func (it *Interpreter) Namespace() *Namespace {
+	return it.current()
+}
+
+// Define binds name in the current namespace to a Go value converted
+// with package bridge. Any Go function or method value becomes callable
+// from Lisp; those with the builtin signature func([]Top) (Top, error)
+// are called directly and others have their arguments and results
+// converted.
+func (it *Interpreter) Define(name string, value interface{}) {
+	if reflect.ValueOf(value).Kind() == reflect.Func {
+		value, _ = bridge.WrapFunc(name, value)
+	}
+	it.current().Set(Symbol{name}, bridge.ToLisp(value))
+}
+
+// EvalForm evaluates an already read form in the current namespace.
+func (it *Interpreter) EvalForm(form Top) (Top, error) {
+	return it.evalForm(form, caller{})
+}
+
+// evalForm evaluates form in the current namespace from c.
+func (it *Interpreter) evalForm(form Top, c caller) (Top, error) {
+	env := it.current()
+	if it.backend == VM {
+		return evalVM(form, env, it.max, c)
+	}
+	return evalTree(form, env, it.max, c)
+}
+
+// EvalString reads and evaluates every form of src in turn and returns
+// the value of the last one.
+func (it *Interpreter) EvalString(src string) (Top, error) {
+	return it.evalSource(src, "", caller{})
+}
+
+// LoadFile evaluates every form of a source file in turn, so that the
+// forms keep their file positions for backtraces. The current namespace
+// is restored afterwards.
+func (it *Interpreter) LoadFile(path string) (Top, error) {
+	return it.loadFile(path, caller{})
+}
+
+func (it *Interpreter) loadFile(path string, c caller) (Top, error) {
+	defer it.switchTo(it.current())
+	src, e := ioutil.ReadFile(path)
+	if e != nil {
+		return nil, NewError(IOError, "load-file", nil, e.Error())
+	}
+	return it.evalSource(string(src), path, c)
+}
+
+// evalSource evaluates the forms of src, read from file, from c.
+func (it *Interpreter) evalSource(src string, file string, c caller) (Top, error) {
+	forms, e := reader.Read_all(src, file)
+	if e != nil {
+		return nil, e
+	}
+	var res Top
+	for _, form := range forms {
+		if res, e = it.evalForm(form, c); e != nil {
+			return nil, e
+		}
+	}
+	return res, nil
+}
+
+// Rep reads the first form of str, evaluates it and prints the result
+// readably, as the REPL does.
+func (it *Interpreter) Rep(str string) (string, error) {
+	exp, e := reader.Read_str(str)
+	if e != nil {
+		return "", e
+	}
+	return it.rep(exp)
+}
+
+// rep evaluates form and prints the result readably.
+func (it *Interpreter) rep(form Top) (string, error) {
+	res, e := it.EvalForm(form)
+	if e != nil {
+		return "", e
+	}
+	return it.display(res)
+}
+
+// RepAll reads every form of str, as typed or pasted at the REPL, and
+// evaluates and prints each in turn as Rep does, passing the results to
+// print as they come. It stops at the first error.
+func (it *Interpreter) RepAll(str string, print func(string)) error {
+	forms, e := reader.Read_all(str, "")
+	if e != nil {
+		return e
+	}
+	for _, form := range forms {
+		out, e := it.rep(form)
+		if e != nil {
+			return e
+		}
+		print(out)
+	}
+	return nil
+}
+
+// PrintError writes an error and its Lisp backtrace to the
+// interpreter's stderr.
+func (it *Interpreter) PrintError(e error) {
+	fmt.Fprintf(it.stderr, "Error: %v\n%s", e, FormatBacktrace(e))
+}
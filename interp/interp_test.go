@@ -0,0 +1,1250 @@
+package interp
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/ntaoo/lispgo/core"
+	"github.com/ntaoo/lispgo/printer"
+	"github.com/ntaoo/lispgo/reader"
+	. "github.com/ntaoo/lispgo/types"
+)
+
+type TestCode struct {
+	title    string
+	code     string
+	expected string
+}
+
+// TODO: Implement car, cdr, etc...
+func newSuccessCodeArray() []TestCode {
+	t := make([]TestCode, 0, 0)
+	t = append(t, TestCode{title: "()", code: "()", expected: "()"})
+
+	// Calc
+	t = append(t, TestCode{title: "+", code: "(+ 1 2)", expected: "3"})
+	t = append(t, TestCode{title: "- 1", code: "(- 10 3)", expected: "7"})
+	t = append(t, TestCode{title: "- 2", code: "(- 10 3 5)", expected: "2"})
+	t = append(t, TestCode{title: "- unary", code: "(- 4)", expected: "-4"})
+	t = append(t, TestCode{title: "* 1", code: "(* 2 3)", expected: "6"})
+	t = append(t, TestCode{title: "* 2", code: "(* 2 3 4)", expected: "24"})
+	t = append(t, TestCode{title: "+ nullary", code: "(+)", expected: "0"})
+	t = append(t, TestCode{title: "* nullary", code: "(*)", expected: "1"})
+	t = append(t, TestCode{title: "/ unary", code: "(/ 4)", expected: "1/4"})
+	t = append(t, TestCode{title: "/ n-ary", code: "(/ 60 2 3)", expected: "10"})
+	t = append(t, TestCode{title: "/", code: "(/ 9 6)", expected: "3/2"})
+	t = append(t, TestCode{title: "nested calc", code: "(* (+ 2 3) (- 5 3))", expected: "10"})
+	t = append(t, TestCode{title: "nested calc2", code: "(/ (+ 9 1) (+ 2 3))", expected: "2"})
+
+	// Numeric tower
+	t = append(t, TestCode{title: "float literal", code: "2.0", expected: "2.0"})
+	t = append(t, TestCode{title: "float exponent", code: "-3.14e159", expected: "-3.14e159"})
+	t = append(t, TestCode{title: "float +", code: "(+ 1 0.5)", expected: "1.5"})
+	t = append(t, TestCode{title: "float /", code: "(/ 1.0 4)", expected: "0.25"})
+	t = append(t, TestCode{title: "rational literal", code: "6/4", expected: "3/2"})
+	t = append(t, TestCode{title: "rational +", code: "(+ 1/3 2/3)", expected: "1"})
+	t = append(t, TestCode{title: "rational float", code: "(* 1/2 3.0)", expected: "1.5"})
+	t = append(t, TestCode{title: "big literal", code: "123456789012345678901234567890", expected: "123456789012345678901234567890"})
+	t = append(t, TestCode{title: "overflow +", code: "(+ 9223372036854775807 1)", expected: "9223372036854775808"})
+	t = append(t, TestCode{title: "overflow *", code: "(* 4294967296 4294967296)", expected: "18446744073709551616"})
+	t = append(t, TestCode{title: "big demote", code: "(- 9223372036854775808 1)", expected: "9223372036854775807"})
+	t = append(t, TestCode{title: "big <", code: "(< 1 123456789012345678901234567890)", expected: "true"})
+	t = append(t, TestCode{title: "rational <", code: "(< 1/3 0.34)", expected: "true"})
+	t = append(t, TestCode{title: "big =", code: "(= 123456789012345678901234567890 123456789012345678901234567890)", expected: "true"})
+	t = append(t, TestCode{title: "minus symbol", code: "(quote -)", expected: "-"})
+
+	// Comparison
+	t = append(t, TestCode{title: "< chained", code: "(< 1 2 3)", expected: "true"})
+	t = append(t, TestCode{title: "< chained false", code: "(< 1 3 2)", expected: "false"})
+	t = append(t, TestCode{title: ">= chained", code: "(>= 3 3 1)", expected: "true"})
+	t = append(t, TestCode{title: "= numeric", code: "(= 1 1.0 2/2)", expected: "true"})
+	t = append(t, TestCode{title: "= lists", code: "(= '(1 2) [1 2])", expected: "true"})
+
+	// Math library
+	t = append(t, TestCode{title: "quotient", code: "(quotient -7 2)", expected: "-3"})
+	t = append(t, TestCode{title: "remainder", code: "(remainder -7 2)", expected: "-1"})
+	t = append(t, TestCode{title: "modulo", code: "(modulo -7 2)", expected: "1"})
+	t = append(t, TestCode{title: "abs", code: "(abs -5/3)", expected: "5/3"})
+	t = append(t, TestCode{title: "min", code: "(min 3 1 2)", expected: "1"})
+	t = append(t, TestCode{title: "max inexact", code: "(max 1 2.0 3)", expected: "3.0"})
+	t = append(t, TestCode{title: "expt", code: "(expt 2 100)", expected: "1267650600228229401496703205376"})
+	t = append(t, TestCode{title: "expt negative", code: "(expt 2/3 -2)", expected: "9/4"})
+	t = append(t, TestCode{title: "expt float", code: "(expt 4 0.5)", expected: "2.0"})
+	t = append(t, TestCode{title: "sqrt exact", code: "(sqrt 16/9)", expected: "4/3"})
+	t = append(t, TestCode{title: "sqrt inexact", code: "(sqrt 2)", expected: "1.4142135623730951"})
+	t = append(t, TestCode{title: "sin", code: "(sin 0)", expected: "0.0"})
+	t = append(t, TestCode{title: "cos", code: "(cos 0)", expected: "1.0"})
+	t = append(t, TestCode{title: "atan2", code: "(atan 1 1)", expected: "0.7853981633974483"})
+	t = append(t, TestCode{title: "floor", code: "(floor -7/2)", expected: "-4"})
+	t = append(t, TestCode{title: "ceiling", code: "(ceiling -7/2)", expected: "-3"})
+	t = append(t, TestCode{title: "round even", code: "(round 5/2)", expected: "2"})
+	t = append(t, TestCode{title: "round float", code: "(round 3.5)", expected: "4.0"})
+	t = append(t, TestCode{title: "truncate", code: "(truncate -2.7)", expected: "-2.0"})
+	t = append(t, TestCode{title: "exact", code: "(inexact->exact 0.5)", expected: "1/2"})
+
+	// list
+	t = append(t, TestCode{title: "list 1", code: "(list)", expected: "()"})
+	t = append(t, TestCode{title: "list 2", code: "(list 1)", expected: "(1)"})
+	t = append(t, TestCode{title: "list 3", code: "(list '(1 2) '(3 4))", expected: "((1 2) (3 4))"})
+
+	// Scoping
+	t = append(t, TestCode{title: "closure", code: "(let* (make (lambda (n) (lambda (x) (+ x n)))) ((make 3) 4))", expected: "7"})
+	t = append(t, TestCode{title: "nested closures", code: "((((lambda (a) (lambda (b) (lambda (c) (list a b c)))) 1) 2) 3)", expected: "(1 2 3)"})
+	t = append(t, TestCode{title: "recursive let*", code: "(let* (f (lambda (n) (if (= n 0) 0 (+ n (f (- n 1)))))) (f 10))", expected: "55"})
+	t = append(t, TestCode{title: "let* rebinding", code: "(let* (x 1 x (+ x 1)) x)", expected: "2"})
+	t = append(t, TestCode{title: "local define", code: "((lambda (x) (do (define y (* x 2)) (+ x y))) 3)", expected: "9"})
+	t = append(t, TestCode{title: "rest parameter", code: "((lambda (a & more) (list a more)) 1 2 3)", expected: "(1 (2 3))"})
+	t = append(t, TestCode{title: "catch* in lambda", code: "((lambda (x) (try* (throw x) (catch* e (list e x)))) 1)", expected: "(1 1)"})
+	t = append(t, TestCode{title: "tail calls", code: "(let* (loop (lambda (n acc) (if (= n 0) acc (loop (- n 1) (+ acc 1))))) (loop 100000 0))", expected: "100000"})
+	t = append(t, TestCode{title: "macro in top-level do", code: "(do (defmacro! twice (lambda (x) (list 'do x x))) (twice 1))", expected: "1"})
+	t = append(t, TestCode{title: "closures capture each call", code: "(let* (mk (lambda (n) (lambda () n)) a (mk 1) b (mk 2)) (list (a) (b)))", expected: "(1 2)"})
+	t = append(t, TestCode{title: "global defined later", code: "(do (define g (lambda () later)) (define later 5) (g))", expected: "5"})
+	t = append(t, TestCode{title: "catch* shadows parameter", code: "((lambda (e) (list (try* (throw 2) (catch* e e)) e)) 1)", expected: "(2 1)"})
+	t = append(t, TestCode{title: "local shadows macro", code: "(let* (or (lambda (a b) (list b a))) (or 1 2))", expected: "(2 1)"})
+	t = append(t, TestCode{title: "macro expanded once", code: "(do (define n (atom 0)) (defmacro! counted (lambda (x) (do (swap! n (lambda (v) (+ v 1))) x))) (define f (lambda (x) (counted x))) (f 1) (f 2) (f 3) (deref n))", expected: "1"})
+
+	// Hygienic macros
+	t = append(t, TestCode{title: "syntax-rules", code: "(do (define-syntax my-if (syntax-rules () ((_ c a b) (cond c a :else b)))) (my-if false 1 2))", expected: "2"})
+	t = append(t, TestCode{title: "syntax-rules ellipsis", code: "(do (define-syntax my-list (syntax-rules () ((_ x ...) (list x ...)))) (list (my-list) (my-list 1 2 3)))", expected: "(() (1 2 3))"})
+	t = append(t, TestCode{title: "syntax-rules nested ellipsis", code: "(do (define-syntax my-let (syntax-rules () ((_ ((n v) ...) body) ((lambda (n ...) body) v ...)))) (my-let ((a 1) (b 2)) (+ a b)))", expected: "3"})
+	t = append(t, TestCode{title: "syntax-rules nested ellipses", code: "(do (define-syntax flat (syntax-rules () ((_ (x ...) ...) '(x ... ...)))) (flat (1 2) () (3)))", expected: "(1 2 3)"})
+	t = append(t, TestCode{title: "syntax-rules literals", code: "(do (define-syntax arrow (syntax-rules (=>) ((_ a => b) (list a b)) ((_ a b c) :other))) (list (arrow 1 => 2) (arrow 1 2 3) (let* (=> 0) (arrow 1 => 2))))", expected: "((1 2) :other :other)"})
+	t = append(t, TestCode{title: "syntax-rules tail pattern", code: "(do (define-syntax final (syntax-rules () ((_ x ... y) y))) (final 1 2 3))", expected: "3"})
+	t = append(t, TestCode{title: "syntax-rules vector pattern", code: "(do (define-syntax sum2 (syntax-rules () ((_ [a b]) (+ a b)))) (sum2 [1 2]))", expected: "3"})
+	t = append(t, TestCode{title: "syntax-rules escaped ellipsis", code: "(do (define-syntax ell (syntax-rules () ((_) '(... ...)))) (ell))", expected: "..."})
+	t = append(t, TestCode{title: "syntax-rules quote", code: "(do (define-syntax q (syntax-rules () ((_) 'x))) (list (q) (symbol? (q))))", expected: "(x true)"})
+	t = append(t, TestCode{title: "hygiene binding", code: "(let* (t 5) (or false t))", expected: "5"})
+	t = append(t, TestCode{title: "hygiene free symbol", code: "(do (define-syntax first-of (syntax-rules () ((_ xs) (first xs)))) (let* (first (lambda (x) :shadowed)) (first-of [1 2])))", expected: "1"})
+	t = append(t, TestCode{title: "hygiene special form", code: "(do (define-syntax my-when (syntax-rules () ((_ c x) (if c x nil)))) (let* (if list) (my-when true 1)))", expected: "1"})
+	t = append(t, TestCode{title: "let-syntax", code: "(let-syntax (twice (syntax-rules () ((_ e) (do e e)))) (let* (a (atom 0)) (do (twice (swap! a + 1)) @a)))", expected: "2"})
+	t = append(t, TestCode{title: "let-syntax free local", code: "(let* (k 10) (let-syntax (add-k (syntax-rules () ((_ e) (+ e k)))) (let* (k 1) (add-k k))))", expected: "11"})
+	t = append(t, TestCode{title: "let-syntax in lambda", code: "(let* (k 10) (let-syntax (add-k (syntax-rules () ((_ e) (+ e k)))) ((lambda (k) (add-k k)) 1)))", expected: "11"})
+	t = append(t, TestCode{title: "letrec-syntax", code: "(letrec-syntax (my-and (syntax-rules () ((_) true) ((_ x) x) ((_ x y ...) (if x (my-and y ...) false)))) (list (my-and) (my-and 1 2 3) (my-and 1 nil 3)))", expected: "(true 3 false)"})
+	t = append(t, TestCode{title: "local define-syntax", code: "((lambda (x) (do (define-syntax dbl (syntax-rules () ((_ e) (* 2 e)))) (dbl x))) 4)", expected: "8"})
+	t = append(t, TestCode{title: "macro-defining macro", code: "(do (define-syntax def-const (syntax-rules () ((_ name v) (define-syntax name (syntax-rules () ((_) v)))))) (def-const five 5) (five))", expected: "5"})
+	t = append(t, TestCode{title: "macroexpand-1", code: "(macroexpand-1 (or 1 2))", expected: "(let* (t 1) (if t t (or 2)))"})
+	t = append(t, TestCode{title: "macroexpand-1 defmacro!", code: "(do (defmacro! unless (lambda (c x) (list 'if c nil x))) (list (macroexpand-1 (unless a b)) (macroexpand-1 (f a))))", expected: "((if a nil b) (f a))"})
+	t = append(t, TestCode{title: "macroexpand-all", code: "(macroexpand-all (cond a 1 b (or c)))", expected: "(if a 1 (if b c nil))"})
+	t = append(t, TestCode{title: "macroexpand-all shadowing", code: "(macroexpand-all (lambda (or) (list (or 1 2) '(or 3))))", expected: "(lambda (or) (list (or 1 2) (quote (or 3))))"})
+	t = append(t, TestCode{title: "macroexpand-all let-syntax", code: "(macroexpand-all (let-syntax (m (syntax-rules () ((_ x) (list x)))) (m (m 1))))", expected: "(list (list 1))"})
+
+	// Prelude
+	t = append(t, TestCode{title: "and", code: "(list (and) (and 1 2) (and 1 nil 3) (and false (throw \"no\")))", expected: "(true 2 nil false)"})
+	t = append(t, TestCode{title: "when unless", code: "(list (when true 1 2) (when false 1) (unless false 3) (unless true 3))", expected: "(2 nil 3 nil)"})
+	t = append(t, TestCode{title: "let", code: "(let [a 1 b (+ a 1)] (list a b))", expected: "(1 2)"})
+	t = append(t, TestCode{title: "let destructuring", code: "(let [[a b & more] '(1 2 3 4) {c :c :keys [d e]} {:c 3 :d 4}] (list a b more c d e))", expected: "(1 2 (3 4) 3 4 nil)"})
+	t = append(t, TestCode{title: "let nested destructuring", code: "(let [[x [y z]] [1 [2 3]] [p q] [1]] (list x y z p q))", expected: "(1 2 3 1 nil)"})
+	t = append(t, TestCode{title: "named let", code: "(let loop [i 0 acc []] (if (< i 3) (loop (+ i 1) (conj acc i)) acc))", expected: "[0 1 2]"})
+	t = append(t, TestCode{title: "named let tail calls", code: "(let loop [n 100000] (if (= n 0) :done (loop (- n 1))))", expected: ":done"})
+	t = append(t, TestCode{title: "defn", code: "(do (defn sq [x] (* x x)) (defn cube \"Cubes x.\" [x] (* x (sq x))) (list (sq 3) (cube 2) (get (meta cube) :doc)))", expected: "(9 8 \"Cubes x.\")"})
+	t = append(t, TestCode{title: "defn source", code: "(do (defn sq [x] (* x x)) (get (meta sq) :source))", expected: "(defn sq [x] (* x x))"})
+	t = append(t, TestCode{title: "apropos", code: "(do (define my-swapper 1) (apropos \"swap\"))", expected: "(my-swapper swap!)"})
+	t = append(t, TestCode{title: "->", code: "(-> 5 (- 2) (list 1) first)", expected: "3"})
+	t = append(t, TestCode{title: "->>", code: "(->> [1 2 3] (map (lambda (x) (* x x))) (filter (lambda (x) (> x 1))))", expected: "(4 9)"})
+	t = append(t, TestCode{title: "reduce", code: "(list (reduce + [1 2 3]) (reduce + 10 [1 2 3]) (reduce + []) (reduce + '(5)))", expected: "(6 16 0 5)"})
+	t = append(t, TestCode{title: "fold-left fold-right", code: "(list (fold-left list 0 [1 2]) (fold-right list 0 [1 2]) (fold-right + 0 (range 200000)))", expected: "(((0 1) 2) (1 (2 0)) 19999900000)"})
+	t = append(t, TestCode{title: "filter", code: "(list (filter (lambda (x) (> x 1)) [1 2 3]) (filter nil? []))", expected: "((2 3) ())"})
+	t = append(t, TestCode{title: "range", code: "(list (range 3) (range 1 4) (range 10 0 -3) (range 0))", expected: "((0 1 2) (1 2 3) (10 7 4 1) ())"})
+	t = append(t, TestCode{title: "take drop", code: "(list (take 2 [1 2 3]) (take 5 '(1)) (drop 2 [1 2 3]) (drop 5 [1]) (drop 0 nil))", expected: "((1 2) (1) (3) () ())"})
+	t = append(t, TestCode{title: "partition", code: "(list (partition 2 [1 2 3 4 5]) (partition 2 1 [1 2 3]))", expected: "(((1 2) (3 4)) ((1 2) (2 3)))"})
+	t = append(t, TestCode{title: "assoc-in update-in", code: "(list (assoc-in {} [:a :b] 1) (assoc-in {:a {:c 2}} [:a :b] 1) (update-in {:a {:n 1}} [:a :n] + 10) (get-in {:a {:b 1}} [:a :b]))", expected: "({:a {:b 1}} {:a {:c 2 :b 1}} {:a {:n 11}} 1)"})
+
+	// Collections
+	t = append(t, TestCode{title: "conj vector", code: "(let* (v [1 2]) (list (conj v 3) (conj v 4) v))", expected: "([1 2 3] [1 2 4] [1 2])"})
+	t = append(t, TestCode{title: "assoc vector", code: "(let* (v [1 2 3]) (list (assoc v 0 :a 3 4) v))", expected: "([:a 2 3 4] [1 2 3])"})
+	t = append(t, TestCode{title: "assoc hash-map", code: "(let* (m {\"a\" 1}) (list (get (assoc m \"b\" 2) \"b\") (count m)))", expected: "(2 1)"})
+	t = append(t, TestCode{title: "dissoc hash-map", code: "(let* (m {\"a\" 1 \"b\" 2}) (list (dissoc m \"a\") (count m)))", expected: "({\"b\" 2} 2)"})
+	t = append(t, TestCode{title: "non-string keys", code: "{1 \"a\" [1 2] \"b\"}", expected: "{1 \"a\" [1 2] \"b\"}"})
+	t = append(t, TestCode{title: "get non-string key", code: "(list (get {1 :a [1 2] :b} '(1 2)) (get {'x 1} 'x) (contains? {nil 1} nil))", expected: "(:b 1 true)"})
+	t = append(t, TestCode{title: "assoc non-string key", code: "(keys (dissoc (assoc {} 1 :a 2 :b) 1))", expected: "(2)"})
+	t = append(t, TestCode{title: "builtin identity", code: "(list (= nil? true?) (= nil? nil?) (get {number? 1} string?) (get {number? 1} number?))", expected: "(false true nil 1)"})
+	t = append(t, TestCode{title: "hash-map equality", code: "(= {1 :a [1] :b} (hash-map '(1) :b 1 :a))", expected: "true"})
+	t = append(t, TestCode{title: "keyword lookup", code: "(list (:a {:a 1}) (:b {:a 1}) (:b {:a 1} 2) (:a nil))", expected: "(1 nil 2 nil)"})
+	t = append(t, TestCode{title: "keyword higher-order", code: "(map :x [{:x 1} {:x 2}])", expected: "(1 2)"})
+	t = append(t, TestCode{title: "keyword identity", code: "(list (= :a (keyword \"a\")) (keyword? :a) (string? :a) (keyword? \"\u029ea\"))", expected: "(true true false false)"})
+	t = append(t, TestCode{title: "nth vector", code: "(nth [1 2 3] 2)", expected: "3"})
+
+	// Concurrency
+	t = append(t, TestCode{title: "go", code: "@(go (+ 1 2))", expected: "3"})
+	t = append(t, TestCode{title: "spawn", code: "(deref (spawn + 1 2))", expected: "3"})
+	t = append(t, TestCode{title: "realized?", code: "(let* (f (go 1)) (do @f (realized? f)))", expected: "true"})
+	t = append(t, TestCode{title: "deref timeout", code: "(deref (go (sleep 1000)) 10 :slow)", expected: ":slow"})
+	t = append(t, TestCode{title: "future error", code: "(try* @(go (throw \"x\")) (catch* e e))", expected: "\"x\""})
+	t = append(t, TestCode{title: "unbuffered chan", code: "(let* (c (chan)) (do (go (>! c 42)) (<! c)))", expected: "42"})
+	t = append(t, TestCode{title: "close!", code: "(let* (c (chan 2)) (do (>! c 1) (>! c 2) (close! c) (list (<! c) (<! c) (<! c) (>! c 3))))", expected: "(1 2 nil false)"})
+	t = append(t, TestCode{title: "fan out", code: "(let* (c (chan)) (do (map (lambda (i) (go (>! c (* i i)))) '(1 2 3)) (+ (<! c) (<! c) (<! c))))", expected: "14"})
+	t = append(t, TestCode{title: "select receive", code: "(let* (c (chan 1)) (do (>! c 7) (select [v (chan)] :wrong [v c] (+ v 1))))", expected: "8"})
+	t = append(t, TestCode{title: "select send", code: "(let* (c (chan 1)) (select [ok c 5] (list ok (<! c))))", expected: "(true 5)"})
+	t = append(t, TestCode{title: "select timeout", code: "(select [v (chan)] v [v (timeout 10)] :timeout)", expected: ":timeout"})
+	t = append(t, TestCode{title: "select default", code: "(select [v (chan)] v :default :none)", expected: ":none"})
+	t = append(t, TestCode{title: "select temporaries", code: "(let* (res 1 idx 2) (select [v (chan)] v :default (list res idx)))", expected: "(1 2)"})
+	t = append(t, TestCode{title: "compare-and-set!", code: "(let* (a (atom 1)) (list (compare-and-set! a 2 3) (compare-and-set! a 1 3) @a))", expected: "(false true 3)"})
+	t = append(t, TestCode{title: "compare-and-set! of ratios and bignums", code: "(list (compare-and-set! (atom 1/2) 1/2 3) (compare-and-set! (atom 100000000000000000000) 100000000000000000000 3))", expected: "(true true)"})
+	t = append(t, TestCode{title: "compare-and-set! by identity", code: "(let* (a (atom [1])) (list (compare-and-set! a [1] 2) (compare-and-set! a @a 2) @a))", expected: "(false true 2)"})
+	t = append(t, TestCode{title: "add-watch", code: "(let* (a (atom 1) log (atom nil)) (do (add-watch a :k (lambda (k r old new) (reset! log (list k old new)))) (swap! a + 10) @log))", expected: "(:k 1 11)"})
+	t = append(t, TestCode{title: "remove-watch", code: "(let* (a (atom 1) log (atom nil)) (do (add-watch a :k (lambda (k r old new) (reset! log new))) (remove-watch a :k) (reset! a 2) @log))", expected: "nil"})
+	t = append(t, TestCode{title: "alts!", code: "(let* (c (chan 1)) (do (>! c :x) (alts! [(chan) c])))", expected: "[:x 1]"})
+
+	// Continuations
+	t = append(t, TestCode{title: "call/cc return", code: "(call/cc (lambda (k) 1))", expected: "1"})
+	t = append(t, TestCode{title: "call/cc escape", code: "(+ 1 (call/cc (lambda (k) (+ 10 (k 2)))))", expected: "3"})
+	t = append(t, TestCode{title: "call/cc from map", code: "(call/cc (lambda (return) (map (lambda (x) (if (= x 3) (return x) x)) '(1 2 3 4))))", expected: "3"})
+	t = append(t, TestCode{title: "call/cc through try*", code: "(call/cc (lambda (k) (try* (k 1) (catch* e 2))))", expected: "1"})
+	t = append(t, TestCode{title: "call/cc nested", code: "(call/cc (lambda (outer) (+ 1 (call/cc (lambda (inner) (outer 5))))))", expected: "5"})
+	t = append(t, TestCode{title: "dynamic-wind", code: "(let* (log (atom [])) (do (dynamic-wind (lambda () (swap! log conj :before)) (lambda () (swap! log conj :during)) (lambda () (swap! log conj :after))) @log))", expected: "[:before :during :after]"})
+	t = append(t, TestCode{title: "dynamic-wind escape", code: "(let* (log (atom [])) (do (call/cc (lambda (k) (dynamic-wind (lambda () (swap! log conj :in)) (lambda () (k 1)) (lambda () (swap! log conj :out))))) @log))", expected: "[:in :out]"})
+	t = append(t, TestCode{title: "dynamic-wind error", code: "(let* (log (atom [])) (list (try* (dynamic-wind (lambda () nil) (lambda () (throw :oops)) (lambda () (swap! log conj :out))) (catch* e e)) @log))", expected: "(:oops [:out])"})
+
+	// Lazy sequences
+	t = append(t, TestCode{title: "infinite range", code: "(take 5 (map (lambda (x) (* x x)) (range)))", expected: "(0 1 4 9 16)"})
+	t = append(t, TestCode{title: "lazy filter", code: "(nth (filter (lambda (x) (= 0 (modulo x 1000))) (range)) 10)", expected: "10000"})
+	t = append(t, TestCode{title: "long lazy chain", code: "(list (first (filter (lambda (x) (> x 100000)) (range))) (nth (iterate (lambda (x) (+ x 1)) 0) 100000))", expected: "(100001 100000)"})
+	t = append(t, TestCode{title: "lazy map is lazy", code: "(let* (n (atom 0) xs (map (lambda (x) (swap! n + 1)) (range 10))) (list @n (first xs) @n (count xs) @n))", expected: "(0 1 1 10 10)"})
+	t = append(t, TestCode{title: "lazy-seq", code: "(let* (ones (lambda () (lazy-seq (cons 1 (ones))))) (take 3 (ones)))", expected: "(1 1 1)"})
+	t = append(t, TestCode{title: "lazy seq functions", code: "(list (rest (range 3)) (seq (range 0)) (empty? (range 0)) (count (range 4)) (cons 9 (range 2)) (conj (range 2) 9) (drop 5 (range 7)))", expected: "((1 2) nil true 4 (9 0 1) (9 0 1) (5 6))"})
+	t = append(t, TestCode{title: "lazy seq equality", code: "(list (= (range 3) '(0 1 2)) (= [0 1] (range 2)) (get {'(0 1) :x} (range 2)) (sequential? (range 1)) (list? (range 1)))", expected: "(true true :x true false)"})
+	t = append(t, TestCode{title: "lazy destructuring", code: "(let [[a b & more] (range)] (list a b (take 2 more)))", expected: "(0 1 (2 3))"})
+	t = append(t, TestCode{title: "repeat", code: "(list (repeat 2 :x) (take 3 (repeat 1)))", expected: "((:x :x) (1 1 1))"})
+	t = append(t, TestCode{title: "delay", code: "(let* (n (atom 0) d (delay (swap! n + 1) :v)) (list (realized? d) (force d) @d @n (realized? d) (force 5)))", expected: "(false :v :v 1 true 5)"})
+	t = append(t, TestCode{title: "lazy error", code: "(try* (count (map (lambda (x) (/ 1 x)) (range -1 2))) (catch* e (get e :kind)))", expected: ":arithmetic-error"})
+
+	t = append(t, TestCode{title: "Quote", code: "(quote (testing 1 (2.0) -3.14e159))", expected: "(testing 1 (2.0) -3.14e159)"})
+	t = append(t, TestCode{title: "If", code: "(if 1 2)", expected: "2"})
+	t = append(t, TestCode{title: "If2", code: "(if (= 3 4) 2)", expected: "nil"})
+	t = append(t, TestCode{title: "Else", code: "(if (= 3 4) 2 5)", expected: "5"})
+	return t
+}
+
+func newErrorCodeArray() []TestCode {
+	t := make([]TestCode, 0, 0)
+	t = append(t, TestCode{title: "Syntax Error", code: "(1)"})
+	t = append(t, TestCode{title: "Division by zero", code: "(/ 1 0)"})
+	t = append(t, TestCode{title: "Rational zero denominator", code: "1/0"})
+	t = append(t, TestCode{title: "Non-number", code: "(+ 1 \"a\")"})
+	t = append(t, TestCode{title: "Missing argument", code: "(-)"})
+	t = append(t, TestCode{title: "Modulo by zero", code: "(modulo 1 0)"})
+	t = append(t, TestCode{title: "Comparison of non-number", code: "(< 1 nil)"})
+	t = append(t, TestCode{title: "Empty try*", code: "(try*)"})
+	t = append(t, TestCode{title: "Malformed catch*", code: "(try* (throw 1) (catch*))"})
+	t = append(t, TestCode{title: "Bad unquote", code: "`(1 (unquote))"})
+	t = append(t, TestCode{title: "Rest parameter", code: "((lambda (& 1) 1))"})
+	t = append(t, TestCode{title: "Assoc out of range", code: "(assoc [1] 2 :x)"})
+	t = append(t, TestCode{title: "Keyword lookup in non-map", code: "(:a 1)"})
+	t = append(t, TestCode{title: "Keyword lookup arity", code: "(:a)"})
+	t = append(t, TestCode{title: "Put nil", code: "(>! (chan 1) nil)"})
+	t = append(t, TestCode{title: "Take non-channel", code: "(<! 1)"})
+	t = append(t, TestCode{title: "Spawn non-function", code: "(spawn 1)"})
+	t = append(t, TestCode{title: "Malformed select", code: "(select [v] 1)"})
+	t = append(t, TestCode{title: "Empty alts!", code: "(alts! [])"})
+	t = append(t, TestCode{title: "No syntax-rules match", code: "(do (define-syntax one (syntax-rules () ((_ x) x))) (one))"})
+	t = append(t, TestCode{title: "Malformed syntax-rules", code: "(define-syntax bad (syntax-rules ((_) 1)))"})
+	t = append(t, TestCode{title: "Local macro as value", code: "(let-syntax (m (syntax-rules () ((_) 1))) m)"})
+	t = append(t, TestCode{title: "Missing template ellipsis", code: "(do (define-syntax bad (syntax-rules () ((_ x ...) (list x)))) (bad 1 2))"})
+	t = append(t, TestCode{title: "range arity", code: "(range 1 2 3 4)"})
+	t = append(t, TestCode{title: "let odd bindings", code: "(let [a] a)"})
+	t = append(t, TestCode{title: "partition size", code: "(partition 0 [1])"})
+	t = append(t, TestCode{title: "Realizing a lazy seq", code: "(map (lambda (x) (/ 1 x)) (range -1 2))"})
+	t = append(t, TestCode{title: "lazy-seq* non-function", code: "(lazy-seq* 1)"})
+	t = append(t, TestCode{title: "Stale continuation", code: "(let* (k (call/cc (lambda (k) k))) (k 1))"})
+	t = append(t, TestCode{title: "call/cc non-function", code: "(call/cc 1)"})
+	return t
+}
+
+// forEachBackend runs a test with each backend in turn.
+func forEachBackend(t *testing.T, test func(t *testing.T, backend Backend)) {
+	for _, b := range []Backend{TreeWalker, VM} {
+		t.Run(b.String(), func(t *testing.T) { test(t, b) })
+	}
+}
+
+func TestSuccessCases(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, backend Backend) {
+		it := New(Options{Backend: backend})
+		for _, element := range newSuccessCodeArray() {
+			actual, err := it.Rep(element.code)
+			if err != nil {
+				t.Errorf("eval: %v, title: %v, to expect %v, but outputs the error unexpectedly: %v",
+					element.code, element.title, element.expected, err)
+			} else if actual != element.expected {
+				t.Errorf("eval %v, title: %v, to expect %v, but actual: %v", element.code, element.title, element.expected, actual)
+			}
+		}
+	})
+}
+
+func TestErrorCases(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, backend Backend) {
+		it := New(Options{Backend: backend})
+		for _, element := range newErrorCodeArray() {
+			actual, err := it.Rep(element.code)
+			if err == nil {
+				t.Errorf("eval: %v, title: %v, but actual: %v", element.code, element.title, actual)
+			}
+		}
+	})
+}
+
+// fuzzArgs are the arguments builtins are called with, in every
+// combination of up to three.
+func fuzzArgs(it *Interpreter) []Top {
+	kw, _ := NewKeyword("k")
+	lambda, _ := it.EvalString("(lambda (x) x)")
+	return []Top{
+		nil,
+		true,
+		0,
+		-1,
+		1.5,
+		new(big.Int).Lsh(big.NewInt(1), 100),
+		big.NewRat(1, 3),
+		"",
+		"s",
+		kw,
+		Symbol{"x"},
+		List{},
+		List{[]Top{1, 2}, nil},
+		NewVector(1),
+		HashMap{}.Assoc("a", 1),
+		NewAtom(0),
+		NewFunc(func(a []Top) (Top, error) { return nil, nil }, nil),
+		lambda,
+		GoObject{Val: &struct{ A int }{}},
+	}
+}
+
+// callNoPanic reports a panic from a builtin as a test failure.
+func callNoPanic(t *testing.T, name string, fn func([]Top) (Top, error), args []Top) {
+	defer func() {
+		if r := recover(); r != nil {
+			t.Errorf("%v panicked with args %v: %v", name, fmt.Sprintf("%#v", args), r)
+		}
+	}()
+	_, e := fn(args)
+	if e != nil {
+		if _, ok := e.(LGError); !ok {
+			t.Errorf("%v returned a non-LGError error: %v", name, e)
+		}
+	}
+}
+
+// TestBuiltinsDoNotPanic calls every builtin function and macro of an
+// interpreter, those of package core and bridge and those it defines
+// itself, with all sorts of arguments.
+func TestBuiltinsDoNotPanic(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fuzz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	it := New(Options{Stdin: strings.NewReader(""), Stdout: ioutil.Discard, LoadPath: []string{dir}})
+	core := it.namespaces.intern(CoreNamespace)
+	samples := fuzzArgs(it)
+	// Interrupted from the start, so that no Builtin waits.
+	stopped := caller{task: newTask()}
+	stopped.task.interrupt()
+	for _, name := range core.Defined() {
+		var fn func([]Top) (Top, error)
+		switch val, _ := core.Get(Symbol{name}); val := val.(type) {
+		case Func:
+			fn = val.Fn
+		case Macro:
+			fn = val.Fn
+		case Builtin:
+			fn = func(a []Top) (Top, error) { return val.Fn(stopped, a) }
+		}
+		if fn == nil {
+			continue
+		}
+		callNoPanic(t, name, fn, []Top{})
+		for _, a := range samples {
+			callNoPanic(t, name, fn, []Top{a})
+			for _, b := range samples {
+				callNoPanic(t, name, fn, []Top{a, b})
+				for _, c := range samples {
+					callNoPanic(t, name, fn, []Top{a, b, c})
+				}
+			}
+		}
+	}
+}
+
+func TestSelectAndGoAreHygienic(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, backend Backend) {
+		it := New(Options{Backend: backend})
+		actual, err := it.EvalString(`(define nth :nth) (define = :=) (define alts! :alts) (define spawn :spawn)
+(let* (c (chan 1) nth 0 = 1 spawn 2)
+  (list @(go (+ 1 2)) (do (>! c 7) (select [v (chan)] :wrong [v c] (+ v 1)))))`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s := printer.PrintString(actual, true); s != "(3 8)" {
+			t.Errorf("expected (3 8), actual: %v", s)
+		}
+	})
+}
+
+func TestDivisionByZeroIsCatchable(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, backend Backend) {
+		it := New(Options{Backend: backend})
+		actual, err := it.Rep("(try* (/ 1 0) (catch* e (list (get e :kind) (get e :builtin))))")
+		expected := `(:arithmetic-error "/")`
+		if err != nil {
+			t.Errorf("try* did not catch division by zero: %v", err)
+		} else if actual != expected {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+	})
+}
+
+func TestTailCalls(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, backend Backend) {
+		it := New(Options{Backend: backend})
+		src := `(define even? (lambda (n) (if (= n 0) true (odd? (- n 1)))))
+(define odd? (lambda (n) (cond (= n 0) false :else (even? (- n 1)))))
+(define all? (lambda (n) (or (= n 0) (all? (- n 1)))))
+(define by-apply (lambda (n) (if (= n 0) :done (apply by-apply (list (- n 1))))))
+(define by-catch (lambda (n) (if (= n 0) :done (try* (throw n) (catch* e (by-catch (- n 1)))))))
+(define by-try (lambda (n) (if (= n 0) :done (try* (by-try (- n 1))))))
+(list (even? 1000000) (odd? 1000001) (all? 100000) (by-apply 100000) (by-catch 100000) (by-try 1000000))`
+		actual, err := it.EvalString(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s := printer.PrintString(actual, true); s != "(true true true :done :done :done)" {
+			t.Errorf("expected (true true true :done :done :done), actual: %v", s)
+		}
+
+		// The body of a try* with a catch* is not a tail position: its
+		// handler stays in place, so deep recursion through it fails.
+		it = New(Options{Backend: backend, MaxDepth: 1000})
+		it.Rep("(define guarded (lambda (n) (if (= n 0) :done (try* (guarded (- n 1)) (catch* e (throw e))))))")
+		actual, err = it.EvalString("(try* (guarded 2000) (catch* e (get e :kind)))")
+		if err != nil || actual != InternKeyword(StackOverflowError) {
+			t.Errorf("expected :stack-overflow, actual: %v, %v", actual, err)
+		}
+	})
+}
+
+func TestStackOverflow(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, backend Backend) {
+		it := New(Options{Backend: backend, MaxDepth: 1000})
+		it.Rep("(define sum (lambda (n) (if (= n 0) 0 (+ n (sum (- n 1))))))")
+		if actual, err := it.Rep("(sum 900)"); err != nil || actual != "405450" {
+			t.Errorf("expected 405450, actual: %v, %v", actual, err)
+		}
+		actual, err := it.Rep("(try* (sum 2000) (catch* e (get e :kind)))")
+		if err != nil || actual != ":stack-overflow" {
+			t.Errorf("expected :stack-overflow, actual: %v, %v", actual, err)
+		}
+
+		// Without a limit set, runaway recursion stops at
+		// DefaultMaxDepth rather than exhausting the Go stack, also
+		// when it goes through builtins calling closures back.
+		it = New(Options{Backend: backend})
+		_, err = it.EvalString("(define loop (lambda (n) (+ 1 (loop n)))) (loop 1)")
+		if kind, _ := ErrorField(err.(LGError).Obj, "kind"); kind != InternKeyword(StackOverflowError) {
+			t.Errorf("expected a stack overflow, actual: %v", err)
+		}
+		it.Rep("(define f (lambda (n) (if (= n 0) 0 (+ 1 (first (map f (list (- n 1))))))))")
+		if actual, err := it.Rep("(f 1000)"); err != nil || actual != "1000" {
+			t.Errorf("expected 1000, actual: %v, %v", actual, err)
+		}
+		actual, err = it.Rep("(try* (f 10000000) (catch* e (get e :kind)))")
+		if err != nil || actual != ":stack-overflow" {
+			t.Errorf("through map: expected :stack-overflow, actual: %v, %v", actual, err)
+		}
+		it.Rep("(define g (lambda (n) (if (= n 0) 0 (+ 1 (swap! (atom n) (lambda (x) (g (- x 1))))))))")
+		actual, err = it.Rep("(try* (g 10000000) (catch* e (get e :kind)))")
+		if err != nil || actual != ":stack-overflow" {
+			t.Errorf("through swap!: expected :stack-overflow, actual: %v, %v", actual, err)
+		}
+		it.Rep("(define h (lambda (n) (+ 1 (eval (list 'h n)))))")
+		actual, err = it.Rep("(try* (h 1) (catch* e (get e :kind)))")
+		if err != nil || actual != ":stack-overflow" {
+			t.Errorf("through eval: expected :stack-overflow, actual: %v, %v", actual, err)
+		}
+	})
+}
+
+// TestCallDepthIsPerCall checks that calls on other goroutines do not
+// count towards the depth of a call.
+func TestCallDepthIsPerCall(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, backend Backend) {
+		it := New(Options{Backend: backend})
+		// The future keeps calling builtins 99000 calls deep while the
+		// main goroutine maps.
+		_, err := it.EvalString(`(define ready (chan)) (define stop (atom false))
+(define down (lambda (n) (if (= n 0) 0 (+ 1 (down (- n 1))))))
+(define spin (lambda () (if @stop 0 (do (down 10) (spin)))))
+(define deep (lambda (n) (if (= n 0) (do (>! ready true) (spin)) (+ 1 (deep (- n 1))))))
+(define fut (spawn deep 99000))
+(<! ready)`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual, err := it.Rep("(count (map (lambda (x) (down 2000)) (range 20)))"); err != nil || actual != "20" {
+			t.Errorf("expected 20, actual: %v, %v", actual, err)
+		}
+		if actual, err := it.Rep("(do (reset! stop true) @fut)"); err != nil || actual != "99000" {
+			t.Errorf("expected 99000, actual: %v, %v", actual, err)
+		}
+	})
+}
+
+// TestEvalInFutureIsNotInterrupted checks that eval in a future does
+// not belong to the task of the session that spawned it.
+func TestEvalInFutureIsNotInterrupted(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, backend Backend) {
+		it := New(Options{Backend: backend})
+		s := it.NewSession()
+		done := make(chan error)
+		go func() {
+			_, err := s.Eval(mustRead(t, "(do (define ch (chan)) (define fut (go (eval '(<! ch)))) (<! (chan)))"))
+			done <- err
+		}()
+		for !s.Interrupt() {
+			time.Sleep(time.Millisecond)
+		}
+		<-done
+		if actual, err := s.Rep(mustRead(t, "(do (>! ch 7) @fut)")); err != nil || actual != "7" {
+			t.Errorf("the future was interrupted: %v %v", actual, err)
+		}
+	})
+}
+
+func TestStructuredErrors(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, backend Backend) {
+		it := New(Options{Backend: backend})
+		cases := []TestCode{
+			{title: "arity", code: "(nth '(1 2))", expected: ":arity-error"},
+			{title: "type", code: "(nth '(1 2) \"a\")", expected: ":type-error"},
+			{title: "value", code: "(nth '(1 2) 5)", expected: ":value-error"},
+			{title: "unbound", code: "undefined-symbol", expected: ":unbound-symbol"},
+			{title: "call non-function", code: "(1 2)", expected: ":type-error"},
+			{title: "lambda arity", code: "((lambda (a b) a) 1)", expected: ":arity-error"},
+			{title: "define non-symbol", code: "(define 1 2)", expected: ":syntax-error"},
+			{title: "defmacro! non-function", code: "(defmacro! m 1)", expected: ":type-error"},
+			{title: "let* odd bindings", code: "(let* (a) a)", expected: ":syntax-error"},
+			{title: "if arity", code: "(if)", expected: ":syntax-error"},
+			{title: "lambda params", code: "(lambda (1) 1)", expected: ":syntax-error"},
+			{title: "syntax-rules match", code: "(let-syntax (m (syntax-rules () ((_ x) x))) (m))", expected: ":syntax-error"},
+			{title: "read error", code: "(read-string \"(1 2\")", expected: ":read-error"},
+		}
+		for _, c := range cases {
+			actual, err := it.Rep("(try* " + c.code + " (catch* e (get e :kind)))")
+			if err != nil {
+				t.Errorf("title: %v, %v escaped try*: %v", c.title, c.code, err)
+			} else if actual != c.expected {
+				t.Errorf("title: %v, %v: expected %v, actual: %v", c.title, c.code, c.expected, actual)
+			}
+		}
+	})
+}
+
+func TestErrorRecordsForm(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, backend Backend) {
+		it := New(Options{Backend: backend})
+		actual, err := it.Rep("(try* (+ 1 \"a\") (catch* e (get e :form)))")
+		expected := `(+ 1 "a")`
+		if err != nil {
+			t.Errorf("try* did not catch type error: %v", err)
+		} else if actual != expected {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+	})
+}
+
+func TestThrownValueIsUnchanged(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, backend Backend) {
+		it := New(Options{Backend: backend})
+		actual, err := it.Rep("(try* (throw {:a 1}) (catch* e e))")
+		expected := `{:a 1}`
+		if err != nil {
+			t.Errorf("try* did not catch thrown value: %v", err)
+		} else if actual != expected {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+	})
+}
+
+func TestDefineFunc(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, backend Backend) {
+		it := New(Options{Backend: backend})
+		var err error
+		_, err = it.Rep("(define inc (lambda (a) (+ a 1)))")
+		actual, err := it.Rep("(inc 1)")
+		expected := "2"
+		if err != nil {
+			t.Errorf("define func has an error, %v", err)
+		} else if actual != expected {
+			t.Errorf("define func has an error. expected: %v, actual: %v", expected, actual)
+		}
+	})
+}
+
+func TestLambda(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, backend Backend) {
+		it := New(Options{Backend: backend})
+		var err error
+		_, err = it.Rep("(define sum2 (lambda (a b) (+ a b)))")
+		actual, err := it.Rep("(sum2 1 2)")
+		expected := "3"
+		if err != nil {
+			t.Errorf("define func has an error, %v", err)
+		} else if actual != expected {
+			t.Errorf("define func has an error. expected: %v, actual: %v", expected, actual)
+		}
+	})
+}
+
+func TestBacktrace(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, backend Backend) {
+		it := New(Options{Backend: backend})
+		dir, err := ioutil.TempDir("", "lispgo")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "trace.lisp")
+		src := `(define inner (lambda (x)
+  (+ x "a")))
+(define outer (lambda (x)
+  (do (inner x)
+    nil)))
+(outer 1)
+`
+		if err := ioutil.WriteFile(path, []byte(src), 0600); err != nil {
+			t.Fatal(err)
+		}
+		_, err = it.LoadFile(path)
+		if err == nil {
+			t.Fatal("expected an error")
+		}
+		expected := []string{
+			"inner (" + path + ":2:3)",
+			"outer (" + path + ":4:7)",
+			"<toplevel> (" + path + ":6:1)",
+		}
+		frames := Backtrace(err)
+		if len(frames) != len(expected) {
+			t.Fatalf("expected %d frames, actual: %v", len(expected), frames)
+		}
+		for i, f := range frames {
+			if f.String() != expected[i] {
+				t.Errorf("frame %d: expected %v, actual %v", i, expected[i], f)
+			}
+		}
+	})
+}
+
+func TestBacktraceThroughApply(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, backend Backend) {
+		it := New(Options{Backend: backend})
+		it.Rep("(define bad (lambda (x) (nth x 5)))")
+		_, err := it.Rep("(map bad '((1)))")
+		frames := Backtrace(err)
+		if len(frames) != 2 || frames[0].Name != "bad" || frames[1].Name != "<toplevel>" {
+			t.Errorf("unexpected backtrace: %v", frames)
+		}
+	})
+}
+
+func TestInterpretersAreIndependent(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, backend Backend) {
+		a := New(Options{Backend: backend})
+		b := New(Options{Backend: backend})
+		if _, err := a.EvalString("(define x 1)"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := b.EvalString("x"); err == nil {
+			t.Error("a definition leaked into another interpreter")
+		}
+		if _, err := b.EvalString("(eval '(define x 2))"); err != nil {
+			t.Fatal(err)
+		}
+		if actual, _ := a.Rep("x"); actual != "1" {
+			t.Errorf("eval in one interpreter changed another: x = %v", actual)
+		}
+	})
+}
+
+func TestDefine(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, backend Backend) {
+		it := New(Options{Backend: backend})
+		it.Define("double", func(a []Top) (Top, error) {
+			return a[0].(int) * 2, nil
+		})
+		it.Define("answer", 42)
+		actual, err := it.EvalString("(double answer)")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual != 84 {
+			t.Errorf("expected 84, actual: %v", actual)
+		}
+	})
+}
+
+func TestEvalStringEvaluatesEveryForm(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, backend Backend) {
+		it := New(Options{Backend: backend})
+		actual, err := it.EvalString("(define a 1) (define b 2) (+ a b)")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual != 3 {
+			t.Errorf("expected 3, actual: %v", actual)
+		}
+	})
+}
+
+func TestRepAllPrintsEveryForm(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, backend Backend) {
+		it := New(Options{Backend: backend})
+		var outs []string
+		print := func(out string) { outs = append(outs, out) }
+		err := it.RepAll("(define f\n  (lambda (x) (* x 2)))  (f 3) \"a\" ; done", print)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(outs) != 3 || strings.Join(outs[1:], " ") != `6 "a"` {
+			t.Errorf("unexpected results %q", outs)
+		}
+		outs = nil
+		if err := it.RepAll("(f 1) (f nil) (f 2)", print); err == nil {
+			t.Error("expected an error")
+		}
+		if strings.Join(outs, " ") != "2" {
+			t.Errorf("the forms before the error were not printed: %q", outs)
+		}
+		outs = nil
+		if err := it.RepAll(" ; nothing", print); err != nil || outs != nil {
+			t.Errorf("an empty input printed %q, %v", outs, err)
+		}
+	})
+}
+
+func TestEvalForm(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, backend Backend) {
+		it := New(Options{Backend: backend})
+		actual, err := it.EvalForm(NewList(Symbol{"+"}, 1, 2))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual != 3 {
+			t.Errorf("expected 3, actual: %v", actual)
+		}
+	})
+}
+
+func TestStreams(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, backend Backend) {
+		var out, errOut bytes.Buffer
+		it := New(Options{
+			Backend: backend,
+			Stdin:   strings.NewReader("first\nsecond"),
+			Stdout:  &out,
+			Stderr:  &errOut,
+			Args:    []string{"-v"},
+		})
+		src := `(prn *ARGV*) (printLine (readline "> ")) (prn (readline "")) (prn (readline ""))`
+		if _, err := it.EvalString(src); err != nil {
+			t.Fatal(err)
+		}
+		expected := "(\"-v\")\n> first\n\"second\"\nnil\n"
+		if out.String() != expected {
+			t.Errorf("expected output %q, actual: %q", expected, out.String())
+		}
+
+		_, err := it.EvalString("(car 1)")
+		it.PrintError(err)
+		if !strings.HasPrefix(errOut.String(), "Error: 'car' not found\n  at <toplevel>") {
+			t.Errorf("unexpected error output: %q", errOut.String())
+		}
+	})
+}
+
+type counter struct {
+	N int
+}
+
+func (c *counter) Add(n int) int {
+	c.N += n
+	return c.N
+}
+
+func TestDefineGoValues(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, backend Backend) {
+		it := New(Options{Backend: backend})
+		c := &counter{}
+		it.Define("c", c)
+		it.Define("repeat", strings.Repeat)
+		it.Define("apply-go", func(f func(int) int, x int) int { return f(x) })
+		src := `(go-call c :Add 2)
+(go-call c "Add" (go-field c :N))
+(list (repeat "ab" 2) (apply-go (lambda (x) (* x 10)) (go-field c :N)))`
+		actual, err := it.EvalString(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s := printer.PrintString(actual, true); s != `("abab" 40)` {
+			t.Errorf("expected (\"abab\" 40), actual: %v", s)
+		}
+		if c.N != 4 {
+			t.Errorf("expected N = 4, actual: %v", c.N)
+		}
+	})
+}
+
+// TestConcurrentEvaluation is meant to be run with -race: goroutines
+// share the global environment and an atom.
+func TestConcurrentEvaluation(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, backend Backend) {
+		it := New(Options{Backend: backend})
+		src := `(define counter (atom 0))
+(define work (lambda (i n)
+  (if (= n 0)
+    (eval (list 'define (symbol (str "done" i)) true))
+    (do (swap! counter (lambda (x) (+ x 1)))
+      (work i (- n 1))))))
+(define workers (map (lambda (i) (go (work i 500))) '(1 2 3 4 5 6 7 8)))
+(map deref workers)
+(list @counter done1 done8)`
+		actual, err := it.EvalString(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s := printer.PrintString(actual, true); s != "(4000 true true)" {
+			t.Errorf("expected (4000 true true), actual: %v", s)
+		}
+	})
+}
+
+func BenchmarkFib(b *testing.B) {
+	for _, backend := range []Backend{TreeWalker, VM} {
+		b.Run(backend.String(), func(b *testing.B) {
+			it := New(Options{Backend: backend})
+			if _, err := it.EvalString("(define fib (lambda (n) (if (< n 2) n (+ (fib (- n 1)) (fib (- n 2))))))"); err != nil {
+				b.Fatal(err)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := it.EvalString("(fib 20)"); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkLoop runs a tail-recursive loop whose body uses macros.
+func BenchmarkLoop(b *testing.B) {
+	for _, backend := range []Backend{TreeWalker, VM} {
+		b.Run(backend.String(), func(b *testing.B) {
+			it := New(Options{Backend: backend})
+			if _, err := it.EvalString("(define loop (lambda (i acc) (cond (= i 0) acc (or (= i 5) (= i 7)) (loop (- i 1) (+ acc 2)) true (loop (- i 1) (+ acc 1)))))"); err != nil {
+				b.Fatal(err)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := it.EvalString("(loop 10000 0)"); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkDeepRecursion makes 10000 nested calls.
+func BenchmarkDeepRecursion(b *testing.B) {
+	for _, backend := range []Backend{TreeWalker, VM} {
+		b.Run(backend.String(), func(b *testing.B) {
+			it := New(Options{Backend: backend})
+			if _, err := it.EvalString("(define sum (lambda (n) (if (= n 0) 0 (+ n (sum (- n 1))))))"); err != nil {
+				b.Fatal(err)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := it.EvalString("(sum 10000)"); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkClosures creates closures and reads variables of outer
+// functions.
+func BenchmarkClosures(b *testing.B) {
+	for _, backend := range []Backend{TreeWalker, VM} {
+		b.Run(backend.String(), func(b *testing.B) {
+			it := New(Options{Backend: backend})
+			if _, err := it.EvalString(`(define adder (lambda (a) (lambda (b) (lambda (c) (+ a b c)))))
+(define run (lambda (i acc) (if (= i 0) acc (run (- i 1) (((adder i) acc) 1)))))`); err != nil {
+				b.Fatal(err)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := it.EvalString("(run 10000 0)"); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// writeModules writes the module sources in files, keyed by their path
+// relative to a new temporary directory, and returns the directory.
+func writeModules(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "lispgo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, src := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(src), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestModules(t *testing.T) {
+	dir := writeModules(t, map[string]string{
+		"my/util.lisp": `(ns my.util (:export twice swap))
+(swap! user/loads (lambda (n) (+ n 1)))
+(define helper (lambda (x) (* x 2)))
+(define twice (lambda (x) (helper x)))
+(define-syntax swap
+  (syntax-rules () ((_ a b) (list b a))))
+`,
+		"my/app.lisp": `(ns my.app (:require [my.util :as u]))
+(define run (lambda () (u/twice 21)))
+`,
+		"cycle/a.lisp": "(ns cycle.a (:require cycle.b))\n",
+		"cycle/b.lisp": "(ns cycle.b (:require cycle.a))\n",
+		"broken.lisp":  "(ns broken)\n(undefined-function)\n",
+		"late.lisp":    "(ns late)\n(define value 7)\n",
+	})
+	defer os.RemoveAll(dir)
+	forEachBackend(t, func(t *testing.T, backend Backend) {
+		it := New(Options{Backend: backend, LoadPath: []string{dir}})
+		it.Rep("(define loads (atom 0))")
+		for _, element := range []TestCode{
+			{"require :as", "(do (require '[my.util :as u]) (u/twice 4))", "8"},
+			{"full name", "(my.util/twice 5)", "10"},
+			{"require :refer", "(do (require '[my.util :refer [twice]]) (twice 6))", "12"},
+			{"macro from module", "(do (require '[my.util :refer [swap]]) (swap 1 2))", "(2 1)"},
+			{"module requires module", "(do (require 'my.app) (my.app/run))", "42"},
+			{"loaded once", "@loads", "1"},
+			{"private name", "(try* u/helper (catch* e (get e :kind)))", ":unbound-symbol"},
+			{"private refer", "(try* (require '[my.util :refer [helper]]) (catch* e (get e :kind)))", ":value-error"},
+			{"current namespace", "(ns other.place)\n(define x 1)\n(ns user)\n(try* x (catch* e (get e :kind)))", ":unbound-symbol"},
+			{"in-ns", "(in-ns 'other.place)\n(define y x)\n(in-ns 'user)\nother.place/y", "1"},
+			{"require after in-ns", "(in-ns 'late)\n(in-ns 'user)\n(require 'late)\nlate/value", "7"},
+			{"cyclic require", "(try* (require 'cycle.a) (catch* e (get e :kind)))", ":value-error"},
+			{"missing module", "(try* (require 'no.such.module) (catch* e (get e :kind)))", ":io-error"},
+			{"failed module is not cached", "(try* (require 'broken) (catch* e (get e :kind)))", ":unbound-symbol"},
+			{"failed module is retried", "(try* (require 'broken) (catch* e (get e :kind)))", ":unbound-symbol"},
+		} {
+			actual, err := it.EvalString(element.code)
+			if err != nil {
+				t.Errorf("%s: %v", element.title, err)
+			} else if printer.PrintString(actual, true) != element.expected {
+				t.Errorf("%s: expected %v, actual %v", element.title, element.expected, printer.PrintString(actual, true))
+			}
+		}
+	})
+}
+
+func TestRequireReferAll(t *testing.T) {
+	dir := writeModules(t, map[string]string{
+		"shapes.lisp": "(ns shapes)\n(define area (lambda (w h) (* w h)))\n",
+	})
+	defer os.RemoveAll(dir)
+	forEachBackend(t, func(t *testing.T, backend Backend) {
+		it := New(Options{Backend: backend, LoadPath: []string{"/no/such/dir", dir}})
+		actual, err := it.EvalString("(ns user (:require [shapes :refer :all]))\n(area 3 4)")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if printer.PrintString(actual, true) != "12" {
+			t.Errorf("expected 12, actual %v", printer.PrintString(actual, true))
+		}
+	})
+}
+
+func TestShadowingCoreNames(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, backend Backend) {
+		it := New(Options{Backend: backend})
+		actual, err := it.EvalString(`(define first (lambda (x) :mine))
+(define if-not (lambda (x) (if x false true)))
+(list (first [1 2]) (lispgo.core/first [1 2]) (when true 1) (fold-left + 0 [1 2 3]) (range 3))`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := "(:mine 1 1 6 (0 1 2))"
+		if printer.PrintString(actual, true) != expected {
+			t.Errorf("expected %v, actual %v", expected, printer.PrintString(actual, true))
+		}
+	})
+}
+
+// TestGlobalsAreLookedUpAgain checks that a function sees a global
+// redefined, or shadowed in its namespace, after it was first called.
+func TestGlobalsAreLookedUpAgain(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, backend Backend) {
+		it := New(Options{Backend: backend})
+		actual, err := it.EvalString(`(define n 1)
+(define f (lambda () (list n (first [1 2]) (u/g))))
+(ns u) (define g (lambda () 1)) (in-ns 'user)
+(define before (f))
+(define n 2) (define first (lambda (x) :mine)) (in-ns 'u) (define g (lambda () 2)) (in-ns 'user)
+(list before (f))`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := "((1 1 1) (2 :mine 2))"
+		if printer.PrintString(actual, true) != expected {
+			t.Errorf("expected %v, actual %v", expected, printer.PrintString(actual, true))
+		}
+	})
+}
+
+func TestComplete(t *testing.T) {
+	dir := writeModules(t, map[string]string{
+		"shapes.lisp": "(ns shapes (:export area))\n(define area (lambda (w h) (* w h)))\n(define arena 1)\n",
+	})
+	defer os.RemoveAll(dir)
+	it := New(Options{LoadPath: []string{dir}})
+	if _, err := it.EvalString("(require '[shapes :as sh]) (define swapped 1)"); err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		prefix, expected string
+	}{
+		{"swa", "swap! swapped"},
+		{"sh", "sh/ shapes/"},
+		{"sh/", "sh/area"},
+		{"shapes/ar", "shapes/area"},
+		{"nope/", ""},
+		{"zzz", ""},
+	}
+	for _, c := range cases {
+		if actual := strings.Join(it.Complete(c.prefix), " "); actual != c.expected {
+			t.Errorf("%q: expected %q, actual %q", c.prefix, c.expected, actual)
+		}
+	}
+}
+
+func TestDocAndSource(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, backend Backend) {
+		var out bytes.Buffer
+		it := New(Options{Backend: backend, Stdout: &out})
+		cases := []struct {
+			code, expected string
+		}{
+			{"(defn cube \"Cubes x.\" [x] (* x x x))", ""},
+			{"(doc cube)", "cube [x]\n  Cubes x.\n"},
+			{"(doc 'count)", "count [coll]\n  Returns the number of elements of coll. A lazy sequence is realized to count it.\n"},
+			{"(doc map)", "map [f coll]\n  Returns a list of the values of f called on each element of coll, or a lazy sequence of them if coll is lazy.\n"},
+			{"(doc select)", "select [clauses ...]\nMacro\n  " + core.Docs["select"].Text + "\n"},
+			{"(doc go-call)", "go-call builtin function\n  No documentation.\n"},
+			{"(source cube)", "(defn cube \"Cubes x.\" [x] (* x x x))\n"},
+			{"(define sq (lambda (x) (* x x)))", ""},
+			{"(doc sq)", "sq (x)\n  No documentation.\n"},
+			{"(source 'sq)", "(define sq (lambda (x) (* x x)))\n"},
+		}
+		for _, c := range cases {
+			out.Reset()
+			if _, err := it.Rep(c.code); err != nil {
+				t.Errorf("%s: %v", c.code, err)
+			} else if out.String() != c.expected {
+				t.Errorf("%s: expected %q, actual %q", c.code, c.expected, out.String())
+			}
+		}
+		if _, err := it.Rep("(source count)"); err == nil {
+			t.Error("a builtin has no source")
+		}
+	})
+}
+
+func TestSessionHistory(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, backend Backend) {
+		it := New(Options{Backend: backend})
+		s, other := it.NewSession(), it.NewSession()
+		var outs []string
+		print := func(out string) { outs = append(outs, out) }
+		if err := s.RepAll("1 2 (+ *1 *2) (list *1 *2 *3)", print); err != nil {
+			t.Fatal(err)
+		}
+		if actual := strings.Join(outs, " "); actual != "1 2 3 (3 2 1)" {
+			t.Errorf("expected 1 2 3 (3 2 1), actual %s", actual)
+		}
+		if err := s.RepAll("(nth [] 0)", print); err == nil {
+			t.Fatal("expected an error")
+		}
+		if actual, _ := s.Rep(Symbol{"*e"}); !strings.Contains(actual, ":kind :value-error") {
+			t.Errorf("*e: %s", actual)
+		}
+		if actual, _ := other.Rep(NewList(Symbol{"list"}, Symbol{"*1"}, Symbol{"*e"})); actual != "(nil nil)" {
+			t.Errorf("the other session saw %s", actual)
+		}
+		if _, err := s.Eval(NewList(Symbol{"in-ns"}, NewList(Symbol{"quote"}, Symbol{"scratch"}))); err != nil {
+			t.Fatal(err)
+		}
+		if s.Namespace().Name() != "scratch" || other.Namespace().Name() != "user" {
+			t.Errorf("namespaces: %s and %s", s.Namespace().Name(), other.Namespace().Name())
+		}
+	})
+}
+
+func TestSessionInterrupt(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, backend Backend) {
+		it := New(Options{Backend: backend})
+		s := it.NewSession()
+		if s.Interrupt() {
+			t.Error("an idle session was interrupted")
+		}
+		done := make(chan error)
+		go func() {
+			_, err := s.Eval(mustRead(t, "(do (define spin (lambda (n) (spin (+ n 1)))) (try* (spin 0) (catch* e (spin 0))))"))
+			done <- err
+		}()
+		for !s.Interrupt() {
+			time.Sleep(time.Millisecond)
+		}
+		err := <-done
+		if kind, _ := ErrorField(err.(LGError).Obj, "kind"); kind != InternKeyword(InterruptError) {
+			t.Errorf("expected an interrupted error, actual %v", err)
+		}
+		if actual, err := s.Rep(mustRead(t, "(+ 1 2)")); err != nil || actual != "3" {
+			t.Errorf("the session stayed interrupted: %v %v", actual, err)
+		}
+	})
+}
+
+func TestSessionInterruptWakesBuiltins(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, backend Backend) {
+		it := New(Options{Backend: backend})
+		s := it.NewSession()
+		for _, code := range []string{
+			"(map (lambda (x) @(go (<! (chan)))) [1])",
+			"(sleep 1000000)",
+			"(do (define ch (chan)) (define fut (go (<! ch))) (<! (chan)))",
+		} {
+			done := make(chan error)
+			go func() {
+				_, err := s.Eval(mustRead(t, code))
+				done <- err
+			}()
+			for !s.Interrupt() {
+				time.Sleep(time.Millisecond)
+			}
+			select {
+			case err := <-done:
+				if kind, _ := ErrorField(err.(LGError).Obj, "kind"); kind != InternKeyword(InterruptError) {
+					t.Errorf("%v: expected an interrupted error, actual %v", code, err)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("%v: not woken by the interrupt", code)
+			}
+		}
+		// The future spawned by the interrupted form goes on.
+		if _, err := s.Eval(mustRead(t, "(>! ch 7)")); err != nil {
+			t.Fatal(err)
+		}
+		if actual, err := s.Rep(mustRead(t, "@fut")); err != nil || actual != "7" {
+			t.Errorf("the future was interrupted: %v %v", actual, err)
+		}
+	})
+}
+
+func TestSessionPrintsLazyResults(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, backend Backend) {
+		it := New(Options{Backend: backend})
+		s := it.NewSession()
+		expected := "(0 1 2 3 4 5 6 7 8 9 10 11 12 13 14 15 16 17 18 19 20 21 22 23 24 25 26 27 28 29 30 31 32 33 34 35 36 37 38 39 40 41 42 43 44 45 46 47 48 49 50 51 52 53 54 55 56 57 58 59 60 61 62 63 64 65 66 67 68 69 70 71 72 73 74 75 76 77 78 79 80 81 82 83 84 85 86 87 88 89 90 91 92 93 94 95 96 97 98 99 ...)"
+		if actual, err := s.Rep(mustRead(t, "(range)")); err != nil || actual != expected {
+			t.Errorf("expected %v, actual: %v, %v", expected, actual, err)
+		}
+		s.Eval(mustRead(t, "(define *print-length* 2)"))
+		if actual, err := s.Rep(mustRead(t, "[(range) [1 2 3] '(1 2)]")); err != nil || actual != "[(0 1 ...) [1 2 ...] ...]" {
+			t.Errorf("expected [(0 1 ...) [1 2 ...] ...], actual: %v, %v", actual, err)
+		}
+		if actual, err := s.Rep(mustRead(t, "{:a 1 :b 2 :c 3}")); err != nil || strings.Count(actual, ":") != 2 || !strings.HasSuffix(actual, " ...}") {
+			t.Errorf("expected two entries and ..., actual: %v, %v", actual, err)
+		}
+
+		// Without a limit, printing an infinite sequence goes on until
+		// it is interrupted.
+		s.Eval(mustRead(t, "(define *print-length* nil)"))
+		done := make(chan error)
+		go func() {
+			_, err := s.Rep(mustRead(t, "(range)"))
+			done <- err
+		}()
+		for !s.Interrupt() {
+			time.Sleep(time.Millisecond)
+		}
+		select {
+		case err := <-done:
+			if kind, _ := ErrorField(err.(LGError).Obj, "kind"); kind != InternKeyword(InterruptError) {
+				t.Errorf("expected an interrupted error, actual %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("printing was not interrupted")
+		}
+	})
+}
+
+func TestInterruptLeavesOtherSessions(t *testing.T) {
+	it := New(Options{})
+	s, other := it.NewSession(), it.NewSession()
+	if _, err := s.Eval(mustRead(t, "(do (define ch (chan)) (define fut (go (<! ch))))")); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error)
+	go func() {
+		_, err := other.Eval(mustRead(t, "@fut"))
+		done <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	s.Interrupt()
+	it.EvalString("(>! ch 1)")
+	if err := <-done; err != nil {
+		t.Errorf("another session was interrupted: %v", err)
+	}
+}
+
+func TestSessionOutput(t *testing.T) {
+	var out, own bytes.Buffer
+	it := New(Options{Stdout: &out})
+	s := it.NewSession()
+	s.SetOutput(&own)
+	s.Eval(mustRead(t, "(prn :session)"))
+	it.EvalString("(prn :interpreter)")
+	if own.String() != ":session\n" || out.String() != ":interpreter\n" {
+		t.Errorf("session %q, interpreter %q", own.String(), out.String())
+	}
+}
+
+func mustRead(t *testing.T, code string) Top {
+	form, err := reader.Read_str(code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return form
+}
@@ -0,0 +1,466 @@
+package interp
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+import (
+	"github.com/ntaoo/lispgo/core"
+	. "github.com/ntaoo/lispgo/env"
+	"github.com/ntaoo/lispgo/printer"
+	. "github.com/ntaoo/lispgo/types"
+)
+
+// Namespaces
+//
+// Every global lives in a namespace. The builtins and the prelude are
+// defined in lispgo.core, which every other namespace falls back to, so
+// a namespace can shadow them without changing what the prelude sees.
+// An interpreter starts in user; (ns name clauses ...) or in-ns switches
+// to another namespace, creating it, for the forms read after it. A file
+// run with load-file or require leaves the current namespace as it was.
+//
+// A symbol such as util/helper, whose part before the slash is the name
+// or an alias of a namespace, is qualified: it names helper in that
+// namespace, which must export it. A namespace exports all its globals
+// unless it lists them with export, or :export in its ns form.
+//
+// require makes a module available to the current namespace, loading it
+// first unless it has been loaded already: the module my.util is the
+// file my/util.lisp in the first directory of the load path that has it,
+// run in the namespace my.util. A namespace of that name made by in-ns
+// does not count as the module being loaded. A require spec is
+// the module name, or a vector of it followed by :as alias, making
+// alias/name qualify names in it, and :refer [names ...] or :refer :all,
+// making its exported globals usable unqualified.
+//
+// Both backends resolve a reference to a global once, to the Var holding
+// its value, and resolve it again only when the names of the interpreter
+// have changed since: when a global is defined for the first time, or a
+// namespace is made or gains an alias, refers or exports.
+
+// CoreNamespace is the namespace of the builtins and the prelude.
+const CoreNamespace = core.Namespace
+
+// Namespace is the root environment of the forms in one namespace.
+type Namespace struct {
+	name    string
+	globals EnvType
+	core    *Namespace
+	reg     *registry
+
+	mu       sync.RWMutex
+	aliases  map[string]*Namespace
+	refers   map[string]*Namespace
+	referAll []*Namespace
+	// exports is nil if every global is exported.
+	exports map[string]bool
+}
+
+// registry holds the namespaces of an interpreter by name.
+type registry struct {
+	// version changes whenever what a name resolves to may have.
+	version    int64
+	mu         sync.Mutex
+	namespaces map[string]*Namespace
+	// loading holds the modules being loaded by require, and loaded
+	// those it has loaded.
+	loading  map[string]bool
+	loaded   map[string]bool
+	loadPath []string
+}
+
+func newRegistry(loadPath []string) *registry {
+	return &registry{namespaces: map[string]*Namespace{}, loading: map[string]bool{}, loaded: map[string]bool{}, loadPath: loadPath}
+}
+
+func (r *registry) get(name string) *Namespace {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.namespaces[name]
+}
+
+// intern returns the namespace name, creating it if needed.
+func (r *registry) intern(name string) *Namespace {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if ns, ok := r.namespaces[name]; ok {
+		return ns
+	}
+	globals, _ := NewEnv(nil, nil, nil)
+	ns := &Namespace{name: name, globals: globals, reg: r, core: r.namespaces[CoreNamespace]}
+	r.namespaces[name] = ns
+	r.changed()
+	return ns
+}
+
+// changed invalidates the references resolved so far.
+func (r *registry) changed() {
+	atomic.AddInt64(&r.version, 1)
+}
+
+// Name returns the name of the namespace.
+func (ns *Namespace) Name() string {
+	return ns.name
+}
+
+// qualified splits a qualified symbol into the namespace it names and
+// the name within it. It fails for any other symbol.
+func (ns *Namespace) qualified(key Symbol) (*Namespace, Symbol, bool) {
+	i := strings.Index(key.Val, "/")
+	if i <= 0 || i == len(key.Val)-1 {
+		return nil, key, false
+	}
+	target := ns.resolve(key.Val[:i])
+	if target == nil {
+		return nil, key, false
+	}
+	return target, Symbol{key.Val[i+1:]}, true
+}
+
+// resolve returns the namespace that prefix, an alias in ns or the name
+// of a namespace, stands for in a qualified symbol, or nil.
+func (ns *Namespace) resolve(prefix string) *Namespace {
+	ns.mu.RLock()
+	target := ns.aliases[prefix]
+	ns.mu.RUnlock()
+	if target == nil {
+		target = ns.reg.get(prefix)
+	}
+	return target
+}
+
+func (ns *Namespace) exported(name string) bool {
+	ns.mu.RLock()
+	defer ns.mu.RUnlock()
+	return ns.exports == nil || ns.exports[name]
+}
+
+// public finds a global that ns exports.
+func (ns *Namespace) public(key Symbol) EnvType {
+	if !ns.exported(key.Val) {
+		return nil
+	}
+	return ns.globals.Find(key)
+}
+
+// find returns the environment holding the global that key names in
+// ns, and its name there, or nil if there is none.
+func (ns *Namespace) find(key Symbol) (EnvType, Symbol) {
+	if target, name, ok := ns.qualified(key); ok {
+		return target.public(name), name
+	}
+	if env := ns.globals.Find(key); env != nil {
+		return env, key
+	}
+	ns.mu.RLock()
+	target, all := ns.refers[key.Val], ns.referAll
+	ns.mu.RUnlock()
+	if target != nil {
+		if env := target.public(key); env != nil {
+			return env, key
+		}
+	}
+	for _, target := range all {
+		if env := target.public(key); env != nil {
+			return env, key
+		}
+	}
+	if ns.core != nil {
+		return ns.core.find(key)
+	}
+	return nil, key
+}
+
+// globalRef is a reference to the global named sym in env, usually the
+// namespace of the form but that of its macro for a symbol a
+// syntax-rules template inserted.
+type globalRef struct {
+	env EnvType
+	sym Symbol
+	// resolved caches what sym resolved to in a namespace.
+	resolved atomic.Value
+}
+
+type resolved struct {
+	version int64
+	v       *Var
+}
+
+// refGlobal returns a reference to the global the free identifier x
+// names in env.
+func refGlobal(x Top, env EnvType) *globalRef {
+	env, sym := globalOf(x, env)
+	return &globalRef{env: env, sym: sym}
+}
+
+// get returns the value of the global.
+func (ref *globalRef) get() (Top, error) {
+	ns, ok := ref.env.(*Namespace)
+	if !ok {
+		return ref.env.Get(ref.sym)
+	}
+	version := atomic.LoadInt64(&ns.reg.version)
+	if r, _ := ref.resolved.Load().(resolved); r.v != nil && r.version == version {
+		return r.v.Get(), nil
+	}
+	env, name := ns.find(ref.sym)
+	globals, ok := env.(*Env)
+	if !ok {
+		return ns.Get(ref.sym)
+	}
+	v := globals.Var(name)
+	if v == nil {
+		return ns.Get(ref.sym)
+	}
+	ref.resolved.Store(resolved{version, v})
+	return v.Get(), nil
+}
+
+// Names returns the names usable unqualified in ns, sorted: its own
+// globals, those it refers to and those of lispgo.core.
+func (ns *Namespace) Names() []string {
+	seen := map[string]bool{}
+	ns.names(seen)
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (ns *Namespace) names(seen map[string]bool) {
+	for _, name := range ns.globals.(*Env).Names() {
+		seen[name] = true
+	}
+	ns.mu.RLock()
+	targets := append([]*Namespace(nil), ns.referAll...)
+	for name, target := range ns.refers {
+		if target.public(Symbol{name}) != nil {
+			seen[name] = true
+		}
+	}
+	ns.mu.RUnlock()
+	for _, target := range targets {
+		for _, name := range target.publicNames() {
+			seen[name] = true
+		}
+	}
+	if ns.core != nil {
+		ns.core.names(seen)
+	}
+}
+
+// Defined returns the names of the globals defined in ns itself,
+// sorted.
+func (ns *Namespace) Defined() []string {
+	names := ns.globals.(*Env).Names()
+	sort.Strings(names)
+	return names
+}
+
+// publicNames returns the names of the globals ns exports.
+func (ns *Namespace) publicNames() []string {
+	var names []string
+	for _, name := range ns.globals.(*Env).Names() {
+		if ns.exported(name) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// prefixes returns what may come before the slash of a symbol qualified
+// in ns: its aliases and the names of the namespaces.
+func (ns *Namespace) prefixes() []string {
+	var res []string
+	ns.mu.RLock()
+	for alias := range ns.aliases {
+		res = append(res, alias)
+	}
+	ns.mu.RUnlock()
+	r := ns.reg
+	r.mu.Lock()
+	for name := range r.namespaces {
+		res = append(res, name)
+	}
+	r.mu.Unlock()
+	return res
+}
+
+func (ns *Namespace) Find(key Symbol) EnvType {
+	env, _ := ns.find(key)
+	return env
+}
+
+func (ns *Namespace) Get(key Symbol) (Top, error) {
+	env, name := ns.find(key)
+	if env == nil {
+		return nil, NewError(UnboundError, "", key, "'"+key.Val+"' not found")
+	}
+	return env.Get(name)
+}
+
+// Set defines a global in ns itself.
+func (ns *Namespace) Set(key Symbol, value Top) Top {
+	defined := ns.globals.(*Env).Var(key) != nil
+	ns.globals.Set(key, value)
+	if !defined {
+		ns.reg.changed()
+	}
+	return value
+}
+
+// export adds names to those ns exports.
+func (ns *Namespace) export(names []Top) error {
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+	defer ns.reg.changed()
+	if ns.exports == nil {
+		ns.exports = map[string]bool{}
+	}
+	for _, x := range names {
+		sym, ok := x.(Symbol)
+		if !ok {
+			return WrongType("export", "symbol", x)
+		}
+		ns.exports[sym.Val] = true
+	}
+	return nil
+}
+
+// current returns the namespace forms are evaluated in.
+func (it *Interpreter) current() *Namespace {
+	it.mu.Lock()
+	defer it.mu.Unlock()
+	return it.ns
+}
+
+// switchTo makes ns the current namespace and returns the one before.
+func (it *Interpreter) switchTo(ns *Namespace) *Namespace {
+	it.mu.Lock()
+	defer it.mu.Unlock()
+	prev := it.ns
+	it.ns = ns
+	return prev
+}
+
+// require makes the module a spec names available to ns, loading it from
+// c.
+func (it *Interpreter) require(ns *Namespace, spec Top, c caller) error {
+	var parts []Top
+	if vec, ok := spec.(Vector); ok && vec.Len() > 0 {
+		parts = vec.Slice()
+	} else {
+		parts = []Top{spec}
+	}
+	name, ok := parts[0].(Symbol)
+	if !ok {
+		return NewError(SyntaxError, "require", spec, "expected a module name or [name options ...], got "+printer.PrintString(spec, true))
+	}
+	target, e := it.load(name.Val, c)
+	if e != nil {
+		return e
+	}
+	opts := parts[1:]
+	if len(opts)%2 != 0 {
+		return NewError(SyntaxError, "require", spec, "options must be keyword/value pairs")
+	}
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+	defer ns.reg.changed()
+	for i := 0; i < len(opts); i += 2 {
+		switch {
+		case opts[i] == InternKeyword("as") && IsSymbol(opts[i+1]):
+			if ns.aliases == nil {
+				ns.aliases = map[string]*Namespace{}
+			}
+			ns.aliases[opts[i+1].(Symbol).Val] = target
+		case opts[i] == InternKeyword("refer") && opts[i+1] == InternKeyword("all"):
+			ns.referAll = append(ns.referAll, target)
+		case opts[i] == InternKeyword("refer"):
+			names, e := GetSlice(opts[i+1])
+			if e != nil {
+				return NewError(SyntaxError, "require", spec, ":refer expects a list of symbols or :all")
+			}
+			if ns.refers == nil {
+				ns.refers = map[string]*Namespace{}
+			}
+			for _, x := range names {
+				sym, ok := x.(Symbol)
+				if !ok {
+					return NewError(SyntaxError, "require", spec, ":refer expects a list of symbols or :all")
+				}
+				if !target.exported(sym.Val) {
+					return NewError(ValueError, "require", spec, "'"+sym.Val+"' is not exported by "+target.name)
+				}
+				ns.refers[sym.Val] = target
+			}
+		default:
+			return NewError(SyntaxError, "require", spec, "unknown option "+printer.PrintString(opts[i], true))
+		}
+	}
+	return nil
+}
+
+// load returns the namespace of a module, loading it from c if it has
+// not been loaded yet.
+func (it *Interpreter) load(name string, c caller) (*Namespace, error) {
+	r := it.namespaces
+	r.mu.Lock()
+	if r.loading[name] {
+		r.mu.Unlock()
+		return nil, NewError(ValueError, "require", nil, "cyclic require of "+name)
+	}
+	if r.loaded[name] {
+		ns := r.namespaces[name]
+		r.mu.Unlock()
+		return ns, nil
+	}
+	_, existed := r.namespaces[name]
+	r.loading[name] = true
+	r.mu.Unlock()
+	defer func() {
+		r.mu.Lock()
+		delete(r.loading, name)
+		r.mu.Unlock()
+	}()
+
+	path, e := r.resolve(name)
+	if e != nil {
+		return nil, e
+	}
+	ns := r.intern(name)
+	prev := it.switchTo(ns)
+	defer it.switchTo(prev)
+	_, e = it.loadFile(path, c)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if e != nil {
+		if !existed {
+			delete(r.namespaces, name)
+			r.changed()
+		}
+		return nil, e
+	}
+	r.loaded[name] = true
+	return ns, nil
+}
+
+// resolve finds the file of a module in the load path.
+func (r *registry) resolve(name string) (string, error) {
+	rel := filepath.Join(strings.Split(name, ".")...) + ".lisp"
+	for _, dir := range r.loadPath {
+		path := filepath.Join(dir, rel)
+		if _, e := os.Stat(path); e == nil {
+			return path, nil
+		}
+	}
+	return "", NewError(IOError, "require", nil, "module "+name+" not found: no "+rel+" in the load path")
+}
@@ -0,0 +1,287 @@
+;; The part of the standard library written in lispgo itself. It is
+;; embedded in package interp and loaded into every new interpreter a
+;; form at a time, so each form can use the macros defined before it.
+
+(define *host-language* "go")
+
+(define not (lambda (a) (if a false true)))
+
+;;; Control
+
+(define-syntax cond
+  (syntax-rules ()
+    ((_) nil)
+    ((_ test) (throw "odd number of forms to cond"))
+    ((_ test expr more ...) (if test expr (cond more ...)))))
+
+(define-syntax or
+  (syntax-rules ()
+    ((_) nil)
+    ((_ x) x)
+    ((_ x more ...) (let* (t x) (if t t (or more ...))))))
+
+(define-syntax and
+  (syntax-rules ()
+    ((_) true)
+    ((_ x) x)
+    ((_ x more ...) (let* (t x) (if t (and more ...) t)))))
+
+(define-syntax when
+  (syntax-rules ()
+    ((_ test body ...) (if test (do body ...) nil))))
+
+(define-syntax unless
+  (syntax-rules ()
+    ((_ test body ...) (if test nil (do body ...)))))
+
+;; (-> x (f a) g) is (g (f x a)): x is threaded through the forms as
+;; their first argument. ->> threads it as the last.
+(define-syntax ->
+  (syntax-rules ()
+    ((_ x) x)
+    ((_ x (f args ...) more ...) (-> (f x args ...) more ...))
+    ((_ x f more ...) (-> (f x) more ...))))
+
+(define-syntax ->>
+  (syntax-rules ()
+    ((_ x) x)
+    ((_ x (f args ...) more ...) (->> (f args ... x) more ...))
+    ((_ x f more ...) (->> (f x) more ...))))
+
+;; (defn name [params] body ...) defines a function, with an optional
+;; docstring after the name that is kept as :doc in its metadata. The
+;; defn form itself is kept as :source, for source to show.
+(define-syntax defn
+  (syntax-rules ()
+    ((_ name [params ...] body ...)
+     (define name (with-meta (lambda [params ...] (do body ...))
+                    {:source '(defn name [params ...] body ...)})))
+    ((_ name doc [params ...] body ...)
+     (define name (with-meta (lambda [params ...] (do body ...))
+                    {:doc doc :source '(defn name doc [params ...] body ...)})))))
+
+(define-syntax go
+  (syntax-rules ()
+    ((_ body ...) (spawn (lambda [] (do body ...))))))
+
+;;; Lazy sequences
+
+;; (lazy-seq body ...) is a sequence whose body is evaluated the first
+;; time it is looked at, to the sequence it stands for. (delay body ...)
+;; is a value evaluated the first time it is forced or dereferenced.
+(define-syntax lazy-seq
+  (syntax-rules ()
+    ((_ body ...) (lazy-seq* (lambda [] (do body ...))))))
+
+(define-syntax delay
+  (syntax-rules ()
+    ((_ body ...) (delay* (lambda [] (do body ...))))))
+
+;; (iterate f x) is the infinite sequence x, (f x), (f (f x)) ...
+(define iterate
+  (lambda (f x)
+    (lazy-seq (cons x (iterate f (f x))))))
+
+;; (repeat x) is x forever, and (repeat n x) is x n times.
+(define repeat
+  (lambda (& args)
+    (if (= (count args) 1)
+      (lazy-seq (cons (first args) (repeat (first args))))
+      (take (first args) (repeat (nth args 1))))))
+
+;; doall realizes a lazy sequence in full and returns it.
+(define doall
+  (lambda (xs)
+    (do (count xs) xs)))
+
+;;; Sequences
+
+;; (fold-left f init [a b]) is (f (f init a) b).
+(define fold-left
+  (lambda (f acc xs)
+    (let* (xs (seq xs))
+      (if (empty? xs)
+        acc
+        (fold-left f (f acc (first xs)) (rest xs))))))
+
+;; (fold-right f init [a b]) is (f a (f b init)).
+(define fold-right
+  (lambda (f acc xs)
+    (let* (v (apply vector xs)
+           loop (lambda (i acc)
+                  (if (< i 0)
+                    acc
+                    (loop (- i 1) (f (nth v i) acc)))))
+      (loop (- (count v) 1) acc))))
+
+;; (reduce f coll) folds the rest of coll into its first element, or
+;; returns (f) if it is empty. (reduce f init coll) is fold-left.
+(define reduce
+  (lambda (f & args)
+    (if (= (count args) 1)
+      (let* (xs (seq (first args)))
+        (if (empty? xs)
+          (f)
+          (fold-left f (first xs) (rest xs))))
+      (apply fold-left f args))))
+
+;; filter is lazy when xs is, like map.
+(define filter
+  (lambda (pred xs)
+    (if (lazy-seq? xs)
+      (lazy-seq
+        (let* (s (seq xs))
+          (cond
+            (nil? s) nil
+            (pred (first s)) (cons (first s) (filter pred (rest s)))
+            :else (filter pred (rest s)))))
+      (apply list (fold-left (lambda (acc x) (if (pred x) (conj acc x) acc)) [] xs)))))
+
+;; (range end), (range start end) or (range start end step) is the lazy
+;; sequence of the numbers from start, by default 0, up to but not
+;; including end. (range) counts up from 0 forever.
+(define range
+  (lambda (& args)
+    (let* (n (count args)
+           start (if (> n 1) (first args) 0)
+           end (if (> n 1) (nth args 1) (first args))
+           step (if (> n 2) (nth args 2) 1)
+           more? (if (> step 0) < >)
+           from (lambda (i)
+                  (lazy-seq
+                    (if (or (= n 0) (more? i end))
+                      (cons i (from (+ i step)))))))
+      (cond
+        (> n 3) (throw "range: expected 0 to 3 arguments")
+        (= step 0) (throw "range: step must not be 0")
+        :else (from start)))))
+
+(define take
+  (lambda (n xs)
+    (let* (loop (lambda (n xs acc)
+                  (if (or (<= n 0) (empty? xs))
+                    (apply list acc)
+                    (loop (- n 1) (rest xs) (conj acc (first xs))))))
+      (loop n (seq xs) []))))
+
+(define drop
+  (lambda (n xs)
+    (let* (xs (seq xs))
+      (cond
+        (nil? xs) ()
+        (<= n 0) xs
+        :else (drop (- n 1) (rest xs))))))
+
+;; (partition n coll) splits coll into lists of n elements, dropping any
+;; left over. (partition n step coll) starts each list step elements
+;; after the one before.
+(define partition
+  (lambda (n & args)
+    (let* (step (if (> (count args) 1) (first args) n)
+           loop (lambda (xs acc)
+                  (let* (chunk (take n xs))
+                    (if (< (count chunk) n)
+                      (apply list acc)
+                      (loop (drop step xs) (conj acc chunk))))))
+      (if (or (<= n 0) (<= step 0))
+        (throw "partition: size and step must be positive")
+        (loop (seq (nth args (- (count args) 1))) [])))))
+
+;;; Nested collections
+
+;; (get-in m [k1 k2]) is (get (get m k1) k2).
+(define get-in
+  (lambda (m ks)
+    (fold-left get m ks)))
+
+;; assoc-in associates v with the path of keys ks in nested hash-maps,
+;; creating those that are missing.
+(define assoc-in
+  (lambda (m ks v)
+    (let* (m (if (nil? m) {} m)
+           k (first ks)
+           ks (rest ks))
+      (if (empty? ks)
+        (assoc m k v)
+        (assoc m k (assoc-in (get m k) ks v))))))
+
+;; (update-in m ks f args ...) replaces the value at the path ks with
+;; (f value args ...).
+(define update-in
+  (lambda (m ks f & args)
+    (assoc-in m ks (apply f (get-in m ks) args))))
+
+;;; let
+
+;; destructure returns the let* bindings that bind pattern to the value
+;; of form. A vector pattern [a b & more] binds the elements of a
+;; sequence in turn and the rest of it after &. A hash-map pattern binds
+;; each pattern in it to the value of its key, and the symbols after
+;; :keys to the values of the keywords with their names. Anything else
+;; is bound as it is.
+(define destructure
+  (lambda (pattern form)
+    (cond
+      (vector? pattern)
+      (let* (v (gensym))
+        (concat (list v form) (destructure-seq (seq pattern) v 0)))
+      (map? pattern)
+      (let* (m (gensym))
+        (concat (list m form) (destructure-map pattern m)))
+      :else (list pattern form))))
+
+(define destructure-seq
+  (lambda (patterns v i)
+    (cond
+      (empty? patterns) ()
+      (= '& (first patterns)) (destructure (nth patterns 1) (list 'drop i v))
+      :else (concat (destructure (first patterns) (list 'first (list 'drop i v)))
+                    (destructure-seq (rest patterns) v (+ i 1))))))
+
+(define destructure-map
+  (lambda (pattern m)
+    (apply concat
+           (map (lambda (k)
+                  (if (= k :keys)
+                    (apply concat (map (lambda (s) (list s (list 'get m (keyword (str s)))))
+                                       (get pattern k)))
+                    (destructure k (list 'get m (get pattern k)))))
+                (keys pattern)))))
+
+;; (let [pattern value ...] body ...) binds patterns as destructure
+;; does. (let name [param value ...] body ...), a named let, also binds
+;; name to a function of the params with the body, so the body can loop
+;; by calling it.
+(defmacro! let
+  (lambda (bindings & body)
+    (if (sequential? bindings)
+      (if (= 1 (modulo (count bindings) 2))
+        (throw "let: bindings must be pattern/value pairs")
+        `(let* ~(apply concat (map (lambda (b) (destructure (first b) (nth b 1)))
+                                   (partition 2 bindings)))
+           (do ~@body)))
+      (let* (pairs (partition 2 (first body)))
+        `((let* (~bindings (lambda ~(apply vector (map first pairs)) (do ~@(rest body))))
+            ~bindings)
+          ~@(map (lambda (p) (nth p 1)) pairs))))))
+
+;;; Namespaces
+
+;; (ns name clauses ...) switches to the namespace name for the forms
+;; after it. A clause (:require spec ...) requires modules and (:export
+;; name ...) lists the names the namespace exports.
+(define ns-clause
+  (lambda (clause)
+    (let* (kind (if (list? clause) (first clause)))
+      (cond
+        (= kind :require) `(require ~@(map (lambda (spec) (list 'quote spec)) (rest clause)))
+        (= kind :export) `(export ~@(map (lambda (name) (list 'quote name)) (rest clause)))
+        :else (throw (str "ns: unknown clause " (pr-str clause)))))))
+
+(defmacro! ns
+  (lambda (name & clauses)
+    `(do (in-ns '~name) ~@(map ns-clause clauses))))
+
+(defmacro! module
+  (lambda (name & clauses)
+    `(ns ~name ~@clauses)))
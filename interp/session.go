@@ -0,0 +1,259 @@
+package interp
+
+import (
+	"io"
+	"sync"
+)
+
+import (
+	"github.com/ntaoo/lispgo/printer"
+	"github.com/ntaoo/lispgo/reader"
+	. "github.com/ntaoo/lispgo/types"
+)
+
+// Sessions
+//
+// A Session is someone evaluating forms one after another, at the REPL
+// or from an editor. It binds *1, *2 and *3 to the values of the last
+// three forms it evaluated and *e to the last error, and keeps its own
+// current namespace. The sessions of an interpreter take turns: while
+// one evaluates a form, it has the interpreter and its *1 ... *e to
+// itself. What it prints may go to a writer of its own rather than to
+// the stdout of the interpreter, and it may be interrupted.
+
+// historyNames are the globals a session binds, most recent first.
+var historyNames = []Symbol{{Val: "*1"}, {Val: "*2"}, {Val: "*3"}}
+
+var lastError = Symbol{Val: "*e"}
+
+// printLength limits how many elements of a collection Rep prints, when
+// it is bound to a number; defaultPrintLength is what it is bound to at
+// first, so that an infinite lazy sequence can be printed.
+var printLength = Symbol{Val: "*print-length*"}
+
+const defaultPrintLength = 100
+
+// Session evaluates forms, remembering their results.
+type Session struct {
+	it *Interpreter
+	// mu guards ns, out and task, which may be used while the session
+	// evaluates. task is the evaluation in progress, if any.
+	mu      sync.Mutex
+	ns      *Namespace
+	out     io.Writer
+	task    *task
+	results [3]Top
+	err     Top
+}
+
+// NewSession returns a session that starts in the current namespace of
+// the interpreter.
+func (it *Interpreter) NewSession() *Session {
+	return &Session{it: it, ns: it.current()}
+}
+
+// Interpreter returns the interpreter of the session.
+func (s *Session) Interpreter() *Interpreter {
+	return s.it
+}
+
+// Namespace returns the current namespace of the session.
+func (s *Session) Namespace() *Namespace {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ns
+}
+
+// InNamespace switches the session to the namespace name, creating it if
+// it does not exist, as in-ns does.
+func (s *Session) InNamespace(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ns = s.it.namespaces.intern(name)
+}
+
+// SetOutput sends what the forms the session evaluates print to w rather
+// than to the stdout of the interpreter. Nil restores that.
+func (s *Session) SetOutput(w io.Writer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.out = w
+}
+
+// Interrupt interrupts the form the session is evaluating: none of its
+// calls can be made any more, and the builtins waiting for it, as <! and
+// deref do, give up, so that it soon fails with an :interrupted error.
+// Other sessions and the futures it spawned go on. It reports whether
+// the session was evaluating a form.
+func (s *Session) Interrupt() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.task != nil {
+		s.task.interrupt()
+	}
+	return s.task != nil
+}
+
+// Eval evaluates form in the session. A value becomes *1, moving the
+// ones before to *2 and *3; an error becomes *e.
+func (s *Session) Eval(form Top) (Top, error) {
+	return s.run(func(c caller) (Top, error) {
+		return s.it.evalForm(form, c)
+	})
+}
+
+// Load evaluates every form of src, the contents of file, in turn as
+// LoadFile does, and the namespace of the session is restored
+// afterwards. The value of the last form becomes *1.
+func (s *Session) Load(src string, file string) (Top, error) {
+	return s.run(func(c caller) (Top, error) {
+		return s.load(src, file, c)
+	})
+}
+
+func (s *Session) load(src string, file string, c caller) (Top, error) {
+	defer s.it.switchTo(s.it.current())
+	return s.it.evalSource(src, file, c)
+}
+
+// run calls f from a new task of the session when it is its turn, with
+// *1 ... *e bound to those of the session, and records its result.
+func (s *Session) run(f func(c caller) (Top, error)) (Top, error) {
+	it := s.it
+	it.turn.Lock()
+	defer it.turn.Unlock()
+	s.mu.Lock()
+	it.switchTo(s.ns)
+	out := s.out
+	t := newTask()
+	s.task = t
+	s.mu.Unlock()
+	if out != nil {
+		defer it.stdout.set(it.stdout.set(out))
+	}
+	core := it.namespaces.get(CoreNamespace)
+	for i, name := range historyNames {
+		core.Set(name, s.results[i])
+	}
+	core.Set(lastError, s.err)
+	res, e := f(caller{task: t})
+	s.mu.Lock()
+	s.ns = it.current()
+	s.task = nil
+	s.mu.Unlock()
+	if e != nil {
+		s.fail(e)
+		core.Set(lastError, s.err)
+		return nil, e
+	}
+	copy(s.results[1:], s.results[:2])
+	s.results[0] = res
+	return res, nil
+}
+
+// fail records e as the last error.
+func (s *Session) fail(e error) {
+	if lge, ok := e.(LGError); ok {
+		s.err = lge.Obj
+	} else {
+		s.err = e.Error()
+	}
+}
+
+// Rep evaluates form in the session and prints the result readably.
+func (s *Session) Rep(form Top) (string, error) {
+	return s.rep(func(c caller) (Top, error) {
+		return s.it.evalForm(form, c)
+	})
+}
+
+// RepLoad evaluates src as Load does and prints the value of the last
+// form as Rep does.
+func (s *Session) RepLoad(src string, file string) (string, error) {
+	return s.rep(func(c caller) (Top, error) {
+		return s.load(src, file, c)
+	})
+}
+
+// rep runs f as run does, and prints its result before the turn of the
+// session ends, so that realizing a lazy result can be interrupted too.
+func (s *Session) rep(f func(c caller) (Top, error)) (string, error) {
+	var out string
+	_, e := s.run(func(c caller) (Top, error) {
+		res, e := f(c)
+		if e == nil {
+			out, e = s.it.display(res)
+		}
+		return res, e
+	})
+	return out, e
+}
+
+// RepAll reads every form of str, and evaluates and prints each in turn
+// as Rep does, passing the results to print as they come. It stops at
+// the first error.
+func (s *Session) RepAll(str string, print func(string)) error {
+	forms, e := reader.Read_all(str, "")
+	if e != nil {
+		s.it.turn.Lock()
+		s.fail(e)
+		s.it.turn.Unlock()
+		return e
+	}
+	for _, form := range forms {
+		out, e := s.Rep(form)
+		if e != nil {
+			return e
+		}
+		print(out)
+	}
+	return nil
+}
+
+// Complete returns the names that prefix may be completed to in the
+// namespace of the session, as Interpreter.Complete does.
+func (s *Session) Complete(prefix string) []string {
+	return complete(s.Namespace(), prefix)
+}
+
+// output is the stdout of an interpreter, which a session may redirect
+// while it evaluates.
+type output struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (o *output) Write(p []byte) (int, error) {
+	o.mu.Lock()
+	w := o.w
+	o.mu.Unlock()
+	return w.Write(p)
+}
+
+// set makes output go to w, and returns where it went before.
+func (o *output) set(w io.Writer) io.Writer {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	prev := o.w
+	o.w = w
+	return prev
+}
+
+// display prints the value res readably, with no more elements of any
+// collection than *print-length* in the current namespace.
+func (it *Interpreter) display(res Top) (string, error) {
+	length := -1
+	if n, _ := it.current().Get(printLength); n != nil {
+		if n, ok := n.(int); ok && n >= 0 {
+			length = n
+		}
+	}
+	if IsLazy(res) {
+		// Realize it here, so that an error doing so is raised rather
+		// than printed.
+		if _, _, e := Take(res, length); e != nil {
+			return "", e
+		}
+	}
+	return printer.PrintLength(res, true, length), nil
+}
@@ -0,0 +1,581 @@
+package interp
+
+import (
+	"github.com/ntaoo/lispgo/printer"
+	. "github.com/ntaoo/lispgo/types"
+)
+
+// Hygienic macros
+//
+// define-syntax, let-syntax and letrec-syntax bind macros written with
+// syntax-rules, which rewrite a call by matching it against each pattern
+// in turn and filling in the template of the first that matches.
+//
+// They are hygienic by renaming. Every symbol that a template inserts,
+// as opposed to one substituted for a pattern variable, becomes a
+// renamed symbol unique to that expansion. If a binding form of the
+// same expansion binds it, it refers to that binding alone, so the
+// temporaries of a macro cannot capture the symbols of the form it was
+// called with. If not, it refers to what the symbol meant where the
+// macro was defined, so a macro keeps working where a name it relies on
+// is bound locally or, for a global, in another namespace. A renamed symbol prints as the symbol it was renamed
+// from and quote turns it back into that symbol. The one exception is a
+// define that an expansion makes at the top level: it defines the global
+// named by the symbol.
+//
+// define-syntax defines a global macro at the top level and a local one
+// for the rest of the body anywhere else, like define. let-syntax and
+// letrec-syntax take symbol/macro pairs like let*, and their one body
+// form is in tail position. The templates of letrec-syntax may use each
+// other's macros.
+
+// renamed is a symbol inserted by an expansion. id is the symbol in the
+// template, which was itself renamed if an expansion defined the macro.
+type renamed struct {
+	id   Top
+	mark *mark
+}
+
+// mark identifies one expansion of a macro.
+type mark struct {
+	rules *syntaxRules
+}
+
+func (r renamed) String() string {
+	sym, _ := symbolOf(r)
+	return sym.Val
+}
+
+// symbolOf returns the symbol that the identifier x was renamed from, or
+// x itself if it is a symbol, or the symbol of its name if it is a
+// gensym. It fails if x is not an identifier.
+func symbolOf(x Top) (Symbol, bool) {
+	for {
+		switch id := x.(type) {
+		case Symbol:
+			return id, true
+		case Gensym:
+			return Symbol{id.Val}, true
+		case renamed:
+			x = id.id
+		default:
+			return Symbol{}, false
+		}
+	}
+}
+
+// globalOf returns the environment in which the free identifier x,
+// used in env, names a global, and the name of the global there. A
+// renamed symbol names one in the environment of its macro.
+func globalOf(x Top, env EnvType) (EnvType, Symbol) {
+	for {
+		switch id := x.(type) {
+		case renamed:
+			if id.mark.rules.env != nil {
+				env = id.mark.rules.env
+			}
+			x = id.id
+		default:
+			sym, _ := symbolOf(x)
+			return env, sym
+		}
+	}
+}
+
+// isIdent reports whether x is a symbol, renamed or not, or a gensym.
+func isIdent(x Top) bool {
+	_, ok := symbolOf(x)
+	return ok
+}
+
+// isNamed reports whether x is the symbol name, renamed or not.
+func isNamed(x Top, name string) bool {
+	sym, ok := symbolOf(x)
+	return ok && sym.Val == name
+}
+
+// stripSyntax returns x with every renamed symbol in it replaced by the
+// symbol it was renamed from, sharing whatever holds none.
+func stripSyntax(x Top) Top {
+	switch t := x.(type) {
+	case renamed:
+		sym, _ := symbolOf(t)
+		return sym
+	case List:
+		if vals, ok := stripAll(t.Val); ok {
+			return List{vals, t.Meta}
+		}
+	case Vector:
+		if vals, ok := stripAll(t.Slice()); ok {
+			return NewVector(vals...)
+		}
+	case HashMap:
+		changed := false
+		res := HashMap{}
+		t.Each(func(k Top, v Top) bool {
+			sk, sv := stripSyntax(k), stripSyntax(v)
+			changed = changed || !Eq(sk, k) || !Eq(sv, v)
+			res = res.Assoc(sk, sv)
+			return true
+		})
+		if changed {
+			return res
+		}
+	}
+	return x
+}
+
+// stripAll strips the elements of xs, returning a copy if any changed.
+func stripAll(xs []Top) ([]Top, bool) {
+	var res []Top
+	for i, x := range xs {
+		s := stripSyntax(x)
+		if res == nil && !Eq(s, x) {
+			res = append(make([]Top, 0, len(xs)), xs[:i]...)
+		}
+		if res != nil {
+			res = append(res, s)
+		}
+	}
+	return res, res != nil
+}
+
+// syntaxRules is a macro written with syntax-rules. The symbols its
+// templates insert refer to the bindings visible where it was defined:
+// the first n bindings of scope, or only globals if scope is nil, and
+// the globals of env.
+type syntaxRules struct {
+	name     string
+	form     Top
+	ellipsis Top
+	literals []Top
+	rules    []syntaxRule
+	scope    *fnScope
+	n        int
+	env      EnvType
+	Meta     Top
+}
+
+type syntaxRule struct {
+	pattern  List
+	template Top
+}
+
+// newSyntaxRules makes the macro named name from ast, a syntax-rules
+// form, defined in env where the first n bindings of scope are visible.
+func newSyntaxRules(name string, ast Top, scope *fnScope, n int, env EnvType) (*syntaxRules, error) {
+	lst, ok := ast.(List)
+	if !ok || len(lst.Val) == 0 || !isNamed(lst.Val[0], "syntax-rules") {
+		return nil, NewError(SyntaxError, name, ast, "expected a syntax-rules form, got "+TypeName(ast))
+	}
+	sr := &syntaxRules{name: name, form: ast, ellipsis: Symbol{"..."}, scope: scope, n: n, env: env}
+	args := lst.Val[1:]
+	if len(args) > 0 && isIdent(args[0]) {
+		sr.ellipsis = args[0]
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		return nil, NewError(SyntaxError, "syntax-rules", ast, "expected a list of literals")
+	}
+	lits, e := GetSlice(args[0])
+	if e != nil {
+		return nil, NewError(SyntaxError, "syntax-rules", ast, "literals must be a list of symbols")
+	}
+	for _, lit := range lits {
+		if !isIdent(lit) {
+			return nil, NewError(SyntaxError, "syntax-rules", ast, "literal must be a symbol, got "+TypeName(lit))
+		}
+	}
+	sr.literals = lits
+	for _, x := range args[1:] {
+		rule, ok := x.(List)
+		if !ok || len(rule.Val) != 2 {
+			return nil, NewError(SyntaxError, "syntax-rules", ast, "expected (pattern template), got "+printer.PrintString(x, true))
+		}
+		pat, ok := rule.Val[0].(List)
+		if !ok || len(pat.Val) == 0 {
+			return nil, NewError(SyntaxError, "syntax-rules", ast, "pattern must be a non-empty list, got "+printer.PrintString(rule.Val[0], true))
+		}
+		if e := sr.checkPattern(pat.Val[1:]); e != nil {
+			return nil, e
+		}
+		sr.rules = append(sr.rules, syntaxRule{pat, rule.Val[1]})
+	}
+	return sr, nil
+}
+
+// checkPattern checks that each sequence in a pattern has at most one
+// ellipsis, following a subpattern.
+func (sr *syntaxRules) checkPattern(ps []Top) error {
+	seen := false
+	for i, p := range ps {
+		if sr.isEllipsis(p) {
+			if seen || i == 0 {
+				return NewError(SyntaxError, "syntax-rules", sr.form, "misplaced ellipsis in pattern")
+			}
+			seen = true
+			continue
+		}
+		var e error
+		switch t := p.(type) {
+		case List:
+			e = sr.checkPattern(t.Val)
+		case Vector:
+			e = sr.checkPattern(t.Slice())
+		}
+		if e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+func (sr *syntaxRules) isEllipsis(x Top) bool {
+	return sr.ellipsis != nil && x == sr.ellipsis
+}
+
+func (sr *syntaxRules) isLiteral(x Top) bool {
+	for _, lit := range sr.literals {
+		if x == lit {
+			return true
+		}
+	}
+	return false
+}
+
+// expand rewrites form, a call of the macro made where scope is visible.
+func (sr *syntaxRules) expand(form List, scope *fnScope) (Top, error) {
+	for _, r := range sr.rules {
+		b := map[Top]Top{}
+		if sr.matchSeq(r.pattern.Val[1:], form.Val[1:], b, scope) {
+			res, e := sr.fill(r.template, b, &mark{sr})
+			if e != nil {
+				return nil, WithForm(e, form)
+			}
+			return res, nil
+		}
+	}
+	return nil, NewError(SyntaxError, sr.name, form, "no syntax-rules pattern matches "+printer.PrintString(stripSyntax(form), true))
+}
+
+// ellipsisMatch holds what a pattern variable followed by an ellipsis
+// matched, one element for each repetition.
+type ellipsisMatch []Top
+
+// match matches x against the pattern p, adding what its pattern
+// variables matched to b. A literal matches the same symbol if it is not
+// bound locally where the macro is called.
+func (sr *syntaxRules) match(p Top, x Top, b map[Top]Top, scope *fnScope) bool {
+	switch t := p.(type) {
+	case Symbol, Gensym, renamed:
+		if sr.isLiteral(p) {
+			psym, _ := symbolOf(p)
+			xsym, ok := symbolOf(x)
+			if !ok || psym != xsym {
+				return false
+			}
+			bound, _ := scope.lookup(x)
+			return bound == nil
+		}
+		if !isNamed(p, "_") {
+			b[p] = x
+		}
+		return true
+	case List:
+		xl, ok := x.(List)
+		return ok && sr.matchSeq(t.Val, xl.Val, b, scope)
+	case Vector:
+		xv, ok := x.(Vector)
+		return ok && sr.matchSeq(t.Slice(), xv.Slice(), b, scope)
+	default:
+		return Eq(p, x)
+	}
+}
+
+// matchSeq matches the elements of a list or vector against those of a
+// sequence pattern, in which one subpattern may be followed by an
+// ellipsis and so match any number of them.
+func (sr *syntaxRules) matchSeq(ps []Top, xs []Top, b map[Top]Top, scope *fnScope) bool {
+	at := -1
+	for i := 1; i < len(ps); i += 1 {
+		if sr.isEllipsis(ps[i]) {
+			at = i - 1
+		}
+	}
+	if at < 0 {
+		if len(ps) != len(xs) {
+			return false
+		}
+		for i, p := range ps {
+			if !sr.match(p, xs[i], b, scope) {
+				return false
+			}
+		}
+		return true
+	}
+	tail := len(ps) - at - 2
+	if len(xs) < at+tail {
+		return false
+	}
+	for i := 0; i < at; i += 1 {
+		if !sr.match(ps[i], xs[i], b, scope) {
+			return false
+		}
+	}
+	vars := sr.patternVars(ps[at], nil)
+	seqs := make([]ellipsisMatch, len(vars))
+	for _, x := range xs[at : len(xs)-tail] {
+		bx := map[Top]Top{}
+		if !sr.match(ps[at], x, bx, scope) {
+			return false
+		}
+		for i, v := range vars {
+			seqs[i] = append(seqs[i], bx[v])
+		}
+	}
+	for i, v := range vars {
+		b[v] = seqs[i]
+	}
+	for i := 0; i < tail; i += 1 {
+		if !sr.match(ps[at+2+i], xs[len(xs)-tail+i], b, scope) {
+			return false
+		}
+	}
+	return true
+}
+
+// patternVars appends the pattern variables of p to vars.
+func (sr *syntaxRules) patternVars(p Top, vars []Top) []Top {
+	switch t := p.(type) {
+	case Symbol, Gensym, renamed:
+		if !sr.isLiteral(p) && !sr.isEllipsis(p) && !isNamed(p, "_") {
+			vars = append(vars, p)
+		}
+	case List:
+		for _, x := range t.Val {
+			vars = sr.patternVars(x, vars)
+		}
+	case Vector:
+		for _, x := range t.Slice() {
+			vars = sr.patternVars(x, vars)
+		}
+	}
+	return vars
+}
+
+// fill fills in the template t with what the pattern variables matched,
+// renaming the symbols it inserts with m. (... template) stands for
+// template with its ellipses taken literally.
+func (sr *syntaxRules) fill(t Top, b map[Top]Top, m *mark) (Top, error) {
+	switch x := t.(type) {
+	case Symbol, Gensym, renamed:
+		v, ok := b[t]
+		if !ok {
+			return renamed{t, m}, nil
+		}
+		if _, ok := v.(ellipsisMatch); ok {
+			sym, _ := symbolOf(t)
+			return nil, NewError(SyntaxError, sr.name, nil, "pattern variable "+sym.Val+" must be followed by an ellipsis in the template")
+		}
+		return v, nil
+	case List:
+		if len(x.Val) == 2 && sr.isEllipsis(x.Val[0]) {
+			literal := *sr
+			literal.ellipsis = nil
+			return literal.fill(x.Val[1], b, m)
+		}
+		vals, e := sr.fillSeq(x.Val, b, m)
+		if e != nil {
+			return nil, e
+		}
+		return List{vals, nil}, nil
+	case Vector:
+		vals, e := sr.fillSeq(x.Slice(), b, m)
+		if e != nil {
+			return nil, e
+		}
+		return NewVector(vals...), nil
+	case HashMap:
+		var err error
+		res := HashMap{}
+		x.Each(func(k Top, v Top) bool {
+			fk, e := sr.fill(k, b, m)
+			if e != nil {
+				err = e
+				return false
+			}
+			fv, e := sr.fill(v, b, m)
+			if e != nil {
+				err = e
+				return false
+			}
+			res = res.Assoc(fk, fv)
+			return true
+		})
+		return res, err
+	default:
+		return t, nil
+	}
+}
+
+// fillSeq fills in the elements of a sequence template. An element
+// followed by n ellipses is repeated for each match of the pattern
+// variables in it that were followed by as many in the pattern.
+func (sr *syntaxRules) fillSeq(ts []Top, b map[Top]Top, m *mark) ([]Top, error) {
+	res := make([]Top, 0, len(ts))
+	for i := 0; i < len(ts); i += 1 {
+		t := ts[i]
+		depth := 0
+		for i+1 < len(ts) && sr.isEllipsis(ts[i+1]) {
+			depth += 1
+			i += 1
+		}
+		if depth == 0 {
+			x, e := sr.fill(t, b, m)
+			if e != nil {
+				return nil, e
+			}
+			res = append(res, x)
+			continue
+		}
+		xs, e := sr.fillRepeated(t, depth, b, m)
+		if e != nil {
+			return nil, e
+		}
+		res = append(res, xs...)
+	}
+	return res, nil
+}
+
+func (sr *syntaxRules) fillRepeated(t Top, depth int, b map[Top]Top, m *mark) ([]Top, error) {
+	var vars []Top
+	n := -1
+	for _, v := range sr.patternVars(t, nil) {
+		seq, ok := b[v].(ellipsisMatch)
+		if !ok {
+			continue
+		}
+		if n >= 0 && len(seq) != n {
+			return nil, NewError(SyntaxError, sr.name, nil, "pattern variables under the same ellipsis matched different numbers of forms")
+		}
+		vars = append(vars, v)
+		n = len(seq)
+	}
+	if vars == nil {
+		return nil, NewError(SyntaxError, sr.name, nil, "ellipsis in template follows no pattern variable that had one")
+	}
+	var res []Top
+	for i := 0; i < n; i += 1 {
+		bi := make(map[Top]Top, len(b))
+		for k, v := range b {
+			bi[k] = v
+		}
+		for _, v := range vars {
+			bi[v] = b[v].(ellipsisMatch)[i]
+		}
+		if depth > 1 {
+			xs, e := sr.fillRepeated(t, depth-1, bi, m)
+			if e != nil {
+				return nil, e
+			}
+			res = append(res, xs...)
+			continue
+		}
+		x, e := sr.fill(t, bi, m)
+		if e != nil {
+			return nil, e
+		}
+		res = append(res, x)
+	}
+	return res, nil
+}
+
+// Call expands a call of the macro with arguments a, made at the top
+// level, as calling a macro defined with defmacro! does.
+func (sr *syntaxRules) Call(a []Top) (Top, error) {
+	return sr.expand(List{append([]Top{Symbol{sr.name}}, a...), nil}, nil)
+}
+
+func (sr *syntaxRules) GetMacro() bool {
+	return true
+}
+
+func (sr *syntaxRules) GetMeta() Top {
+	return sr.Meta
+}
+
+func (sr *syntaxRules) WithMeta(m Top) Top {
+	res := *sr
+	res.Meta = m
+	return &res
+}
+
+func (sr *syntaxRules) String() string {
+	return printer.PrintString(sr.form, true)
+}
+
+// defineSyntax checks (define-syntax name rules) and returns the macro
+// it defines in env. If scope is not nil, the macro is local and is
+// declared in it.
+func defineSyntax(ast Top, scope *fnScope, env EnvType) (*syntaxRules, error) {
+	if e := checkForm(ast, 3, 3); e != nil {
+		return nil, e
+	}
+	lst := ast.(List).Val
+	sym, ok := symbolOf(lst[1])
+	if !ok {
+		return nil, NewError(SyntaxError, "define-syntax", ast, "expected a symbol to define, got "+TypeName(lst[1]))
+	}
+	if scope == nil {
+		return newSyntaxRules(sym.Val, lst[2], nil, 0, env)
+	}
+	scope.declareSyntax(lst[1], nil)
+	mac, e := newSyntaxRules(sym.Val, lst[2], scope, len(scope.names), env)
+	if e != nil {
+		return nil, e
+	}
+	scope.names[len(scope.names)-1].macro = mac
+	return mac, nil
+}
+
+// bindSyntax declares the macros bound by ast, a let-syntax or
+// letrec-syntax form in env, in scope.
+func bindSyntax(ast Top, scope *fnScope, env EnvType) error {
+	if e := checkForm(ast, 3, 3); e != nil {
+		return e
+	}
+	lst := ast.(List).Val
+	name, _ := symbolOf(lst[0])
+	binds, e := GetSlice(lst[1])
+	if e != nil || len(binds)%2 != 0 {
+		return NewError(SyntaxError, name.Val, ast, "bindings must be a list of symbol/syntax-rules pairs")
+	}
+	for i := 0; i < len(binds); i += 2 {
+		if !isIdent(binds[i]) {
+			return NewError(SyntaxError, name.Val, ast, "non-symbol bind value")
+		}
+	}
+	n := len(scope.names)
+	if name.Val == "letrec-syntax" {
+		for i := 0; i < len(binds); i += 2 {
+			scope.declareSyntax(binds[i], nil)
+		}
+	}
+	macs := make([]*syntaxRules, 0, len(binds)/2)
+	for i := 0; i < len(binds); i += 2 {
+		sym, _ := symbolOf(binds[i])
+		mac, e := newSyntaxRules(sym.Val, binds[i+1], scope, len(scope.names), env)
+		if e != nil {
+			return e
+		}
+		macs = append(macs, mac)
+	}
+	for i, mac := range macs {
+		if name.Val == "letrec-syntax" {
+			scope.names[n+i].macro = mac
+		} else {
+			scope.declareSyntax(binds[2*i], mac)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package interp
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+import (
+	. "github.com/ntaoo/lispgo/types"
+)
+
+// Tasks
+//
+// A task is an evaluation that can be interrupted on its own, such as a
+// form a session evaluates; see Session.Interrupt. Both backends pass it
+// on from a call to the calls it makes, which fail with an :interrupted
+// error once it is interrupted, and Builtins called from it, such as <!
+// and deref, stop waiting then. Forms evaluated by EvalForm, EvalString
+// or LoadFile and the functions of futures belong to no task, so that
+// interrupting one leaves them be.
+
+// task is an evaluation that can be interrupted. A nil task cannot be.
+type task struct {
+	// stop is set, and done closed, when the task is interrupted.
+	stop int32
+	done chan struct{}
+	once sync.Once
+}
+
+func newTask() *task {
+	return &task{done: make(chan struct{})}
+}
+
+// interrupt makes the calls of t fail and wakes the builtins it waits in.
+func (t *task) interrupt() {
+	atomic.StoreInt32(&t.stop, 1)
+	t.once.Do(func() { close(t.done) })
+}
+
+// interrupted returns the error ending a call from form in t if t is
+// interrupted.
+func (t *task) interrupted(form Top) error {
+	if t != nil && atomic.LoadInt32(&t.stop) != 0 {
+		return NewError(InterruptError, "", form, "interrupted")
+	}
+	return nil
+}
+
+// stopped returns the channel closed when t is interrupted, for
+// Caller.Stop; that of no task is never closed.
+func (t *task) stopped() <-chan struct{} {
+	if t == nil {
+		return nil
+	}
+	return t.done
+}
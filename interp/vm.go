@@ -0,0 +1,299 @@
+package interp
+
+import (
+	. "github.com/ntaoo/lispgo/types"
+)
+
+// Stack machine
+//
+// A machine runs protos with an operand stack and a stack of call
+// frames, so calls between closures do not grow the Go stack. Builtins
+// and other functions are called directly; when they call back into a
+// closure, as map does, that closure runs on a machine of its own.
+
+type frame struct {
+	p   *proto
+	act *activation
+	// cl is the closure being run, nil at the top level, and callForm
+	// the form that called it, for backtraces.
+	cl       *Closure
+	callForm Top
+	// applied is set for the first frame of a closure called by Call.
+	// Like a function applied by the tree walker, a tail call from it
+	// still gets a frame of its own.
+	applied bool
+	pc      int
+	// ip is the instruction being run when the frame calls another.
+	ip   int
+	base int
+}
+
+type handler struct {
+	frame int
+	sp    int
+	pc    int
+}
+
+type machine struct {
+	stack    []Top
+	frames   []frame
+	handlers []handler
+	// base is the depth of the first frame, and task the task the frames
+	// belong to; see closure.go.
+	base int
+	task *task
+}
+
+// evalVM compiles and runs a form in env, the root environment, from c,
+// allowing calls to nest max deep. The forms of a top-level do are run
+// one by one, so that a macro defined by one can be used by the next.
+func evalVM(ast Top, env EnvType, max int, c caller) (Top, error) {
+	expanded, e := macroExpand(ast, nil, env, c)
+	if e != nil {
+		return nil, Locate(e, ast)
+	}
+	if lst, ok := expanded.(List); ok && len(lst.Val) > 1 && isNamed(lst.Val[0], "do") {
+		var res Top
+		for _, x := range lst.Val[1:] {
+			if res, e = evalVM(x, env, max, c); e != nil {
+				return nil, e
+			}
+		}
+		return res, nil
+	}
+	depth := c.depth + 1
+	if depth > max {
+		return nil, stackOverflow(ast, max)
+	}
+	p := compileTop(expanded, env, max, c)
+	m := &machine{base: depth, task: c.task}
+	m.frames = append(m.frames, frame{p: p, act: &activation{slots: make([]Top, p.nslots)}})
+	return m.run()
+}
+
+func (m *machine) push(x Top) {
+	m.stack = append(m.stack, x)
+}
+
+func (m *machine) pop() Top {
+	x := m.stack[len(m.stack)-1]
+	m.stack = m.stack[:len(m.stack)-1]
+	return x
+}
+
+func (m *machine) run() (Top, error) {
+	f := &m.frames[len(m.frames)-1]
+	code := f.p.code
+	pc := f.pc
+	for {
+		ip := pc
+		op := code[pc]
+		pc += 1
+		var err error
+		switch op {
+		case opConst:
+			m.push(f.p.consts[code[pc]])
+			pc += 1
+		case opLocal:
+			m.push(f.act.slots[code[pc]])
+			pc += 1
+		case opOuter:
+			act := f.act
+			for d := code[pc]; d > 0; d -= 1 {
+				act = act.outer
+			}
+			m.push(act.slots[code[pc+1]])
+			pc += 2
+		case opSetLocal:
+			f.act.slots[code[pc]] = m.pop()
+			pc += 1
+		case opGlobal:
+			var x Top
+			if x, err = f.p.consts[code[pc]].(*globalRef).get(); err == nil {
+				m.push(x)
+			}
+			pc += 1
+		case opDefGlobal:
+			f.p.globals.Set(f.p.consts[code[pc]].(Symbol), m.stack[len(m.stack)-1])
+			pc += 1
+		case opName:
+			m.stack[len(m.stack)-1] = named(m.stack[len(m.stack)-1], f.p.consts[code[pc]].(Symbol).Val)
+			pc += 1
+		case opMacro:
+			x := m.stack[len(m.stack)-1]
+			if mac, ok := asMacro(x, f.p.consts[code[pc]].(Symbol).Val); ok {
+				m.stack[len(m.stack)-1] = mac
+			} else {
+				err = NewError(TypeError, "defmacro!", f.p.forms[ip], "expected a lambda, got "+TypeName(x))
+			}
+			pc += 1
+		case opPop:
+			m.stack = m.stack[:len(m.stack)-1]
+		case opDup:
+			m.push(m.stack[len(m.stack)-1])
+		case opJump:
+			pc = int(code[pc])
+		case opJumpIfFalse:
+			if cond := m.pop(); cond == nil || cond == false {
+				pc = int(code[pc])
+			} else {
+				pc += 1
+			}
+		case opCall, opTailCall:
+			n := int(code[pc])
+			pc += 1
+			sp := len(m.stack) - n - 1
+			form := f.p.forms[ip]
+			fn, args := m.stack[sp], m.stack[sp+1:]
+			cl, ok := fn.(*Closure)
+			// Call anything else, and then the function of a
+			// TailCall it returns in its place.
+			for !ok || cl.proto.run != nil {
+				var res Top
+				if res, err = callOther(fn, append([]Top(nil), args...), form, caller{m.base + len(m.frames) - 1, m.task}); err != nil {
+					break
+				}
+				tc, more := res.(TailCall)
+				if !more {
+					m.stack = m.stack[:sp]
+					m.push(res)
+					break
+				}
+				fn, args = tc.Fn, tc.Args
+				cl, ok = fn.(*Closure)
+			}
+			if err != nil || !ok || cl.proto.run != nil {
+				break
+			}
+			var act *activation
+			if act, err = cl.bind(args); err != nil {
+				err = WithForm(err, form)
+				break
+			}
+			if err = m.task.interrupted(form); err != nil {
+				break
+			}
+			if op == opTailCall && !f.applied {
+				callForm := f.callForm
+				if f.cl == nil {
+					callForm = form
+				}
+				m.stack = m.stack[:f.base]
+				*f = frame{p: cl.proto, act: act, cl: cl, callForm: callForm, base: f.base}
+			} else {
+				if max := cl.proto.max; m.base+len(m.frames) > max {
+					err = stackOverflow(form, max)
+					break
+				}
+				m.stack = m.stack[:sp]
+				f.pc, f.ip = pc, ip
+				m.frames = append(m.frames, frame{p: cl.proto, act: act, cl: cl, callForm: form, base: sp})
+				f = &m.frames[len(m.frames)-1]
+			}
+			code, pc = f.p.code, 0
+		case opReturn:
+			res := m.pop()
+			m.stack = m.stack[:f.base]
+			m.frames = m.frames[:len(m.frames)-1]
+			if len(m.frames) == 0 {
+				return res, nil
+			}
+			m.push(res)
+			f = &m.frames[len(m.frames)-1]
+			code, pc = f.p.code, f.pc
+		case opClosure:
+			m.push(&Closure{proto: f.p.consts[code[pc]].(*proto), outer: f.act})
+			pc += 1
+		case opVector:
+			n := int(code[pc])
+			sp := len(m.stack) - n
+			vec := NewVector(m.stack[sp:]...)
+			m.stack = m.stack[:sp]
+			m.push(vec)
+			pc += 1
+		case opHashMap:
+			n := int(code[pc])
+			sp := len(m.stack) - 2*n
+			hm := HashMap{}
+			for i := sp; i < len(m.stack); i += 2 {
+				hm = hm.Assoc(m.stack[i], m.stack[i+1])
+			}
+			m.stack = m.stack[:sp]
+			m.push(hm)
+			pc += 1
+		case opTry:
+			m.handlers = append(m.handlers, handler{len(m.frames) - 1, len(m.stack), int(code[pc])})
+			pc += 1
+		case opEndTry:
+			m.handlers = m.handlers[:len(m.handlers)-1]
+		case opRaise:
+			err = f.p.consts[code[pc]].(error)
+			pc += 1
+		}
+
+		if err != nil {
+			f.ip = ip
+			if err = m.unwind(err); err != nil {
+				return nil, err
+			}
+			f = &m.frames[len(m.frames)-1]
+			code, pc = f.p.code, f.pc
+		}
+	}
+}
+
+// callOther calls anything but a closure run by the caller's backend
+// from c. A TailCall returned by a builtin is returned to the caller to
+// make.
+func callOther(fn Top, a []Top, form Top, c caller) (Top, error) {
+	var res Top
+	var e error
+	switch f := fn.(type) {
+	case Func:
+		res, e = f.Fn(a)
+	case func([]Top) (Top, error):
+		res, e = f(a)
+	case Builtin:
+		res, e = f.Fn(c, a)
+	case *Closure:
+		res, e = f.call(a, c)
+	case Keyword, Callable:
+		res, e = Apply(fn, a)
+	default:
+		e = NewError(TypeError, "", form, "cannot call "+TypeName(fn))
+	}
+	if e != nil {
+		return nil, WithForm(e, form)
+	}
+	return res, nil
+}
+
+// unwind passes an error to the innermost handler, returning nil once
+// the machine is ready to run it, or records the frames it unwinds
+// through and returns the error if there is none.
+func (m *machine) unwind(e error) error {
+	for len(m.frames) > 0 {
+		f := &m.frames[len(m.frames)-1]
+		e = Locate(e, f.p.at[f.ip])
+		if n := len(m.handlers); n > 0 && m.handlers[n-1].frame == len(m.frames)-1 {
+			h := m.handlers[n-1]
+			m.handlers = m.handlers[:n-1]
+			if _, ok := e.(Escape); ok {
+				continue
+			}
+			m.stack = m.stack[:h.sp]
+			if lge, ok := e.(LGError); ok {
+				m.push(lge.Obj)
+			} else {
+				m.push(e.Error())
+			}
+			f.pc = h.pc
+			return nil
+		}
+		if f.cl != nil {
+			e = PushFrame(e, f.cl.Name, f.callForm)
+		}
+		m.frames = m.frames[:len(m.frames)-1]
+	}
+	return e
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"time"
+)
+
+import (
+	"github.com/ntaoo/lispgo/interp"
+	"github.com/ntaoo/lispgo/printer"
+	"github.com/ntaoo/lispgo/reader"
+	. "github.com/ntaoo/lispgo/types"
+)
+
+// repl evaluates what is typed at the REPL: forms, or a command such as
+// :load file. A line starting with a keyword that is not a command is
+// evaluated as usual.
+type repl struct {
+	s   *interp.Session
+	out io.Writer
+	// loaded is the file :load loaded last, for :reload.
+	loaded string
+}
+
+type command struct {
+	args string
+	help string
+	run  func(r *repl, arg string) error
+}
+
+// commands are the REPL commands by name. They are set in init, as
+// :help refers to them.
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		":load":   {"file", "load a file", (*repl).load},
+		":reload": {"", "load the file :load loaded last again", (*repl).reload},
+		":env":    {"", "list the globals of the current namespace", (*repl).env},
+		":time":   {"expr", "evaluate expr and show how long it took", (*repl).timed},
+		":expand": {"expr", "expand the macros in expr", (*repl).expand},
+		":type":   {"expr", "show the type of the value of expr", (*repl).typeOf},
+		":quit":   {"", "leave the REPL", nil},
+		":help":   {"", "list these commands", (*repl).help},
+	}
+}
+
+// eval evaluates text, printing the results and any error, and reports
+// whether it was :quit.
+func (r *repl) eval(text string) (quit bool) {
+	if name, arg, ok := r.command(text); ok {
+		if name == ":quit" {
+			return true
+		}
+		if e := commands[name].run(r, arg); e != nil {
+			r.s.Interpreter().PrintError(e)
+		}
+		return false
+	}
+	e := r.s.RepAll(text, func(out string) {
+		fmt.Fprintln(r.out, out)
+	})
+	if e != nil {
+		r.s.Interpreter().PrintError(e)
+	}
+	return false
+}
+
+// command splits text into a command and its argument, if it starts
+// with one.
+func (r *repl) command(text string) (string, string, bool) {
+	text = strings.TrimSpace(text)
+	name, arg := text, ""
+	if i := strings.IndexAny(text, " \t\n"); i >= 0 {
+		name, arg = text[:i], strings.TrimSpace(text[i:])
+	}
+	_, ok := commands[name]
+	return name, arg, ok
+}
+
+// readArg reads the one form of a command's argument.
+func readArg(name string, arg string) (Top, error) {
+	forms, e := reader.Read_all(arg, "")
+	if e != nil {
+		return nil, e
+	}
+	if len(forms) != 1 {
+		return nil, NewErrorf(SyntaxError, "", "%s expects one form, got %d", name, len(forms))
+	}
+	return forms[0], nil
+}
+
+// rep evaluates form and prints the result.
+func (r *repl) rep(form Top) error {
+	out, e := r.s.Rep(form)
+	if e != nil {
+		return e
+	}
+	fmt.Fprintln(r.out, out)
+	return nil
+}
+
+func (r *repl) load(arg string) error {
+	path := strings.Trim(arg, `"`)
+	if path == "" {
+		return NewError(ValueError, "", nil, ":load expects a file")
+	}
+	r.loaded = path
+	src, e := ioutil.ReadFile(path)
+	if e != nil {
+		return NewError(IOError, "load-file", nil, e.Error())
+	}
+	out, e := r.s.RepLoad(string(src), path)
+	if e != nil {
+		return e
+	}
+	fmt.Fprintln(r.out, out)
+	return nil
+}
+
+func (r *repl) reload(arg string) error {
+	if r.loaded == "" {
+		return NewError(ValueError, "", nil, ":reload: no file loaded yet")
+	}
+	return r.load(r.loaded)
+}
+
+func (r *repl) env(arg string) error {
+	ns := r.s.Namespace()
+	names := ns.Defined()
+	if len(names) == 0 {
+		fmt.Fprintf(r.out, "%s has no globals\n", ns.Name())
+	}
+	for _, name := range names {
+		val, e := ns.Get(Symbol{name})
+		if e != nil {
+			continue
+		}
+		s := []rune(printer.PrintLength(val, true, 60))
+		if len(s) > 60 {
+			s = append(s[:57], []rune("...")...)
+		}
+		fmt.Fprintf(r.out, "%s = %s\n", name, string(s))
+	}
+	return nil
+}
+
+func (r *repl) timed(arg string) error {
+	form, e := readArg(":time", arg)
+	if e != nil {
+		return e
+	}
+	start := time.Now()
+	out, e := r.s.Rep(form)
+	elapsed := time.Since(start)
+	if e != nil {
+		return e
+	}
+	fmt.Fprintf(r.out, "Elapsed time: %v\n%s\n", elapsed, out)
+	return nil
+}
+
+func (r *repl) expand(arg string) error {
+	form, e := readArg(":expand", arg)
+	if e != nil {
+		return e
+	}
+	return r.rep(NewList(Symbol{"macroexpand-all"}, form))
+}
+
+func (r *repl) typeOf(arg string) error {
+	form, e := readArg(":type", arg)
+	if e != nil {
+		return e
+	}
+	val, e := r.s.Eval(form)
+	if e != nil {
+		return e
+	}
+	fmt.Fprintln(r.out, TypeName(val))
+	return nil
+}
+
+func (r *repl) help(arg string) error {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		c := commands[name]
+		fmt.Fprintf(r.out, "%-15s %s\n", strings.TrimSpace(name+" "+c.args), c.help)
+	}
+	return nil
+}
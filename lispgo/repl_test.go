@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+import (
+	"github.com/ntaoo/lispgo/interp"
+)
+
+func TestCommands(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lispgo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "loaded.lisp")
+	if err := ioutil.WriteFile(file, []byte("(define loads (+ 1 (if (nil? loads) 0 loads)))"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var out, errOut bytes.Buffer
+	it := interp.New(interp.Options{Stdout: &out, Stderr: &errOut})
+	r := &repl{s: it.NewSession(), out: &out}
+	cases := []struct {
+		text, expected string
+	}{
+		{"(define loads nil) (+ 1 2)", "nil\n3\n"},
+		{":type *1", "integer\n"},
+		{":type \"a\"", "string\n"},
+		{":expand (when a b)", "(if a (do b) nil)\n"},
+		{":load " + file, "1\n"},
+		{":reload", "2\n"},
+		{":env", "loads = 2\n"},
+		{":time (+ 1 2)", "Elapsed time: "},
+		{"(list *1 *2)", "(3 2)\n"},
+		{"(define load-file (lambda (f) :shadowed))", ""},
+		{":reload", "3\n"},
+		{":kw", ":kw\n"},
+		{"  :quit", ""},
+	}
+	for _, c := range cases {
+		out.Reset()
+		quit := r.eval(c.text)
+		if !strings.HasPrefix(out.String(), c.expected) {
+			t.Errorf("%s: expected %q, actual %q", c.text, c.expected, out.String())
+		}
+		if quit != (c.text == "  :quit") {
+			t.Errorf("%s: quit %v", c.text, quit)
+		}
+	}
+	if errOut.Len() != 0 {
+		t.Errorf("unexpected errors: %s", errOut.String())
+	}
+	for _, text := range []string{":time 1 2", ":load", ":load " + file + ".missing", ":type (nth [] 0)"} {
+		errOut.Reset()
+		r.eval(text)
+		if !strings.HasPrefix(errOut.String(), "Error: ") {
+			t.Errorf("%s: expected an error, got %q", text, errOut.String())
+		}
+	}
+}
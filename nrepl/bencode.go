@@ -0,0 +1,197 @@
+package nrepl
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+)
+
+// Bencode
+//
+// nREPL messages are bencoded: an integer is i42e, a string its length
+// in bytes and the bytes, 5:hello, a list l...e and a dictionary d...e
+// with string keys in sorted order, each followed by its value. Decoded,
+// they are int64, string, []interface{} and map[string]interface{}.
+
+// message is a bencoded dictionary, the unit of the protocol.
+type message map[string]interface{}
+
+// str returns the string at key, or "".
+func (m message) str(key string) string {
+	s, _ := m[key].(string)
+	return s
+}
+
+type decoder struct {
+	r *bufio.Reader
+}
+
+func newDecoder(r io.Reader) *decoder {
+	return &decoder{bufio.NewReader(r)}
+}
+
+var errBencode = errors.New("nrepl: malformed bencode")
+
+// Limits on what decode reads, so that a message from the network cannot
+// make it use any amount of memory or stack: maxString is the longest
+// string, maxDigits the longest number or string length and maxDepth how
+// deeply lists and dictionaries may nest.
+const (
+	maxString = 64 << 20
+	maxDigits = 20
+	maxDepth  = 64
+)
+
+// decode reads one value.
+func (d *decoder) decode() (interface{}, error) {
+	return d.value(0)
+}
+
+// value reads a value nested in depth lists and dictionaries.
+func (d *decoder) value(depth int) (interface{}, error) {
+	c, e := d.r.ReadByte()
+	if e != nil {
+		return nil, e
+	}
+	switch {
+	case c == 'i':
+		s, e := d.digits("", 'e')
+		if e != nil {
+			return nil, e
+		}
+		n, e := strconv.ParseInt(s, 10, 64)
+		if e != nil {
+			return nil, errBencode
+		}
+		return n, nil
+	case c >= '0' && c <= '9':
+		s, e := d.digits(string(c), ':')
+		if e != nil {
+			return nil, e
+		}
+		n, e := strconv.Atoi(s)
+		if e != nil || n < 0 || n > maxString {
+			return nil, errBencode
+		}
+		buf := make([]byte, n)
+		if _, e := io.ReadFull(d.r, buf); e != nil {
+			return nil, e
+		}
+		return string(buf), nil
+	case (c == 'l' || c == 'd') && depth == maxDepth:
+		return nil, errBencode
+	case c == 'l':
+		list := []interface{}{}
+		for {
+			if end, e := d.end(); e != nil || end {
+				return list, e
+			}
+			x, e := d.value(depth + 1)
+			if e != nil {
+				return nil, e
+			}
+			list = append(list, x)
+		}
+	case c == 'd':
+		dict := map[string]interface{}{}
+		for {
+			if end, e := d.end(); e != nil || end {
+				return dict, e
+			}
+			k, e := d.value(depth + 1)
+			if e != nil {
+				return nil, e
+			}
+			key, ok := k.(string)
+			if !ok {
+				return nil, errBencode
+			}
+			if dict[key], e = d.value(depth + 1); e != nil {
+				return nil, e
+			}
+		}
+	}
+	return nil, errBencode
+}
+
+// digits reads the digits of a number, which come after prefix, and the
+// byte end that follows them. It fails if there are more than maxDigits.
+func (d *decoder) digits(prefix string, end byte) (string, error) {
+	buf := []byte(prefix)
+	for {
+		c, e := d.r.ReadByte()
+		if e != nil {
+			return "", e
+		}
+		if c == end {
+			return string(buf), nil
+		}
+		if len(buf) == maxDigits {
+			return "", errBencode
+		}
+		buf = append(buf, c)
+	}
+}
+
+// end consumes the e ending a list or dictionary, if it comes next.
+func (d *decoder) end() (bool, error) {
+	c, e := d.r.ReadByte()
+	if e != nil {
+		return false, e
+	}
+	if c == 'e' {
+		return true, nil
+	}
+	return false, d.r.UnreadByte()
+}
+
+// encode writes v to w.
+func encode(w io.Writer, v interface{}) error {
+	switch v := v.(type) {
+	case string:
+		_, e := fmt.Fprintf(w, "%d:%s", len(v), v)
+		return e
+	case int:
+		_, e := fmt.Fprintf(w, "i%de", v)
+		return e
+	case int64:
+		_, e := fmt.Fprintf(w, "i%de", v)
+		return e
+	case []string:
+		list := make([]interface{}, len(v))
+		for i, s := range v {
+			list[i] = s
+		}
+		return encode(w, list)
+	case []interface{}:
+		io.WriteString(w, "l")
+		for _, x := range v {
+			if e := encode(w, x); e != nil {
+				return e
+			}
+		}
+		_, e := io.WriteString(w, "e")
+		return e
+	case message:
+		return encode(w, map[string]interface{}(v))
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		io.WriteString(w, "d")
+		for _, k := range keys {
+			encode(w, k)
+			if e := encode(w, v[k]); e != nil {
+				return e
+			}
+		}
+		_, e := io.WriteString(w, "e")
+		return e
+	}
+	return fmt.Errorf("nrepl: cannot bencode %T", v)
+}
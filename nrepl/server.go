@@ -0,0 +1,438 @@
+// Package nrepl is a server of the nREPL protocol, over which editors
+// such as Emacs with CIDER or VS Code with Calva evaluate code in a
+// running lispgo process.
+//
+// A client sends bencoded dictionaries over TCP, each with an "op", an
+// "id" and usually a "session", and the server answers each with one or
+// more messages carrying the same id and session, the last of which has
+// the status "done". The ops are:
+//
+//	clone        start a session, or a copy of "session": "new-session"
+//	close        end "session"
+//	ls-sessions  list the sessions: "sessions"
+//	describe     list the ops and versions: "ops", "versions"
+//	eval         evaluate the forms of "code", in "ns" if given: a
+//	             "value" and "ns" for each, "out" for what they print and
+//	             "err" and "ex" for an error
+//	load-file    evaluate "file", the contents of "file-path": "value"
+//	complete     complete "prefix": "completions"
+//	interrupt    interrupt the eval "interrupt-id" of "session"
+//
+// A session is an interp.Session, with its own *1, *2, *3, *e and
+// current namespace; an eval without one gets a new session of its own.
+// The evals of a session run in turn, and those of all sessions share
+// the interpreter of the server.
+package nrepl
+
+import (
+	"bufio"
+	"crypto/rand"
+	"fmt"
+	"net"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+)
+
+import (
+	"github.com/ntaoo/lispgo/interp"
+	. "github.com/ntaoo/lispgo/types"
+)
+
+// Server serves nREPL clients from one interpreter.
+type Server struct {
+	it *interp.Interpreter
+
+	mu       sync.Mutex
+	sessions map[string]*session
+	ln       net.Listener
+	conns    map[net.Conn]bool
+	closed   bool
+}
+
+// NewServer returns a server evaluating code in it.
+func NewServer(it *interp.Interpreter) *Server {
+	return &Server{it: it, sessions: map[string]*session{}, conns: map[net.Conn]bool{}}
+}
+
+// Serve accepts connections on ln until the server is closed, serving
+// each on a goroutine of its own.
+func (srv *Server) Serve(ln net.Listener) error {
+	srv.mu.Lock()
+	srv.ln = ln
+	srv.mu.Unlock()
+	for {
+		c, e := ln.Accept()
+		if e != nil {
+			srv.mu.Lock()
+			closed := srv.closed
+			srv.mu.Unlock()
+			if closed {
+				return nil
+			}
+			return e
+		}
+		srv.mu.Lock()
+		srv.conns[c] = true
+		srv.mu.Unlock()
+		go srv.serve(c)
+	}
+}
+
+// Close stops the server, closing its connections and sessions.
+func (srv *Server) Close() error {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	srv.closed = true
+	for c := range srv.conns {
+		c.Close()
+	}
+	for id, sess := range srv.sessions {
+		sess.close()
+		delete(srv.sessions, id)
+	}
+	if srv.ln != nil {
+		return srv.ln.Close()
+	}
+	return nil
+}
+
+func (srv *Server) serve(c net.Conn) {
+	defer func() {
+		srv.mu.Lock()
+		delete(srv.conns, c)
+		srv.mu.Unlock()
+		c.Close()
+	}()
+	t := &transport{w: bufio.NewWriter(c)}
+	d := newDecoder(c)
+	for {
+		v, e := d.decode()
+		if e != nil {
+			return
+		}
+		if req, ok := v.(map[string]interface{}); ok {
+			srv.handle(t, message(req))
+		}
+	}
+}
+
+// transport sends the replies on one connection.
+type transport struct {
+	mu sync.Mutex
+	w  *bufio.Writer
+}
+
+// reply sends m as a reply to req.
+func (t *transport) reply(req message, m message) {
+	if id, ok := req["id"]; ok {
+		m["id"] = id
+	}
+	if s, ok := req["session"]; ok {
+		m["session"] = s
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if encode(t.w, m) == nil {
+		t.w.Flush()
+	}
+}
+
+func done(status ...string) message {
+	return message{"status": append(status, "done")}
+}
+
+// Sessions
+
+type session struct {
+	id string
+	s  *interp.Session
+	// queue holds the evals of the session waiting to run in turn on a
+	// goroutine of its own. It has no bound, so that queuing an eval
+	// never blocks the reader of a connection, which must stay free to
+	// read an interrupt. qmu guards it and closed, and ready wakes up
+	// the goroutine.
+	qmu    sync.Mutex
+	ready  *sync.Cond
+	queue  []func()
+	closed bool
+
+	mu sync.Mutex
+	// running is the id of the eval running, if any.
+	running string
+}
+
+func newSession(s *interp.Session) *session {
+	sess := &session{id: newID(), s: s}
+	sess.ready = sync.NewCond(&sess.qmu)
+	go sess.run()
+	return sess
+}
+
+// run runs the evals of sess in turn until it is closed and its queue
+// is empty.
+func (sess *session) run() {
+	for {
+		sess.qmu.Lock()
+		for len(sess.queue) == 0 && !sess.closed {
+			sess.ready.Wait()
+		}
+		if len(sess.queue) == 0 {
+			sess.qmu.Unlock()
+			return
+		}
+		f := sess.queue[0]
+		sess.queue[0] = nil
+		sess.queue = sess.queue[1:]
+		sess.qmu.Unlock()
+		f()
+	}
+}
+
+// enqueue adds an eval to the queue of sess, unless it is closed.
+func (sess *session) enqueue(f func()) bool {
+	sess.qmu.Lock()
+	defer sess.qmu.Unlock()
+	if sess.closed {
+		return false
+	}
+	sess.queue = append(sess.queue, f)
+	sess.ready.Signal()
+	return true
+}
+
+// close ends sess once the evals in its queue have run.
+func (sess *session) close() {
+	sess.qmu.Lock()
+	defer sess.qmu.Unlock()
+	sess.closed = true
+	sess.ready.Signal()
+}
+
+// newID returns a random UUID.
+func newID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// session returns the session of req, or nil and false if it names
+// none. A request without a session gets a new one, which is not kept.
+func (srv *Server) session(req message) (*session, bool) {
+	id := req.str("session")
+	if id == "" {
+		return newSession(srv.it.NewSession()), true
+	}
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	sess, ok := srv.sessions[id]
+	return sess, ok
+}
+
+// Ops
+
+var ops = []string{"clone", "close", "complete", "completions", "describe", "eval", "interrupt", "load-file", "ls-sessions"}
+
+func (srv *Server) handle(t *transport, req message) {
+	op := req.str("op")
+	if op == "describe" {
+		srv.describe(t, req)
+		return
+	}
+	if op == "ls-sessions" {
+		srv.lsSessions(t, req)
+		return
+	}
+	sess, ok := srv.session(req)
+	if !ok {
+		t.reply(req, done("error", "unknown-session"))
+		return
+	}
+	if req.str("session") == "" {
+		// The session made for this request alone ends after it.
+		defer sess.close()
+	}
+	switch op {
+	case "clone":
+		srv.clone(t, req, sess)
+	case "close":
+		srv.closeSession(t, req, sess)
+	case "eval", "load-file":
+		ok := sess.enqueue(func() {
+			sess.mu.Lock()
+			sess.running = req.str("id")
+			sess.mu.Unlock()
+			if op == "eval" {
+				srv.eval(t, req, sess)
+			} else {
+				srv.loadFile(t, req, sess)
+			}
+			sess.mu.Lock()
+			sess.running = ""
+			sess.mu.Unlock()
+			t.reply(req, done())
+		})
+		if !ok {
+			t.reply(req, done("error", "unknown-session"))
+		}
+	case "complete", "completions":
+		srv.complete(t, req, sess)
+	case "interrupt":
+		srv.interrupt(t, req, sess)
+	default:
+		t.reply(req, done("error", "unknown-op"))
+	}
+}
+
+func (srv *Server) describe(t *transport, req message) {
+	m := map[string]interface{}{}
+	for _, op := range ops {
+		m[op] = map[string]interface{}{}
+	}
+	t.reply(req, message{
+		"ops": m,
+		"versions": map[string]interface{}{
+			"nrepl": map[string]interface{}{"major": 1, "minor": 0, "incremental": 0, "version-string": "1.0.0"},
+			"go":    map[string]interface{}{"version-string": runtime.Version()},
+		},
+		"aux":    map[string]interface{}{"current-ns": srv.it.Namespace().Name()},
+		"status": []string{"done"},
+	})
+}
+
+func (srv *Server) lsSessions(t *transport, req message) {
+	srv.mu.Lock()
+	ids := make([]string, 0, len(srv.sessions))
+	for id := range srv.sessions {
+		ids = append(ids, id)
+	}
+	srv.mu.Unlock()
+	sort.Strings(ids)
+	t.reply(req, message{"sessions": ids, "status": []string{"done"}})
+}
+
+// clone starts a session in the namespace of sess, which is that of the
+// interpreter for a request without a session.
+func (srv *Server) clone(t *transport, req message, sess *session) {
+	clone := newSession(srv.it.NewSession())
+	clone.s.InNamespace(sess.s.Namespace().Name())
+	srv.mu.Lock()
+	srv.sessions[clone.id] = clone
+	srv.mu.Unlock()
+	t.reply(req, message{"new-session": clone.id, "status": []string{"done"}})
+}
+
+func (srv *Server) closeSession(t *transport, req message, sess *session) {
+	srv.mu.Lock()
+	delete(srv.sessions, sess.id)
+	srv.mu.Unlock()
+	sess.close()
+	t.reply(req, done("session-closed"))
+}
+
+// output sends what an eval prints to the client.
+type output struct {
+	t   *transport
+	req message
+}
+
+func (o output) Write(p []byte) (int, error) {
+	o.t.reply(o.req, message{"out": string(p)})
+	return len(p), nil
+}
+
+func (srv *Server) eval(t *transport, req message, sess *session) {
+	if ns := req.str("ns"); ns != "" {
+		sess.s.InNamespace(ns)
+	}
+	sess.s.SetOutput(output{t, req})
+	defer sess.s.SetOutput(nil)
+	e := sess.s.RepAll(req.str("code"), func(val string) {
+		t.reply(req, message{"value": val, "ns": sess.s.Namespace().Name()})
+	})
+	if e != nil {
+		fail(t, req, e)
+	}
+}
+
+func (srv *Server) loadFile(t *transport, req message, sess *session) {
+	sess.s.SetOutput(output{t, req})
+	defer sess.s.SetOutput(nil)
+	out, e := sess.s.RepLoad(req.str("file"), req.str("file-path"))
+	if e != nil {
+		fail(t, req, e)
+		return
+	}
+	t.reply(req, message{"value": out, "ns": sess.s.Namespace().Name()})
+}
+
+// fail reports the error of an eval: its message and backtrace as "err",
+// and its kind as "ex".
+func fail(t *transport, req message, e error) {
+	kind := "error"
+	if lge, ok := e.(LGError); ok {
+		if k, ok := ErrorField(lge.Obj, "kind"); ok {
+			kind = fmt.Sprint(k)
+		}
+	}
+	if kind == ":"+InterruptError {
+		t.reply(req, message{"status": []string{"interrupted"}})
+		return
+	}
+	t.reply(req, message{"err": fmt.Sprintf("Error: %v\n%s", e, FormatBacktrace(e))})
+	t.reply(req, message{"ex": kind, "root-ex": kind, "status": []string{"eval-error"}})
+}
+
+func (srv *Server) complete(t *transport, req message, sess *session) {
+	prefix := req.str("prefix")
+	if prefix == "" {
+		prefix = req.str("symbol")
+	}
+	ns := sess.s.Namespace()
+	completions := []interface{}{}
+	for _, c := range sess.s.Complete(prefix) {
+		completions = append(completions, map[string]interface{}{"candidate": c, "type": kindOf(ns, c)})
+	}
+	t.reply(req, message{"completions": completions, "status": []string{"done"}})
+}
+
+// kindOf returns the type of the completion candidate c in ns: a
+// namespace, macro, function or var.
+func kindOf(ns *interp.Namespace, c string) string {
+	if strings.HasSuffix(c, "/") {
+		return "namespace"
+	}
+	val, e := ns.Get(Symbol{c})
+	switch {
+	case e != nil:
+		return "var"
+	case TypeName(val) == "macro":
+		return "macro"
+	case TypeName(val) == "function":
+		if f, ok := val.(Callable); ok && f.GetMacro() {
+			return "macro"
+		}
+		return "function"
+	}
+	return "var"
+}
+
+func (srv *Server) interrupt(t *transport, req message, sess *session) {
+	id := req.str("interrupt-id")
+	sess.mu.Lock()
+	running := sess.running
+	sess.mu.Unlock()
+	switch {
+	case running == "":
+		t.reply(req, done("session-idle"))
+	case id != "" && id != running:
+		t.reply(req, done("interrupt-id-mismatch"))
+	case sess.s.Interrupt():
+		t.reply(req, done())
+	default:
+		t.reply(req, done("session-idle"))
+	}
+}
@@ -0,0 +1,355 @@
+package nrepl
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/ntaoo/lispgo/interp"
+)
+
+func TestBencode(t *testing.T) {
+	v := map[string]interface{}{
+		"op":    "eval",
+		"code":  "(str \"héllo\")",
+		"n":     int64(-42),
+		"list":  []interface{}{"a", int64(1), []interface{}{}},
+		"empty": map[string]interface{}{},
+	}
+	var b bytes.Buffer
+	if err := encode(&b, v); err != nil {
+		t.Fatal(err)
+	}
+	expected := "d4:code14:(str \"héllo\")5:emptyde4:listl1:ai1elee1:ni-42e2:op4:evale"
+	if b.String() != expected {
+		t.Errorf("expected %q, actual %q", expected, b.String())
+	}
+	actual, err := newDecoder(&b).decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(actual, v) {
+		t.Errorf("expected %v, actual %v", v, actual)
+	}
+	for _, bad := range []string{"x", "i1", "3:ab", "d1:a", "di1ei2ee", "-1:"} {
+		if _, err := newDecoder(strings.NewReader(bad)).decode(); err == nil {
+			t.Errorf("%q: expected an error", bad)
+		}
+	}
+	// A huge length is refused before anything is allocated for it, and
+	// so are endless numbers and nesting before they exhaust memory or
+	// the stack.
+	for _, huge := range []string{
+		"99999999999:",
+		"1" + strings.Repeat("0", 1<<20) + ":",
+		"i" + strings.Repeat("9", 1<<20) + "e",
+		strings.Repeat("l", 20<<20),
+		strings.Repeat("d1:a", 1<<20),
+	} {
+		if _, err := newDecoder(strings.NewReader(huge)).decode(); err != errBencode {
+			t.Errorf("%.20q...: expected %v, actual %v", huge, errBencode, err)
+		}
+	}
+	nested := strings.Repeat("l", maxDepth) + strings.Repeat("e", maxDepth)
+	if _, err := newDecoder(strings.NewReader(nested)).decode(); err != nil {
+		t.Errorf("nested %d deep: %v", maxDepth, err)
+	}
+}
+
+// client talks to a server on loopback.
+type client struct {
+	t    *testing.T
+	conn net.Conn
+	d    *decoder
+	n    int
+	// pending holds the replies read for other requests than the one
+	// being waited for.
+	pending map[string][]message
+}
+
+func startServer(t *testing.T) (*Server, *client) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := NewServer(interp.New(interp.Options{}))
+	go srv.Serve(ln)
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	return srv, &client{t: t, conn: conn, d: newDecoder(conn), pending: map[string][]message{}}
+}
+
+// send sends a request and returns its id.
+func (c *client) send(req message) string {
+	c.n += 1
+	id := fmt.Sprint(c.n)
+	req["id"] = id
+	if err := encode(c.conn, req); err != nil {
+		c.t.Fatal(err)
+	}
+	return id
+}
+
+// replies returns the replies to the request id, up to the one whose
+// status is done.
+func (c *client) replies(id string) []message {
+	var res []message
+	for {
+		var m message
+		if len(c.pending[id]) > 0 {
+			m, c.pending[id] = c.pending[id][0], c.pending[id][1:]
+		} else {
+			v, err := c.d.decode()
+			if err != nil {
+				c.t.Fatal(err)
+			}
+			m = message(v.(map[string]interface{}))
+			if m.str("id") != id {
+				c.pending[m.str("id")] = append(c.pending[m.str("id")], m)
+				continue
+			}
+		}
+		res = append(res, m)
+		if hasStatus(m, "done") {
+			return res
+		}
+	}
+}
+
+// call sends a request and returns its replies.
+func (c *client) call(req message) []message {
+	return c.replies(c.send(req))
+}
+
+func hasStatus(m message, status string) bool {
+	list, _ := m["status"].([]interface{})
+	for _, s := range list {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// collect joins the values of key in replies.
+func collect(replies []message, key string) string {
+	var res []string
+	for _, m := range replies {
+		if s, ok := m[key].(string); ok {
+			res = append(res, s)
+		}
+	}
+	return strings.Join(res, "|")
+}
+
+func (c *client) clone() string {
+	id := c.call(message{"op": "clone"})[0].str("new-session")
+	if id == "" {
+		c.t.Fatal("clone: no new session")
+	}
+	return id
+}
+
+func TestEval(t *testing.T) {
+	srv, c := startServer(t)
+	defer srv.Close()
+	s1, s2 := c.clone(), c.clone()
+
+	replies := c.call(message{"op": "eval", "session": s1, "code": "(define x 41) (prn :hi) (+ x 1)"})
+	if actual := collect(replies, "value"); actual != "41|nil|42" {
+		t.Errorf("values: %q", actual)
+	}
+	if actual := collect(replies, "out"); actual != ":hi\n" {
+		t.Errorf("out: %q", actual)
+	}
+	if actual := collect(replies, "ns"); actual != "user|user|user" {
+		t.Errorf("ns: %q", actual)
+	}
+	if replies[0].str("session") != s1 {
+		t.Errorf("the reply is not of session %s: %v", s1, replies[0])
+	}
+
+	replies = c.call(message{"op": "eval", "session": s1, "code": "(nth [] 0)"})
+	if err := collect(replies, "err"); !strings.HasPrefix(err, "Error: nth: ") {
+		t.Errorf("err: %q", err)
+	}
+	if ex := collect(replies, "ex"); ex != ":value-error" {
+		t.Errorf("ex: %q", ex)
+	}
+
+	cases := []struct {
+		session, code, expected string
+	}{
+		{s1, "*1", "42"},
+		{s1, "(get *e :kind)", ":value-error"},
+		{s2, "(list *1 *e x)", "(nil nil 41)"},
+		{s2, "(ns scratch)", "nil"},
+		{s2, "(define y 2)", "2"},
+		{"", "(+ 1 2)", "3"},
+	}
+	for _, tc := range cases {
+		req := message{"op": "eval", "code": tc.code}
+		if tc.session != "" {
+			req["session"] = tc.session
+		}
+		replies := c.call(req)
+		if actual := collect(replies, "value"); actual != tc.expected {
+			t.Errorf("%s: expected %q, actual %q %v", tc.code, tc.expected, actual, replies)
+		}
+	}
+	replies = c.call(message{"op": "eval", "session": s2, "code": "y"})
+	if collect(replies, "value") != "2" || collect(replies, "ns") != "scratch" {
+		t.Errorf("the session did not stay in its namespace: %v", replies)
+	}
+	replies = c.call(message{"op": "eval", "session": s1, "code": "y", "ns": "scratch"})
+	if collect(replies, "value") != "2" {
+		t.Errorf("eval in ns: %v", replies)
+	}
+}
+
+func TestLoadFileAndComplete(t *testing.T) {
+	srv, c := startServer(t)
+	defer srv.Close()
+	s := c.clone()
+	replies := c.call(message{"op": "load-file", "session": s, "file": "(ns shapes)\n(define area (lambda (w h) (* w h)))\n(area 6 7)\n", "file-path": "shapes.lisp"})
+	if actual := collect(replies, "value"); actual != "42" {
+		t.Errorf("load-file: %v", replies)
+	}
+	replies = c.call(message{"op": "load-file", "session": s, "file": "(define oops (lambda () (nth [] 0)))\n(oops)\n", "file-path": "oops.lisp"})
+	if err := collect(replies, "err"); !strings.Contains(err, "oops.lisp:2:1") {
+		t.Errorf("the error does not point into the file: %q", err)
+	}
+	replies = c.call(message{"op": "eval", "session": s, "code": "(shapes/area 2 3)"})
+	if actual := collect(replies, "value"); actual != "6" {
+		t.Errorf("the file was not loaded in its own namespace: %v", replies)
+	}
+
+	replies = c.call(message{"op": "complete", "session": s, "prefix": "shapes/a"})
+	completions := replies[0]["completions"].([]interface{})
+	if len(completions) != 1 || !reflect.DeepEqual(completions[0], map[string]interface{}{"candidate": "shapes/area", "type": "function"}) {
+		t.Errorf("completions: %v", completions)
+	}
+	replies = c.call(message{"op": "complete", "session": s, "prefix": "whe"})
+	completions = replies[0]["completions"].([]interface{})
+	if len(completions) != 1 || completions[0].(map[string]interface{})["type"] != "macro" {
+		t.Errorf("completions: %v", completions)
+	}
+}
+
+func TestInterrupt(t *testing.T) {
+	srv, c := startServer(t)
+	defer srv.Close()
+	s := c.clone()
+	if replies := c.call(message{"op": "interrupt", "session": s}); !hasStatus(replies[0], "session-idle") {
+		t.Errorf("an idle session: %v", replies)
+	}
+	spin := c.send(message{"op": "eval", "session": s, "code": "(define spin (lambda () (spin))) (spin)"})
+	for {
+		replies := c.call(message{"op": "interrupt", "session": s, "interrupt-id": spin})
+		if !hasStatus(replies[0], "session-idle") {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	replies := c.replies(spin)
+	if !hasStatus(replies[len(replies)-2], "interrupted") {
+		t.Errorf("the eval was not interrupted: %v", replies)
+	}
+	if actual := collect(c.call(message{"op": "eval", "session": s, "code": "(+ 1 2)"}), "value"); actual != "3" {
+		t.Errorf("after the interrupt: %q", actual)
+	}
+}
+
+func TestInterruptAfterManyEvals(t *testing.T) {
+	srv, c := startServer(t)
+	defer srv.Close()
+	s := c.clone()
+	var ids []string
+	for i := 0; i < 40; i += 1 {
+		ids = append(ids, c.send(message{"op": "eval", "session": s, "code": "(sleep 100000)"}))
+	}
+	for _, id := range ids {
+		for {
+			replies := c.call(message{"op": "interrupt", "session": s, "interrupt-id": id})
+			if !hasStatus(replies[0], "session-idle") && !hasStatus(replies[0], "interrupt-id-mismatch") {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+		if replies := c.replies(id); !hasStatus(replies[len(replies)-2], "interrupted") {
+			t.Fatalf("eval %s was not interrupted: %v", id, replies)
+		}
+	}
+}
+
+func TestLazyResults(t *testing.T) {
+	srv, c := startServer(t)
+	defer srv.Close()
+	s := c.clone()
+	if actual := collect(c.call(message{"op": "eval", "session": s, "code": "(range)"}), "value"); !strings.HasSuffix(actual, " 98 99 ...)") {
+		t.Errorf("expected (range) cut short, actual %q", actual)
+	}
+	c.call(message{"op": "eval", "session": s, "code": "(define *print-length* nil)"})
+	endless := c.send(message{"op": "eval", "session": s, "code": "(range)"})
+	for {
+		replies := c.call(message{"op": "interrupt", "session": s, "interrupt-id": endless})
+		if !hasStatus(replies[0], "session-idle") {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	replies := c.replies(endless)
+	if !hasStatus(replies[len(replies)-2], "interrupted") {
+		t.Errorf("printing was not interrupted: %v", replies)
+	}
+}
+
+func TestMalformedInputClosesItsConnection(t *testing.T) {
+	srv, c := startServer(t)
+	defer srv.Close()
+	bad, err := net.Dial("tcp", c.conn.RemoteAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bad.Close()
+	bad.SetDeadline(time.Now().Add(10 * time.Second))
+	bad.Write([]byte(strings.Repeat("l", 1000)))
+	if _, err := bad.Read(make([]byte, 1)); err == nil {
+		t.Error("the connection was not closed")
+	}
+	if actual := collect(c.call(message{"op": "eval", "code": "(+ 1 2)"}), "value"); actual != "3" {
+		t.Errorf("another connection: %q", actual)
+	}
+}
+
+func TestSessionsAndErrors(t *testing.T) {
+	srv, c := startServer(t)
+	defer srv.Close()
+	s := c.clone()
+	replies := c.call(message{"op": "describe"})
+	ops := replies[0]["ops"].(map[string]interface{})
+	if _, ok := ops["eval"]; !ok {
+		t.Errorf("describe: %v", replies)
+	}
+	if sessions := c.call(message{"op": "ls-sessions"})[0]["sessions"]; !reflect.DeepEqual(sessions, []interface{}{s}) {
+		t.Errorf("ls-sessions: %v", sessions)
+	}
+	if replies := c.call(message{"op": "close", "session": s}); !hasStatus(replies[0], "session-closed") {
+		t.Errorf("close: %v", replies)
+	}
+	if replies := c.call(message{"op": "eval", "session": s, "code": "1"}); !hasStatus(replies[0], "unknown-session") {
+		t.Errorf("eval in a closed session: %v", replies)
+	}
+	if replies := c.call(message{"op": "frobnicate"}); !hasStatus(replies[0], "unknown-op") {
+		t.Errorf("unknown op: %v", replies)
+	}
+}
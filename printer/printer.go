@@ -2,6 +2,7 @@ package printer
 
 import (
 	"fmt"
+	"math/big"
 	"strings"
 )
 
@@ -11,30 +12,68 @@ import (
 
 func PrintList(lst []types.Top, printReadable bool,
 	start string, end string, join string) string {
-	strList := make([]string, 0, len(lst))
+	return printList(lst, false, printReadable, -1, start, end, join)
+}
+
+// printList prints lst, followed by ... if more, with at most length
+// elements of every collection in it.
+func printList(lst []types.Top, more bool, printReadable bool, length int,
+	start string, end string, join string) string {
+	if length >= 0 && len(lst) > length {
+		lst, more = lst[:length], true
+	}
+	strList := make([]string, 0, len(lst)+1)
 	for _, e := range lst {
-		strList = append(strList, PrintString(e, printReadable))
+		strList = append(strList, printString(e, printReadable, length))
+	}
+	if more {
+		strList = append(strList, "...")
 	}
 	return start + strings.Join(strList, join) + end
 }
 
 func PrintString(obj types.Top, printReadable bool) string {
+	return printString(obj, printReadable, -1)
+}
+
+// PrintLength prints obj as PrintString does, but only the first length
+// elements of every collection in it, followed by ... if it has more, so
+// that an infinite lazy sequence can be printed. A negative length
+// prints them all.
+func PrintLength(obj types.Top, printReadable bool, length int) string {
+	return printString(obj, printReadable, length)
+}
+
+func printString(obj types.Top, printReadable bool, length int) string {
 	switch tobj := obj.(type) {
 	case types.List:
-		return PrintList(tobj.Val, printReadable, "(", ")", " ")
+		return printList(tobj.Val, false, printReadable, length, "(", ")", " ")
 	case types.Vector:
-		return PrintList(tobj.Val, printReadable, "[", "]", " ")
-	case types.HashMap:
-		str_list := make([]string, 0, len(tobj.Val)*2)
-		for k, v := range tobj.Val {
-			str_list = append(str_list, PrintString(k, printReadable))
-			str_list = append(str_list, PrintString(v, printReadable))
+		return printList(tobj.Slice(), false, printReadable, length, "[", "]", " ")
+	case *types.LazySeq, types.Cons:
+		slc, more, e := types.Take(tobj, length)
+		if e != nil {
+			return "<lazy-seq: " + e.Error() + ">"
 		}
+		return printList(slc, more, printReadable, length, "(", ")", " ")
+	case types.HashMap:
+		str_list := make([]string, 0, tobj.Len()*2)
+		n := 0
+		tobj.Each(func(k types.Top, v types.Top) bool {
+			if n == length {
+				str_list = append(str_list, "...")
+				return false
+			}
+			n += 1
+			str_list = append(str_list, printString(k, printReadable, length))
+			str_list = append(str_list, printString(v, printReadable, length))
+			return true
+		})
 		return "{" + strings.Join(str_list, " ") + "}"
+	case types.Keyword:
+		return tobj.String()
 	case string:
-		if strings.HasPrefix(tobj, "\u029e") {
-			return ":" + tobj[2:len(tobj)]
-		} else if printReadable {
+		if printReadable {
 			return `"` + strings.Replace(
 				strings.Replace(
 					strings.Replace(tobj, `\`, `\\`, -1),
@@ -45,17 +84,37 @@ func PrintString(obj types.Top, printReadable bool) string {
 		}
 	case types.Symbol:
 		return tobj.Val
+	case types.Gensym:
+		return tobj.Val
+	case float64:
+		return types.FormatFloat(tobj)
+	case *big.Int:
+		return tobj.String()
+	case *big.Rat:
+		return tobj.RatString()
 	case nil:
 		return "nil"
-	case types.MalFunc:
-		return "(lambda " +
-			PrintString(tobj.Params, true) + " " +
-			PrintString(tobj.Exp, true) + ")"
 	case func([]types.Top) (types.Top, error):
 		return fmt.Sprintf("<function %v>", obj)
 	case *types.Atom:
 		return "(atom " +
-			PrintString(tobj.Val, true) + ")"
+			printString(tobj.Deref(), true, length) + ")"
+	case *types.Future:
+		if tobj.Realized() {
+			return "<future done>"
+		}
+		return "<future pending>"
+	case *types.Delay:
+		if tobj.Realized() {
+			return "<delay done>"
+		}
+		return "<delay pending>"
+	case *types.Chan:
+		return "<chan>"
+	case *types.Continuation:
+		return "<continuation>"
+	case types.GoObject:
+		return fmt.Sprintf("<go %T>", tobj.Val)
 	default:
 		return fmt.Sprintf("%v", obj)
 	}
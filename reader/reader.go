@@ -1,10 +1,9 @@
 package reader
 
 import (
-	"errors"
 	"regexp"
-	"strconv"
 	"strings"
+	"unicode/utf8"
 	//"fmt"
 )
 
@@ -15,11 +14,13 @@ import (
 type Reader interface {
 	next() *string
 	peek() *string
+	pos() SourcePos
 }
 
 type TokenReader struct {
-	tokens   []string
-	position int
+	tokens    []string
+	positions []SourcePos
+	position  int
 }
 
 func (tr *TokenReader) next() *string {
@@ -38,42 +39,111 @@ func (tr *TokenReader) peek() *string {
 	return &tr.tokens[tr.position]
 }
 
-func tokenize(str string) []string {
+// pos returns where the next token starts.
+func (tr *TokenReader) pos() SourcePos {
+	if tr.position >= len(tr.positions) {
+		if len(tr.positions) == 0 {
+			return SourcePos{}
+		}
+		return tr.positions[len(tr.positions)-1]
+	}
+	return tr.positions[tr.position]
+}
+
+func readError(msg string) error {
+	return NewError(ReadError, "", nil, msg)
+}
+
+// incompleteError is the error of input that ends inside a form. It
+// carries :incomplete true, so that the REPL can tell it from other read
+// errors and read more lines; see Incomplete.
+func incompleteError(msg string) error {
+	e := NewError(ReadError, "", nil, msg)
+	e.Obj = e.Obj.(HashMap).Assoc(InternKeyword("incomplete"), true)
+	return e
+}
+
+// Incomplete reports whether e is the error of reading input that ends
+// inside a form, such as an unclosed list or string, which more input
+// could complete.
+func Incomplete(e error) bool {
+	lge, ok := e.(LGError)
+	if !ok {
+		return false
+	}
+	v, ok := ErrorField(lge.Obj, "incomplete")
+	return ok && v == true
+}
+
+func tokenize(str string, file string) ([]string, []SourcePos) {
 	results := make([]string, 0, 1)
-	// Work around lack of quoting in backtick
+	positions := make([]SourcePos, 0, 1)
+	// Work around lack of quoting in backtick. A string missing its
+	// closing quote runs to the end of str, for read_atom to report.
 	re := regexp.MustCompile(`[\s,]*(~@|[\[\]{}()'` + "`" +
-		`~^@]|"(?:\\.|[^\\"])*"|;.*|[^\s\[\]{}('"` + "`" +
+		`~^@]|"(?:\\.|[^\\"])*"?|;.*|[^\s\[\]{}('"` + "`" +
 		`,;)]*)`)
-	for _, group := range re.FindAllStringSubmatch(str, -1) {
-		if (group[1] == "") || (group[1][0] == ';') {
+	line, lineStart, scanned := 1, 0, 0
+	for _, loc := range re.FindAllStringSubmatchIndex(str, -1) {
+		token := str[loc[2]:loc[3]]
+		if (token == "") || (token[0] == ';') {
 			continue
 		}
-		results = append(results, group[1])
+		// Count the newlines between the previous token and this one.
+		for i := scanned; i < loc[2]; i++ {
+			if str[i] == '\n' {
+				line++
+				lineStart = i + 1
+			}
+		}
+		scanned = loc[2]
+		col := utf8.RuneCountInString(str[lineStart:loc[2]]) + 1
+		results = append(results, token)
+		positions = append(positions, SourcePos{file, line, col})
 	}
-	return results
+	return results, positions
+}
+
+// unescape undoes the escapes \\, \" and \n of a string literal; any
+// other backslash is kept as it is.
+func unescape(str string) string {
+	if !strings.Contains(str, `\`) {
+		return str
+	}
+	var b strings.Builder
+	for i := 0; i < len(str); i += 1 {
+		if str[i] == '\\' && i+1 < len(str) {
+			switch str[i+1] {
+			case '\\', '"':
+				b.WriteByte(str[i+1])
+				i += 1
+				continue
+			case 'n':
+				b.WriteByte('\n')
+				i += 1
+				continue
+			}
+		}
+		b.WriteByte(str[i])
+	}
+	return b.String()
 }
 
 func read_atom(rdr Reader) (Top, error) {
 	token := rdr.next()
 	if token == nil {
-		return nil, errors.New("read_atom underflow")
+		return nil, readError("read_atom underflow")
 	}
-	if match, _ := regexp.MatchString(`^-?[0-9]+$`, *token); match {
-		var i int
-		var e error
-		if i, e = strconv.Atoi(*token); e != nil {
-			return nil, errors.New("number parse error")
+	if n, ok, e := ParseNumber(*token); ok {
+		if e != nil {
+			return nil, e
 		}
-		return i, nil
+		return n, nil
 	} else if (*token)[0] == '"' {
-		str := (*token)[1 : len(*token)-1]
-		return strings.Replace(
-			strings.Replace(
-				strings.Replace(
-					strings.Replace(str, `\\`, "\u029e", -1),
-					`\"`, `"`, -1),
-				`\n`, "\n", -1),
-			"\u029e", "\\", -1), nil
+		if !terminated(*token) {
+			return nil, incompleteError("expected '\"', got EOF")
+		}
+		return unescape((*token)[1 : len(*token)-1]), nil
 	} else if (*token)[0] == ':' {
 		return NewKeyword((*token)[1:len(*token)])
 	} else if *token == "nil" {
@@ -85,23 +155,37 @@ func read_atom(rdr Reader) (Top, error) {
 	} else {
 		return Symbol{*token}, nil
 	}
-	return token, nil
+}
+
+// terminated reports whether the string literal token ends with a
+// closing quote rather than with the end of the input.
+func terminated(token string) bool {
+	for i := 1; i < len(token); i += 1 {
+		switch token[i] {
+		case '\\':
+			i += 1
+		case '"':
+			return true
+		}
+	}
+	return false
 }
 
 func readList(rdr Reader, start string, end string) (Top, error) {
+	pos := rdr.pos()
 	token := rdr.next()
 	if token == nil {
-		return nil, errors.New("readList underflow")
+		return nil, readError("readList underflow")
 	}
 	if *token != start {
-		return nil, errors.New("expected '" + start + "'")
+		return nil, readError("expected '" + start + "'")
 	}
 
 	ast_list := []Top{}
 	token = rdr.peek()
 	for ; true; token = rdr.peek() {
 		if token == nil {
-			return nil, errors.New("exepected '" + end + "', got EOF")
+			return nil, incompleteError("expected '" + end + "', got EOF")
 		}
 		if *token == end {
 			break
@@ -113,7 +197,7 @@ func readList(rdr Reader, start string, end string) (Top, error) {
 		ast_list = append(ast_list, f)
 	}
 	rdr.next()
-	return List{ast_list, nil}, nil
+	return List{ast_list, PosMeta(pos)}, nil
 }
 
 func read_vector(rdr Reader) (Top, error) {
@@ -121,7 +205,8 @@ func read_vector(rdr Reader) (Top, error) {
 	if e != nil {
 		return nil, e
 	}
-	vec := Vector{lst.(List).Val, nil}
+	vec := NewVector(lst.(List).Val...)
+	vec.Meta = lst.(List).Meta
 	return vec, nil
 }
 
@@ -130,13 +215,20 @@ func read_hash_map(rdr Reader) (Top, error) {
 	if e != nil {
 		return nil, e
 	}
-	return NewHashMap(mal_lst)
+	hm, e := NewHashMap(mal_lst)
+	if e != nil {
+		return nil, e
+	}
+	m := hm.(HashMap)
+	m.Meta = mal_lst.(List).Meta
+	return m, nil
 }
 
 func read_form(rdr Reader) (Top, error) {
+	pos := rdr.pos()
 	token := rdr.peek()
 	if token == nil {
-		return nil, errors.New("read_form underflow")
+		return nil, incompleteError("read_form underflow")
 	}
 	switch *token {
 
@@ -146,28 +238,28 @@ func read_form(rdr Reader) (Top, error) {
 		if e != nil {
 			return nil, e
 		}
-		return List{[]Top{Symbol{"quote"}, form}, nil}, nil
+		return List{[]Top{Symbol{"quote"}, form}, PosMeta(pos)}, nil
 	case "`":
 		rdr.next()
 		form, e := read_form(rdr)
 		if e != nil {
 			return nil, e
 		}
-		return List{[]Top{Symbol{"quasiquote"}, form}, nil}, nil
+		return List{[]Top{Symbol{"quasiquote"}, form}, PosMeta(pos)}, nil
 	case `~`:
 		rdr.next()
 		form, e := read_form(rdr)
 		if e != nil {
 			return nil, e
 		}
-		return List{[]Top{Symbol{"unquote"}, form}, nil}, nil
+		return List{[]Top{Symbol{"unquote"}, form}, PosMeta(pos)}, nil
 	case `~@`:
 		rdr.next()
 		form, e := read_form(rdr)
 		if e != nil {
 			return nil, e
 		}
-		return List{[]Top{Symbol{"splice-unquote"}, form}, nil}, nil
+		return List{[]Top{Symbol{"splice-unquote"}, form}, PosMeta(pos)}, nil
 	case `^`:
 		rdr.next()
 		meta, e := read_form(rdr)
@@ -178,43 +270,63 @@ func read_form(rdr Reader) (Top, error) {
 		if e != nil {
 			return nil, e
 		}
-		return List{[]Top{Symbol{"with-meta"}, form, meta}, nil}, nil
+		return List{[]Top{Symbol{"with-meta"}, form, meta}, PosMeta(pos)}, nil
 	case `@`:
 		rdr.next()
 		form, e := read_form(rdr)
 		if e != nil {
 			return nil, e
 		}
-		return List{[]Top{Symbol{"deref"}, form}, nil}, nil
+		return List{[]Top{Symbol{"deref"}, form}, PosMeta(pos)}, nil
 
 		// list
 	case ")":
-		return nil, errors.New("unexpected ')'")
+		return nil, readError("unexpected ')'")
 	case "(":
 		return readList(rdr, "(", ")")
 
 		// vector
 	case "]":
-		return nil, errors.New("unexpected ']'")
+		return nil, readError("unexpected ']'")
 	case "[":
 		return read_vector(rdr)
 
 		// hash-map
 	case "}":
-		return nil, errors.New("unexpected '}'")
+		return nil, readError("unexpected '}'")
 	case "{":
 		return read_hash_map(rdr)
 	default:
 		return read_atom(rdr)
 	}
-	return read_atom(rdr)
 }
 
 func Read_str(str string) (Top, error) {
-	var tokens = tokenize(str)
+	return Read_str_file(str, "")
+}
+
+// Read_str_file reads the first form of str, recording file in the source
+// positions of the forms read.
+func Read_str_file(str string, file string) (Top, error) {
+	tokens, positions := tokenize(str, file)
 	if len(tokens) == 0 {
-		return nil, errors.New("<empty line>")
+		return nil, readError("<empty line>")
 	}
 
-	return read_form(&TokenReader{tokens: tokens, position: 0})
+	return read_form(&TokenReader{tokens: tokens, positions: positions, position: 0})
+}
+
+// Read_all reads every form of str, e.g. the contents of a source file.
+func Read_all(str string, file string) ([]Top, error) {
+	tokens, positions := tokenize(str, file)
+	rdr := &TokenReader{tokens: tokens, positions: positions, position: 0}
+	forms := []Top{}
+	for rdr.peek() != nil {
+		form, e := read_form(rdr)
+		if e != nil {
+			return nil, e
+		}
+		forms = append(forms, form)
+	}
+	return forms, nil
 }
@@ -0,0 +1,95 @@
+package reader
+
+import (
+	"testing"
+)
+
+import (
+	. "github.com/ntaoo/lispgo/types"
+)
+
+func TestFormPositions(t *testing.T) {
+	forms, err := Read_all("(define x 1)\n\n  (foo [1 2]\n     {\"a\" (bar)})", "test.lisp")
+	if err != nil {
+		t.Fatalf("Read_all: %v", err)
+	}
+	if len(forms) != 2 {
+		t.Fatalf("expected 2 forms, got %d", len(forms))
+	}
+	foo := forms[1].(List)
+	hm := foo.Val[2].(HashMap)
+	nested, _ := hm.Get("a")
+	cases := []struct {
+		title string
+		form  Top
+		pos   SourcePos
+	}{
+		{"first form", forms[0], SourcePos{"test.lisp", 1, 1}},
+		{"indented form", foo, SourcePos{"test.lisp", 3, 3}},
+		{"vector", foo.Val[1], SourcePos{"test.lisp", 3, 8}},
+		{"hash-map", hm, SourcePos{"test.lisp", 4, 6}},
+		{"nested list", nested, SourcePos{"test.lisp", 4, 11}},
+	}
+	for _, c := range cases {
+		pos, ok := FormPos(c.form)
+		if !ok {
+			t.Errorf("%v: no position recorded", c.title)
+		} else if pos != c.pos {
+			t.Errorf("%v: expected %v, actual %v", c.title, c.pos, pos)
+		}
+	}
+}
+
+func TestQuotePosition(t *testing.T) {
+	form, err := Read_str_file("  'x", "q.lisp")
+	if err != nil {
+		t.Fatalf("Read_str_file: %v", err)
+	}
+	pos, ok := FormPos(form)
+	if !ok || pos != (SourcePos{"q.lisp", 1, 3}) {
+		t.Errorf("expected q.lisp:1:3, actual %v", pos)
+	}
+}
+
+func TestColumnsCountRunes(t *testing.T) {
+	form, err := Read_str_file("\"日é\" (a)", "")
+	if err != nil {
+		t.Fatalf("Read_str_file: %v", err)
+	}
+	if form != "日é" {
+		t.Fatalf("unexpected form %#v", form)
+	}
+	forms, _ := Read_all("\"日é\" (a)", "")
+	pos, _ := FormPos(forms[1])
+	if pos.Column != 6 {
+		t.Errorf("expected column 6, actual %v", pos.Column)
+	}
+}
+
+func TestIncomplete(t *testing.T) {
+	cases := []struct {
+		code       string
+		incomplete bool
+	}{
+		{"(define (f x)", true},
+		{"(define (f x)\n  [1 {:a", true},
+		{"'", true},
+		{"(quote ^{:a 1}", true},
+		{"\"abc", true},
+		{"(str \"a\\\"", true},
+		{"(str \"a;b\") ; (", false},
+		{"(+ 1 2))", false},
+		{"(+ 1 2]", false},
+		{"{:a}", false},
+		{"(+ 1 2) (f", true},
+	}
+	for _, c := range cases {
+		_, err := Read_all(c.code, "")
+		if Incomplete(err) != c.incomplete {
+			t.Errorf("%q: expected incomplete %v, actual error %v", c.code, c.incomplete, err)
+		}
+	}
+	if form, err := Read_str("\"a\\\\\""); err != nil || form != "a\\" {
+		t.Errorf("a string ending in an escaped backslash: %#v, %v", form, err)
+	}
+}
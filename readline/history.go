@@ -0,0 +1,143 @@
+package readline
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultHistorySize is how many lines a History keeps unless told
+// otherwise.
+const DefaultHistorySize = 1000
+
+// History is the list of lines entered, oldest first. Entering a line
+// again moves it to the end rather than adding it twice, and only the
+// newest Max lines are kept. If Path is set, every change is saved to
+// that file, one line per line with newlines and backslashes escaped.
+type History struct {
+	Path  string
+	Max   int
+	lines []string
+}
+
+// LoadHistory returns the history kept in the file path, which need not
+// exist yet. An empty path keeps the history in memory only. max limits
+// its size; 0 means DefaultHistorySize.
+func LoadHistory(path string, max int) (*History, error) {
+	h := &History{Path: path, Max: max}
+	if path == "" {
+		return h, nil
+	}
+	content, e := ioutil.ReadFile(path)
+	if os.IsNotExist(e) {
+		return h, nil
+	} else if e != nil {
+		return nil, e
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		if line != "" {
+			h.add(unescape(line))
+		}
+	}
+	h.trim()
+	return h, nil
+}
+
+// Lines returns the lines of the history, oldest first.
+func (h *History) Lines() []string {
+	return append([]string(nil), h.lines...)
+}
+
+// Add adds a line to the end of the history, unless it is blank, and
+// saves the history if it has a file.
+func (h *History) Add(line string) error {
+	if strings.TrimSpace(line) == "" {
+		return nil
+	}
+	h.add(line)
+	h.trim()
+	if h.Path == "" {
+		return nil
+	}
+	return h.save()
+}
+
+func (h *History) add(line string) {
+	for i, old := range h.lines {
+		if old == line {
+			h.lines = append(h.lines[:i], h.lines[i+1:]...)
+			break
+		}
+	}
+	h.lines = append(h.lines, line)
+}
+
+func (h *History) trim() {
+	max := h.Max
+	if max <= 0 {
+		max = DefaultHistorySize
+	}
+	if len(h.lines) > max {
+		h.lines = append([]string(nil), h.lines[len(h.lines)-max:]...)
+	}
+}
+
+// save writes the history to a temporary file and renames it over the
+// old one, so that the history is never left half written.
+func (h *History) save() error {
+	var b strings.Builder
+	for _, line := range h.lines {
+		b.WriteString(escape(line))
+		b.WriteByte('\n')
+	}
+	tmp, e := ioutil.TempFile(filepath.Dir(h.Path), filepath.Base(h.Path)+".tmp")
+	if e != nil {
+		return e
+	}
+	defer os.Remove(tmp.Name())
+	if _, e := tmp.WriteString(b.String()); e != nil {
+		tmp.Close()
+		return e
+	}
+	if e := tmp.Close(); e != nil {
+		return e
+	}
+	if e := os.Chmod(tmp.Name(), 0600); e != nil {
+		return e
+	}
+	return os.Rename(tmp.Name(), h.Path)
+}
+
+// search returns the index of the newest line before from that contains
+// query, or -1.
+func (h *History) search(query string, from int) int {
+	if from > len(h.lines) {
+		from = len(h.lines)
+	}
+	for i := from - 1; i >= 0; i -= 1 {
+		if strings.Contains(h.lines[i], query) {
+			return i
+		}
+	}
+	return -1
+}
+
+func escape(line string) string {
+	return strings.Replace(strings.Replace(line, `\`, `\\`, -1), "\n", `\n`, -1)
+}
+
+func unescape(line string) string {
+	var b strings.Builder
+	for i := 0; i < len(line); i += 1 {
+		if line[i] == '\\' && i+1 < len(line) {
+			i += 1
+			if line[i] == 'n' {
+				b.WriteByte('\n')
+				continue
+			}
+		}
+		b.WriteByte(line[i])
+	}
+	return b.String()
+}
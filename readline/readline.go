@@ -1,79 +1,751 @@
+// Package readline is a line editor for the REPL, written in Go so that
+// lispgo needs no C library. It has the emacs keys of GNU readline:
+//
+//	C-a C-e C-b C-f M-b M-f   move to the start or end, by character or word
+//	C-d C-h C-k C-u C-w M-d   delete a character, or kill text
+//	C-y C-t C-l               yank the last kill, transpose, clear the screen
+//	C-p C-n, up down          walk the history, or the lines of the input
+//	C-r                       search the history backwards as you type
+//	Tab                       complete the word (see Options.Complete), or
+//	                          indent
+//	Enter                     end the input, or start a new line if it is
+//	                          incomplete (see Options.Incomplete)
+//	M-Enter                   start a new line in the input
+//	C-c                       abandon the input (ErrInterrupt)
+//	C-d on an empty line      end of input (io.EOF)
+//
+// If the input is not a terminal, lines are read as they are without
+// editing, so the REPL can be driven by a pipe. Pasting text, or piping
+// it, works the same as typing it.
 package readline
 
-/*
-// IMPORTANT: choose one
-#cgo LDFLAGS: -ledit
-//#cgo LDFLAGS: -lreadline // NOTE: libreadline is GPL
-
-// free()
-#include <stdlib.h>
-// readline()
-#include <stdio.h> // FILE *
-#include <readline/readline.h>
-// add_history()
-#include <readline/history.h>
-*/
-import "C"
-
 import (
+	"bufio"
 	"errors"
 	"fmt"
-	"io/ioutil"
-	"os"
-	"path/filepath"
+	"io"
 	"strings"
-	"unsafe"
+	"sync"
+	"unicode"
 )
 
-var HISTORY_FILE = ".mal-history"
-var history_path string
+// ErrInterrupt is returned by Readline when C-c is typed.
+var ErrInterrupt = errors.New("interrupt")
+
+// Options configures a new Editor.
+type Options struct {
+	// Terminal is what the Editor reads keys from and draws on. Nil
+	// means Stdio.
+	Terminal Terminal
+	// History is the history the Editor walks and adds lines to. Nil
+	// means a history kept in memory only.
+	History *History
+	// ContinuationPrompt is shown before every line of the input but the
+	// first. By default it is spaces as wide as the prompt.
+	ContinuationPrompt string
+	// Incomplete reports whether the input typed so far needs more
+	// lines, such as a form whose parentheses are not closed yet. If it
+	// does, Enter starts a new line instead of ending the input. Nil
+	// means the input is always complete.
+	Incomplete func(input string) bool
+	// Complete is called when Tab is typed after something other than
+	// indentation, with the input before the cursor. It returns the
+	// word at the end of that input that is being completed, and the
+	// candidates it could be completed to. Nil means Tab only indents.
+	Complete func(before string) (word string, candidates []string)
+}
+
+// Editor reads lines from a terminal.
+type Editor struct {
+	term    Terminal
+	in      *bufio.Reader
+	history *History
+	cont    string
+	more    func(string) bool
+	comp    func(string) (string, []string)
+	// kill is the text C-y yanks.
+	kill []rune
+	// row is the row of the cursor below that of the prompt.
+	row int
+}
+
+// New returns an Editor.
+func New(opts Options) *Editor {
+	ed := &Editor{term: opts.Terminal, history: opts.History, cont: opts.ContinuationPrompt, more: opts.Incomplete, comp: opts.Complete}
+	if ed.term == nil {
+		ed.term = Stdio()
+	}
+	if ed.history == nil {
+		ed.history = &History{}
+	}
+	ed.in = bufio.NewReader(ed.term)
+	return ed
+}
+
+// History returns the history of the editor.
+func (ed *Editor) History() *History {
+	return ed.history
+}
 
-func loadHistory(filename string) error {
-	content, err := ioutil.ReadFile(history_path)
-	if err != nil {
-		return err
+// Readline shows prompt and returns the line typed after it, which is
+// added to the history. The line may hold several lines of input,
+// separated by newlines. If the history cannot be saved, that is
+// reported on the terminal and it is kept in memory from then on.
+func (ed *Editor) Readline(prompt string) (string, error) {
+	restore, e := ed.term.MakeRaw()
+	if e != nil {
+		return ed.readPlain(prompt)
+	}
+	l := &line{ed: ed, prompt: prompt, hist: len(ed.history.lines)}
+	res, e := l.edit()
+	restore()
+	if e != nil {
+		return "", e
 	}
+	if e := ed.history.Add(res); e != nil {
+		fmt.Fprintf(ed.term, "readline: cannot save the history: %v\n", e)
+		ed.history.Path = ""
+	}
+	return res, nil
+}
+
+var (
+	defaultMu     sync.Mutex
+	defaultEditor *Editor
+)
+
+// Readline reads a line with the default editor: one of Stdio with its
+// history in memory, unless SetDefault has set another.
+func Readline(prompt string) (string, error) {
+	defaultMu.Lock()
+	if defaultEditor == nil {
+		defaultEditor = New(Options{})
+	}
+	ed := defaultEditor
+	defaultMu.Unlock()
+	return ed.Readline(prompt)
+}
+
+// SetDefault makes ed the editor Readline uses, so that it shares the
+// input and history of the REPL.
+func SetDefault(ed *Editor) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	defaultEditor = ed
+}
+
+// readPlain reads a line without editing it, and the lines after it
+// while the input is incomplete.
+func (ed *Editor) readPlain(prompt string) (string, error) {
+	var lines []string
+	for {
+		io.WriteString(ed.term, prompt)
+		s, e := ed.in.ReadString('\n')
+		if e == io.EOF && s == "" && lines != nil {
+			// The input ends incomplete; the reader will say so.
+			return strings.Join(lines, "\n"), nil
+		}
+		if e == io.EOF && s != "" {
+			e = nil
+		}
+		if e != nil {
+			return "", e
+		}
+		lines = append(lines, strings.TrimRight(s, "\r\n"))
+		input := strings.Join(lines, "\n")
+		if !strings.HasSuffix(s, "\n") || !ed.incomplete(input) {
+			return input, nil
+		}
+		prompt = ed.continuation(prompt)
+	}
+}
+
+func (ed *Editor) incomplete(input string) bool {
+	return ed.more != nil && ed.more(input)
+}
+
+// continuation returns the prompt of the lines of the input after the
+// first.
+func (ed *Editor) continuation(prompt string) string {
+	if ed.cont != "" {
+		return ed.cont
+	}
+	return strings.Repeat(" ", len([]rune(prompt)))
+}
 
-	for _, add_line := range strings.Split(string(content), "\n") {
-		if add_line == "" {
+// Keys
+
+type key int
+
+// Keys that are not runes are negative. Control keys are their ASCII
+// codes.
+const (
+	keyUp key = -1 - iota
+	keyDown
+	keyLeft
+	keyRight
+	keyHome
+	keyEnd
+	keyDelete
+	keyWordLeft
+	keyWordRight
+	keyKillWord
+	keyKillWordBack
+	keyNewline
+	keyUnknown
+)
+
+func ctrl(c byte) key {
+	return key(c & 0x1f)
+}
+
+const (
+	keyEnter     = key('\r')
+	keyEscape    = key(0x1b)
+	keyBackspace = key(0x7f)
+)
+
+// readKey reads one key, decoding escape sequences.
+func (ed *Editor) readKey() (key, error) {
+	r, _, e := ed.in.ReadRune()
+	if e != nil {
+		return 0, e
+	}
+	if key(r) != keyEscape {
+		return key(r), nil
+	}
+	r, _, e = ed.in.ReadRune()
+	if e != nil {
+		return keyEscape, nil
+	}
+	switch r {
+	case '[', 'O':
+		return ed.readSequence(r)
+	case 'b', 'B':
+		return keyWordLeft, nil
+	case 'f', 'F':
+		return keyWordRight, nil
+	case 'd', 'D':
+		return keyKillWord, nil
+	case 0x7f, 0x08:
+		return keyKillWordBack, nil
+	case '\r', '\n':
+		return keyNewline, nil
+	}
+	return keyUnknown, nil
+}
+
+// readSequence decodes the CSI or SS3 sequence after ESC intro.
+func (ed *Editor) readSequence(intro rune) (key, error) {
+	var params []byte
+	for {
+		c, e := ed.in.ReadByte()
+		if e != nil {
+			return keyUnknown, nil
+		}
+		if intro == '[' && c >= 0x20 && c < 0x40 {
+			params = append(params, c)
 			continue
 		}
-		c_add_line := C.CString(add_line)
-		C.add_history(c_add_line)
-		C.free(unsafe.Pointer(c_add_line))
+		switch string(params) + string(c) {
+		case "A":
+			return keyUp, nil
+		case "B":
+			return keyDown, nil
+		case "C":
+			return keyRight, nil
+		case "D":
+			return keyLeft, nil
+		case "H", "1~", "7~":
+			return keyHome, nil
+		case "F", "4~", "8~":
+			return keyEnd, nil
+		case "3~":
+			return keyDelete, nil
+		case "1;5C", "1;3C":
+			return keyWordRight, nil
+		case "1;5D", "1;3D":
+			return keyWordLeft, nil
+		}
+		return keyUnknown, nil
 	}
+}
+
+// Editing
 
-	return nil
+// line is the state of one call of Readline.
+type line struct {
+	ed     *Editor
+	prompt string
+	buf    []rune
+	pos    int
+	// hist is the index in the history of the line shown, or its length
+	// for the line being typed, which saved holds while another is.
+	hist  int
+	saved []rune
 }
 
-func init() {
-	history_path = filepath.Join(os.Getenv("HOME"), HISTORY_FILE)
-	loadHistory(history_path)
+func (l *line) edit() (string, error) {
+	l.refresh()
+	for {
+		k, e := l.ed.readKey()
+		if e != nil {
+			if e == io.EOF && len(l.buf) > 0 {
+				return l.accept(), nil
+			}
+			l.ed.row = 0
+			return "", e
+		}
+		if k == ctrl('r') {
+			if k, e = l.search(); e != nil {
+				return "", e
+			}
+		}
+		switch k {
+		case keyEnter, key('\n'):
+			if !l.ed.incomplete(string(l.buf)) {
+				return l.accept(), nil
+			}
+			l.pos = len(l.buf)
+			l.insert('\n')
+		case ctrl('c'):
+			l.pos = len(l.buf)
+			l.refresh()
+			io.WriteString(l.ed.term, "^C\r\n")
+			l.ed.row = 0
+			return "", ErrInterrupt
+		case ctrl('d'):
+			if len(l.buf) == 0 {
+				io.WriteString(l.ed.term, "\r\n")
+				l.ed.row = 0
+				return "", io.EOF
+			}
+			l.delete(l.pos, l.pos+1)
+		case ctrl('a'), keyHome:
+			l.pos = l.lineStart()
+		case ctrl('e'), keyEnd:
+			l.pos = l.lineEnd()
+		case ctrl('b'), keyLeft:
+			if l.pos > 0 {
+				l.pos -= 1
+			}
+		case ctrl('f'), keyRight:
+			if l.pos < len(l.buf) {
+				l.pos += 1
+			}
+		case keyWordLeft:
+			l.pos = l.wordLeft()
+		case keyWordRight:
+			l.pos = l.wordRight()
+		case ctrl('h'), keyBackspace:
+			if l.pos > 0 {
+				l.delete(l.pos-1, l.pos)
+			}
+		case keyDelete:
+			l.delete(l.pos, l.pos+1)
+		case ctrl('k'):
+			l.killTo(l.lineEnd())
+		case ctrl('u'):
+			l.killTo(l.lineStart())
+		case ctrl('w'), keyKillWordBack:
+			l.killTo(l.wordLeft())
+		case keyKillWord:
+			l.killTo(l.wordRight())
+		case ctrl('y'):
+			l.insert(l.ed.kill...)
+		case ctrl('t'):
+			l.transpose()
+		case ctrl('l'):
+			io.WriteString(l.ed.term, "\x1b[H\x1b[2J")
+			l.ed.row = 0
+		case ctrl('p'), keyUp:
+			if l.row() > 0 {
+				l.moveRow(-1)
+			} else {
+				l.walk(-1)
+			}
+		case ctrl('n'), keyDown:
+			if l.row() < strings.Count(string(l.buf), "\n") {
+				l.moveRow(1)
+			} else {
+				l.walk(1)
+			}
+		case keyNewline:
+			l.insert('\n')
+		case '\t':
+			if l.ed.comp == nil || strings.TrimSpace(string(l.buf[l.lineStart():l.pos])) == "" {
+				l.insert(' ', ' ')
+			} else {
+				l.complete()
+			}
+		default:
+			if k >= ' ' && k != keyBackspace {
+				l.insert(rune(k))
+			}
+		}
+		l.refresh()
+	}
 }
 
-func Readline(prompt string) (string, error) {
-	c_prompt := C.CString(prompt)
-	defer C.free(unsafe.Pointer(c_prompt))
+// accept ends the input, leaving the cursor below it.
+func (l *line) accept() string {
+	l.pos = len(l.buf)
+	l.refresh()
+	io.WriteString(l.ed.term, "\r\n")
+	l.ed.row = 0
+	return string(l.buf)
+}
+
+// maxListed is how many candidates for a completion are listed at most.
+const maxListed = 100
+
+// complete completes the word before the cursor to its candidate if it
+// has one, or else as far as the candidates agree. If they agree no
+// further than the word, they are listed below the input.
+func (l *line) complete() {
+	word, candidates := l.ed.comp(string(l.buf[:l.pos]))
+	n := len([]rune(word))
+	if len(candidates) == 0 || n > l.pos {
+		return
+	}
+	prefix := []rune(candidates[0])
+	for _, c := range candidates[1:] {
+		prefix = commonPrefix(prefix, []rune(c))
+	}
+	if len(prefix) > n || len(candidates) == 1 {
+		l.delete(l.pos-n, l.pos)
+		l.insert(prefix...)
+		return
+	}
+	// List them below the input, for the input to be drawn again below
+	// the list.
+	pos := l.pos
+	l.pos = len(l.buf)
+	l.refresh()
+	w := l.ed.term.Width()
+	if w <= 0 {
+		w = 80
+	}
+	shown := candidates
+	if len(shown) > maxListed {
+		shown = shown[:maxListed]
+	}
+	width := 0
+	for _, c := range shown {
+		if len([]rune(c)) > width {
+			width = len([]rune(c))
+		}
+	}
+	width += 2
+	cols := w / width
+	if cols < 1 {
+		cols = 1
+	}
+	var b strings.Builder
+	b.WriteString("\r\n")
+	for i, c := range shown {
+		b.WriteString(c)
+		if (i+1)%cols == 0 || i == len(shown)-1 {
+			b.WriteString("\r\n")
+		} else {
+			b.WriteString(strings.Repeat(" ", width-len([]rune(c))))
+		}
+	}
+	if len(candidates) > len(shown) {
+		fmt.Fprintf(&b, "... and %d more\r\n", len(candidates)-len(shown))
+	}
+	io.WriteString(l.ed.term, b.String())
+	l.ed.row = 0
+	l.pos = pos
+}
+
+func commonPrefix(a, b []rune) []rune {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i += 1
+	}
+	return a[:i]
+}
 
-	c_line := C.readline(c_prompt)
-	defer C.free(unsafe.Pointer(c_line))
-	line := C.GoString(c_line)
+func (l *line) insert(rs ...rune) {
+	buf := make([]rune, 0, len(l.buf)+len(rs))
+	buf = append(append(append(buf, l.buf[:l.pos]...), rs...), l.buf[l.pos:]...)
+	l.buf = buf
+	l.pos += len(rs)
+}
 
-	if c_line == nil {
-		return "", errors.New("C.readline call failed")
+// delete deletes the runes from i up to j.
+func (l *line) delete(i, j int) {
+	if j > len(l.buf) {
+		j = len(l.buf)
+	}
+	if i >= j {
+		return
+	}
+	l.buf = append(l.buf[:i:i], l.buf[j:]...)
+	if l.pos > j {
+		l.pos -= j - i
+	} else if l.pos > i {
+		l.pos = i
 	}
-	C.add_history(c_line)
+}
 
-	// append to file
-	f, e := os.OpenFile(history_path, os.O_APPEND|os.O_WRONLY, 0600)
-	if e == nil {
-		defer f.Close()
+// killTo deletes the text between the cursor and i, keeping it for C-y.
+func (l *line) killTo(i int) {
+	from, to := l.pos, i
+	if from > to {
+		from, to = to, from
+	}
+	if from == to {
+		return
+	}
+	l.ed.kill = append([]rune(nil), l.buf[from:to]...)
+	l.delete(from, to)
+}
 
-		_, e = f.WriteString(line + "\n")
+// transpose swaps the characters before and at the cursor, or the last
+// two at the end of the line.
+func (l *line) transpose() {
+	i := l.pos
+	if i == len(l.buf) || l.buf[i] == '\n' {
+		i -= 1
+	}
+	if i < 1 || l.buf[i-1] == '\n' || l.buf[i] == '\n' {
+		return
+	}
+	l.buf[i-1], l.buf[i] = l.buf[i], l.buf[i-1]
+	l.pos = i + 1
+}
+
+// isWord reports whether r is part of a word for M-b and M-f: anything
+// but space and the punctuation of Lisp.
+func isWord(r rune) bool {
+	return !unicode.IsSpace(r) && !strings.ContainsRune("()[]{}\"'`,;", r)
+}
+
+func (l *line) wordLeft() int {
+	i := l.pos
+	for i > 0 && !isWord(l.buf[i-1]) {
+		i -= 1
+	}
+	for i > 0 && isWord(l.buf[i-1]) {
+		i -= 1
+	}
+	return i
+}
+
+func (l *line) wordRight() int {
+	i := l.pos
+	for i < len(l.buf) && !isWord(l.buf[i]) {
+		i += 1
+	}
+	for i < len(l.buf) && isWord(l.buf[i]) {
+		i += 1
+	}
+	return i
+}
+
+// lineStart and lineEnd are the ends of the line of the input the
+// cursor is on.
+func (l *line) lineStart() int {
+	i := l.pos
+	for i > 0 && l.buf[i-1] != '\n' {
+		i -= 1
+	}
+	return i
+}
+
+func (l *line) lineEnd() int {
+	i := l.pos
+	for i < len(l.buf) && l.buf[i] != '\n' {
+		i += 1
+	}
+	return i
+}
+
+// row is the line of the input the cursor is on.
+func (l *line) row() int {
+	return strings.Count(string(l.buf[:l.pos]), "\n")
+}
+
+// moveRow moves the cursor to the same column of the line d lines down.
+func (l *line) moveRow(d int) {
+	col := l.pos - l.lineStart()
+	if d < 0 {
+		// From the end of the line above to its start.
+		l.pos = l.lineStart() - 1
+		l.pos = l.lineStart()
+	} else {
+		l.pos = l.lineEnd() + 1
+	}
+	if end := l.lineEnd(); l.pos+col < end {
+		l.pos += col
+	} else {
+		l.pos = end
+	}
+}
+
+// walk shows the line d lines later in the history.
+func (l *line) walk(d int) {
+	lines := l.ed.history.lines
+	i := l.hist + d
+	if i < 0 || i > len(lines) {
+		return
+	}
+	if l.hist == len(lines) {
+		l.saved = l.buf
+	}
+	l.hist = i
+	if i == len(lines) {
+		l.buf = l.saved
+	} else {
+		l.buf = []rune(lines[i])
+	}
+	l.pos = len(l.buf)
+}
+
+// search searches the history backwards for the text typed after C-r,
+// showing the newest line that holds it. C-r again finds an older one
+// and C-g gives up. Any other key takes the line found and is returned
+// to be handled as usual.
+func (l *line) search() (key, error) {
+	h := l.ed.history
+	buf, pos := l.buf, l.pos
+	var query []rune
+	found := len(h.lines)
+	failing := false
+	show := func() {
+		status := "reverse-i-search"
+		if failing {
+			status = "failing " + status
+		}
+		var shown []rune
+		at := 0
+		if found < len(h.lines) {
+			shown, at = []rune(h.lines[found]), matchAt(h.lines[found], query)
+		}
+		l.draw(fmt.Sprintf("(%s)`%s': ", status, string(query)), shown, at)
+	}
+	find := func(from int) {
+		if i := h.search(string(query), from); i >= 0 {
+			found, failing = i, false
+		} else {
+			failing = true
+		}
+	}
+	show()
+	for {
+		k, e := l.ed.readKey()
 		if e != nil {
-			fmt.Printf("error writing to history")
+			return 0, e
 		}
+		switch {
+		case k == ctrl('r'):
+			if len(query) > 0 {
+				find(found)
+			}
+		case k == ctrl('g'):
+			l.buf, l.pos = buf, pos
+			return keyUnknown, nil
+		case k == ctrl('h') || k == keyBackspace:
+			if len(query) > 0 {
+				query = query[:len(query)-1]
+				found = len(h.lines)
+				if len(query) > 0 {
+					find(found)
+				}
+			}
+		case k >= ' ':
+			query = append(query, rune(k))
+			find(found + 1)
+		default:
+			if found < len(h.lines) {
+				l.buf, l.pos = []rune(h.lines[found]), matchAt(h.lines[found], query)
+				l.hist = found
+			}
+			return k, nil
+		}
+		show()
 	}
+}
+
+// matchAt returns the position of query in s, or 0 if it is not there.
+func matchAt(s string, query []rune) int {
+	i := strings.Index(s, string(query))
+	if i < 0 {
+		return 0
+	}
+	return len([]rune(s[:i]))
+}
 
-	return line, nil
+// Drawing
+
+func (l *line) refresh() {
+	l.draw(l.prompt, l.buf, l.pos)
+}
+
+// draw redraws the input from the row of its prompt: prompt and the
+// first line of buf, then each other line after the continuation
+// prompt, with the cursor at pos. Lines longer than the terminal wrap.
+func (l *line) draw(prompt string, buf []rune, pos int) {
+	w := l.ed.term.Width()
+	if w <= 0 {
+		w = 80
+	}
+	cont := l.ed.continuation(l.prompt)
+	var b strings.Builder
+	if l.ed.row > 0 {
+		fmt.Fprintf(&b, "\x1b[%dA", l.ed.row)
+	}
+	b.WriteString("\r\x1b[J")
+	lines := strings.Split(string(buf), "\n")
+	// row is the row the current line starts on, and end the column
+	// drawing it ends on.
+	row, end, off := 0, 0, 0
+	curRow, curCol := 0, 0
+	for i, text := range lines {
+		p := prompt
+		if i > 0 {
+			b.WriteString("\r\n")
+			p = cont
+		}
+		b.WriteString(p)
+		b.WriteString(text)
+		n := len([]rune(text))
+		width := len([]rune(p)) + n
+		if pos >= off && pos <= off+n {
+			c := len([]rune(p)) + pos - off
+			curRow, curCol = row+c/w, c%w
+			if c == width && c > 0 && c%w == 0 && i < len(lines)-1 {
+				// The end of a line that fills its last row.
+				curRow, curCol = row+c/w-1, w-1
+			}
+		}
+		if i < len(lines)-1 {
+			row += (width + w - 1) / w
+			if width == 0 {
+				row += 1
+			}
+		} else {
+			if width > 0 && width%w == 0 {
+				// Leave the pending wrap, so that the cursor is
+				// where it is counted to be.
+				b.WriteString("\r\n")
+			}
+			row += width / w
+			end = width % w
+		}
+		off += n + 1
+	}
+	if up := row - curRow; up > 0 {
+		fmt.Fprintf(&b, "\x1b[%dA", up)
+	}
+	if curCol != end || row != curRow {
+		b.WriteString("\r")
+		if curCol > 0 {
+			fmt.Fprintf(&b, "\x1b[%dC", curCol)
+		}
+	}
+	l.ed.row = curRow
+	io.WriteString(l.ed.term, b.String())
 }
@@ -0,0 +1,305 @@
+package readline
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// fakeTerm is a terminal whose keys are typed from a string.
+type fakeTerm struct {
+	in     *strings.Reader
+	out    bytes.Buffer
+	notTTY bool
+	raw    bool
+	width  int
+}
+
+func newFakeTerm(keys string) *fakeTerm {
+	return &fakeTerm{in: strings.NewReader(keys), width: 80}
+}
+
+func (t *fakeTerm) Read(p []byte) (int, error) {
+	// A key at a time, as a terminal in raw mode delivers them.
+	if len(p) > 1 {
+		p = p[:1]
+	}
+	return t.in.Read(p)
+}
+
+func (t *fakeTerm) Write(p []byte) (int, error) {
+	return t.out.Write(p)
+}
+
+func (t *fakeTerm) MakeRaw() (func() error, error) {
+	if t.notTTY {
+		return nil, errNotTerminal
+	}
+	t.raw = true
+	return func() error {
+		t.raw = false
+		return nil
+	}, nil
+}
+
+func (t *fakeTerm) Width() int {
+	return t.width
+}
+
+const (
+	up    = "\x1b[A"
+	down  = "\x1b[B"
+	left  = "\x1b[D"
+	right = "\x1b[C"
+)
+
+func TestEditing(t *testing.T) {
+	cases := []struct {
+		title, keys, expected string
+	}{
+		{"plain", "(+ 1 2)\r", "(+ 1 2)"},
+		{"backspace", "(+ 1 3\x7f2)\r", "(+ 1 2)"},
+		{"start and end", "+ 1 2\x01(\x05)\r", "(+ 1 2)"},
+		{"arrows", "(+ 12)" + left + left + " \r", "(+ 1 2)"},
+		{"home and delete", "x(+ 1 2)\x1b[H\x1b[3~\r", "(+ 1 2)"},
+		{"word motion", "(foo bar-baz qux)\x1bb\x1bbX\x1bfY\r", "(foo Xbar-bazY qux)"},
+		{"kill to end and yank", "(a b c)\x01\x06\x06\x0b)\x01\x19\r", " b c)(a)"},
+		{"kill to start", "abc def\x15xyz\r", "xyz"},
+		{"kill word back", "(list foo bar)\x02\x17\r", "(list foo )"},
+		{"kill word forward", "(list foo bar)\x01\x06\x1bd\r", "( foo bar)"},
+		{"transpose", "(lsit)\x02\x02\x02\x14\r", "(list)"},
+		{"C-d deletes", "(+ 1 2)\x01\x04\r", "+ 1 2)"},
+		{"multi-line", "(do\x1b\r  1)\r", "(do\n  1)"},
+		{"up and down between lines", "(a\x1b\rbc)" + up + "X" + down + "\x05Y\r", "(aX\nbc)Y"},
+		{"tab", "\tx\r", "  x"},
+		{"utf-8", "(str \"héllo\")" + left + left + left + "!\r", "(str \"héll!o\")"},
+		{"end of input ends the line", "(+ 1 2)", "(+ 1 2)"},
+	}
+	for _, c := range cases {
+		term := newFakeTerm(c.keys)
+		ed := New(Options{Terminal: term})
+		actual, err := ed.Readline("> ")
+		if err != nil {
+			t.Errorf("%s: %v", c.title, err)
+		} else if actual != c.expected {
+			t.Errorf("%s: expected %q, actual %q", c.title, c.expected, actual)
+		}
+		if term.raw {
+			t.Errorf("%s: the terminal was left in raw mode", c.title)
+		}
+	}
+}
+
+func TestInterruptAndEOF(t *testing.T) {
+	ed := New(Options{Terminal: newFakeTerm("(abandoned\x03\x04")})
+	if _, err := ed.Readline("> "); err != ErrInterrupt {
+		t.Errorf("C-c: expected ErrInterrupt, actual %v", err)
+	}
+	if _, err := ed.Readline("> "); err != io.EOF {
+		t.Errorf("C-d: expected io.EOF, actual %v", err)
+	}
+	if lines := ed.History().Lines(); len(lines) != 0 {
+		t.Errorf("abandoned input was added to the history: %q", lines)
+	}
+}
+
+func TestHistoryNavigation(t *testing.T) {
+	ed := New(Options{Terminal: newFakeTerm("one\rtwo\rthr" + up + up + "\r" + "thr" + up + down + "ee\r")})
+	var lines []string
+	for i := 0; i < 4; i += 1 {
+		line, err := ed.Readline("> ")
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, line)
+	}
+	expected := []string{"one", "two", "one", "three"}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Errorf("expected %q, actual %q", expected, lines)
+	}
+	if hist := ed.History().Lines(); !reflect.DeepEqual(hist, []string{"two", "one", "three"}) {
+		t.Errorf("history: %q", hist)
+	}
+}
+
+func TestReverseSearch(t *testing.T) {
+	h := &History{}
+	for _, line := range []string{"(define x 1)", "(+ x 2)", "(define y 3)", "(prn y)"} {
+		h.Add(line)
+	}
+	cases := []struct {
+		title, keys, expected string
+	}{
+		{"newest match", "\x12def\r", "(define y 3)"},
+		{"older match", "\x12def\x12\r", "(define x 1)"},
+		{"edit the match", "\x12x 2\x05\x02 3\r", "(+ x 2 3)"},
+		{"give up", "old\x12def\x07\r", "old"},
+		{"failing", "\x12zzz\r", ""},
+		{"backspace widens", "\x12prnx\x7f\r", "(prn y)"},
+	}
+	for _, c := range cases {
+		term := newFakeTerm(c.keys)
+		ed := New(Options{Terminal: term, History: &History{lines: h.Lines()}})
+		actual, err := ed.Readline("> ")
+		if err != nil {
+			t.Errorf("%s: %v", c.title, err)
+		} else if actual != c.expected {
+			t.Errorf("%s: expected %q, actual %q", c.title, c.expected, actual)
+		}
+		if c.title == "failing" && !strings.Contains(term.out.String(), "failing reverse-i-search") {
+			t.Errorf("%s: the search was not shown failing", c.title)
+		}
+	}
+}
+
+func TestNotATerminal(t *testing.T) {
+	term := newFakeTerm("(+ 1 2)\r\nlast")
+	term.notTTY = true
+	ed := New(Options{Terminal: term})
+	for _, expected := range []string{"(+ 1 2)", "last"} {
+		line, err := ed.Readline("> ")
+		if err != nil || line != expected {
+			t.Errorf("expected %q, actual %q, %v", expected, line, err)
+		}
+	}
+	if _, err := ed.Readline("> "); err != io.EOF {
+		t.Errorf("expected io.EOF, actual %v", err)
+	}
+	if len(ed.History().Lines()) != 0 {
+		t.Error("lines read from a pipe were added to the history")
+	}
+}
+
+func TestDrawWrapsLongLines(t *testing.T) {
+	term := newFakeTerm("0123456789\x01\r")
+	term.width = 6
+	New(Options{Terminal: term}).Readline("> ")
+	out := term.out.String()
+	// After C-a the cursor goes back up two rows to that of the prompt,
+	// to column 2.
+	if !strings.Contains(out, "\x1b[2A\r\x1b[2C") {
+		t.Errorf("the cursor was not moved up to the prompt: %q", out)
+	}
+}
+
+func TestHistoryFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "readline")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "history")
+	h, err := LoadHistory(path, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"a", "b", "  ", "a", "c\\d", "(do\n  e)"} {
+		if err := h.Add(line); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected := []string{"a", "c\\d", "(do\n  e)"}
+	if !reflect.DeepEqual(h.Lines(), expected) {
+		t.Errorf("expected %q, actual %q", expected, h.Lines())
+	}
+	loaded, err := LoadHistory(path, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded.Lines(), expected) {
+		t.Errorf("loaded %q, expected %q", loaded.Lines(), expected)
+	}
+	if loaded, _ := LoadHistory(path, 2); !reflect.DeepEqual(loaded.Lines(), expected[1:]) {
+		t.Errorf("the size limit was not applied on load: %q", loaded.Lines())
+	}
+	if _, err := LoadHistory(filepath.Join(dir, "missing"), 0); err != nil {
+		t.Errorf("a missing history file is not an error: %v", err)
+	}
+}
+
+// unbalanced is an Incomplete that waits for the parentheses to close.
+func unbalanced(input string) bool {
+	return strings.Count(input, "(") > strings.Count(input, ")")
+}
+
+func TestContinuation(t *testing.T) {
+	term := newFakeTerm("(define (f x)\r(* x 2))\r(f" + left + "\r3)\r")
+	ed := New(Options{Terminal: term, ContinuationPrompt: "... ", Incomplete: unbalanced})
+	for _, expected := range []string{"(define (f x)\n(* x 2))", "(f\n3)"} {
+		actual, err := ed.Readline("> ")
+		if err != nil || actual != expected {
+			t.Errorf("expected %q, actual %q, %v", expected, actual, err)
+		}
+	}
+	if !strings.Contains(term.out.String(), "\r\n... (* x 2))") {
+		t.Errorf("the continuation prompt was not shown: %q", term.out.String())
+	}
+	if hist := ed.History().Lines(); len(hist) != 2 || hist[0] != "(define (f x)\n(* x 2))" {
+		t.Errorf("history: %q", hist)
+	}
+}
+
+func TestContinuationNotATerminal(t *testing.T) {
+	term := newFakeTerm("(do\n  1\n  2)\n(+ 1\n")
+	term.notTTY = true
+	ed := New(Options{Terminal: term, Incomplete: unbalanced})
+	for _, expected := range []string{"(do\n  1\n  2)", "(+ 1"} {
+		actual, err := ed.Readline("> ")
+		if err != nil || actual != expected {
+			t.Errorf("expected %q, actual %q, %v", expected, actual, err)
+		}
+	}
+	if _, err := ed.Readline("> "); err != io.EOF {
+		t.Errorf("expected io.EOF, actual %v", err)
+	}
+	if out := term.out.String(); out != ">     >   > " {
+		t.Errorf("prompts: %q", out)
+	}
+}
+
+// words completes the last word of the input from a fixed list.
+func words(before string) (string, []string) {
+	word := before[strings.LastIndexAny(before, " (")+1:]
+	var res []string
+	for _, w := range []string{"swap!", "str", "string?", "println", "u/twice"} {
+		if strings.HasPrefix(w, word) {
+			res = append(res, w)
+		}
+	}
+	return word, res
+}
+
+func TestCompletion(t *testing.T) {
+	cases := []struct {
+		title, keys, expected string
+	}{
+		{"one candidate", "(sw\t a)\r", "(swap! a)"},
+		{"common prefix", "(st\t)\r", "(str)"},
+		{"in the middle", "(pr x)" + left + left + left + "\t\r", "(println x)"},
+		{"qualified", "(u/\t 2)\r", "(u/twice 2)"},
+		{"none", "(zz\t)\r", "(zz)"},
+		{"indents at the start of a line", "(do\x1b\r\tx)\r", "(do\n  x)"},
+	}
+	for _, c := range cases {
+		ed := New(Options{Terminal: newFakeTerm(c.keys), Complete: words})
+		actual, err := ed.Readline("> ")
+		if err != nil {
+			t.Errorf("%s: %v", c.title, err)
+		} else if actual != c.expected {
+			t.Errorf("%s: expected %q, actual %q", c.title, c.expected, actual)
+		}
+	}
+	term := newFakeTerm("(str\t)\r")
+	actual, _ := New(Options{Terminal: term, Complete: words}).Readline("> ")
+	if actual != "(str)" {
+		t.Errorf("listing changed the input to %q", actual)
+	}
+	if !strings.Contains(term.out.String(), "\r\nstr      string?\r\n") {
+		t.Errorf("the candidates were not listed: %q", term.out.String())
+	}
+}
@@ -0,0 +1,10 @@
+package readline
+
+import (
+	"syscall"
+)
+
+const (
+	ioctlGetTermios = syscall.TCGETS
+	ioctlSetTermios = syscall.TCSETS
+)
@@ -0,0 +1,15 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+// +build !linux,!darwin,!dragonfly,!freebsd,!netbsd,!openbsd
+
+package readline
+
+// Raw mode is only supported on Unix; elsewhere an Editor reads whole
+// lines as they are typed.
+
+func makeRaw(fd int) (func() error, error) {
+	return nil, errNotTerminal
+}
+
+func width(fd int) int {
+	return 0
+}
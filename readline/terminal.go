@@ -0,0 +1,49 @@
+package readline
+
+import (
+	"errors"
+	"io"
+	"os"
+)
+
+// Terminal is what an Editor reads keys from and draws the line on. The
+// terminal of the process is Stdio; tests use a fake one.
+type Terminal interface {
+	io.Reader
+	io.Writer
+	// MakeRaw puts the terminal in raw mode, where keys are read one at
+	// a time without echo, and returns a function restoring the mode it
+	// was in. It fails if the input is not a terminal.
+	MakeRaw() (restore func() error, err error)
+	// Width returns the number of columns, or 0 if it is not known.
+	Width() int
+}
+
+var errNotTerminal = errors.New("readline: not a terminal")
+
+type stdio struct {
+	in  *os.File
+	out *os.File
+}
+
+// Stdio returns the terminal of the process: keys are read from
+// os.Stdin and the line is drawn on os.Stdout.
+func Stdio() Terminal {
+	return stdio{os.Stdin, os.Stdout}
+}
+
+func (t stdio) Read(p []byte) (int, error) {
+	return t.in.Read(p)
+}
+
+func (t stdio) Write(p []byte) (int, error) {
+	return t.out.Write(p)
+}
+
+func (t stdio) MakeRaw() (func() error, error) {
+	return makeRaw(int(t.in.Fd()))
+}
+
+func (t stdio) Width() int {
+	return width(int(t.out.Fd()))
+}
@@ -1,3 +1,3 @@
 #!/usr/bin/env bash
 
-go test ./lispgo
\ No newline at end of file
+go test -race ./...
\ No newline at end of file
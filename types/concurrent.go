@@ -0,0 +1,137 @@
+package types
+
+import (
+	"sync"
+	"time"
+)
+
+// Futures
+
+// Future is the result of a computation running in another goroutine.
+type Future struct {
+	done chan struct{}
+	val  Top
+	err  error
+	Meta Top
+}
+
+func NewFuture() *Future {
+	return &Future{done: make(chan struct{})}
+}
+
+func IsFuture(obj Top) bool {
+	_, ok := obj.(*Future)
+	return ok
+}
+
+// Resolve sets the result of the future and wakes up its waiters. It
+// must be called exactly once.
+func (f *Future) Resolve(val Top, err error) {
+	f.val, f.err = val, err
+	close(f.done)
+}
+
+func (f *Future) Realized() bool {
+	select {
+	case <-f.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Wait blocks until the future is resolved and returns its result, or
+// until stop is closed. A negative timeout waits forever; otherwise ok
+// is false if the future was not resolved in time, as it is if stop was
+// closed first.
+func (f *Future) Wait(timeout time.Duration, stop <-chan struct{}) (val Top, err error, ok bool) {
+	var expired <-chan time.Time
+	if timeout >= 0 {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		expired = t.C
+	}
+	select {
+	case <-f.done:
+		return f.val, f.err, true
+	case <-expired:
+		return nil, nil, false
+	case <-stop:
+		return nil, nil, false
+	}
+}
+
+// Channels
+
+// Chan is a channel between goroutines. Unlike a Go channel it can be
+// closed while senders are blocked on it: they give up and report
+// failure. Values buffered before the close can still be received, after
+// which receives return nil at once.
+type Chan struct {
+	C    chan Top
+	Done chan struct{}
+	once sync.Once
+	Meta Top
+}
+
+func NewChan(size int) *Chan {
+	return &Chan{C: make(chan Top, size), Done: make(chan struct{})}
+}
+
+func IsChan(obj Top) bool {
+	_, ok := obj.(*Chan)
+	return ok
+}
+
+func (c *Chan) Close() {
+	c.once.Do(func() { close(c.Done) })
+}
+
+func (c *Chan) Closed() bool {
+	select {
+	case <-c.Done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Send blocks until val is received or buffered and reports whether it
+// was, which it is not if the channel is or gets closed, or if stop is
+// closed first.
+func (c *Chan) Send(val Top, stop <-chan struct{}) bool {
+	if c.Closed() {
+		return false
+	}
+	select {
+	case c.C <- val:
+		return true
+	case <-c.Done:
+		return false
+	case <-stop:
+		return false
+	}
+}
+
+// Recv blocks until a value is available and returns it; ok is false if
+// the channel is closed and drained, or if stop is closed first.
+func (c *Chan) Recv(stop <-chan struct{}) (val Top, ok bool) {
+	select {
+	case val := <-c.C:
+		return val, true
+	case <-c.Done:
+		return c.Drain()
+	case <-stop:
+		return nil, false
+	}
+}
+
+// Drain returns a value left in the buffer of a closed channel, if any.
+func (c *Chan) Drain() (val Top, ok bool) {
+	select {
+	case val := <-c.C:
+		return val, true
+	default:
+		return nil, false
+	}
+}
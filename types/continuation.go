@@ -0,0 +1,88 @@
+package types
+
+import (
+	"sync/atomic"
+)
+
+// Continuations
+//
+// call/cc passes its function an escape-only continuation: calling it
+// makes call/cc return at once with the value passed, from however deep
+// inside the function. It cannot be re-entered once call/cc has
+// returned. The escape unwinds like an error, an Escape, which try* lets
+// through and dynamic-wind runs its after thunk for.
+
+// Continuation is the escape procedure made by CallCC.
+type Continuation struct {
+	k    *continuation
+	Meta Top
+}
+
+type continuation struct {
+	// done is set once call/cc has returned.
+	done int32
+}
+
+// Escape is the error that carries a value out to the call/cc that made
+// a continuation.
+type Escape struct {
+	k   *continuation
+	Val Top
+}
+
+func (e Escape) Error() string {
+	return "continuation called outside of its call/cc"
+}
+
+// CallCC calls f back from c with the continuation of the call.
+func CallCC(c Caller, f Top) (Top, error) {
+	k := &continuation{}
+	res, e := c.Apply(f, []Top{&Continuation{k: k}})
+	atomic.StoreInt32(&k.done, 1)
+	if esc, ok := e.(Escape); ok && esc.k == k {
+		return esc.Val, nil
+	}
+	return res, e
+}
+
+// DynamicWind calls thunk between before and after, all without
+// arguments and back from c, and calls after however thunk is left: by
+// returning, raising an error or escaping to a continuation.
+func DynamicWind(c Caller, before Top, thunk Top, after Top) (Top, error) {
+	if _, e := c.Apply(before, nil); e != nil {
+		return nil, e
+	}
+	res, e := c.Apply(thunk, nil)
+	if _, ae := c.Apply(after, nil); ae != nil {
+		return nil, ae
+	}
+	return res, e
+}
+
+// Call escapes to the call/cc of the continuation with the value a[0],
+// or nil.
+func (c *Continuation) Call(a []Top) (Top, error) {
+	if e := CheckArity("continuation", a, 0, 1); e != nil {
+		return nil, e
+	}
+	if atomic.LoadInt32(&c.k.done) != 0 {
+		return nil, NewErrorf(ValueError, "", "continuation called after its call/cc returned")
+	}
+	var val Top
+	if len(a) == 1 {
+		val = a[0]
+	}
+	return nil, Escape{c.k, val}
+}
+
+func (c *Continuation) GetMacro() bool {
+	return false
+}
+
+func (c *Continuation) GetMeta() Top {
+	return c.Meta
+}
+
+func (c *Continuation) WithMeta(m Top) Top {
+	return &Continuation{c.k, m}
+}
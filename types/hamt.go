@@ -0,0 +1,193 @@
+package types
+
+import (
+	"math/bits"
+)
+
+// Persistent hash maps
+//
+// A hash array mapped trie: each level of the tree uses the next 5 bits
+// of the key's hash to pick one of up to 32 entries, stored densely with
+// a bitmap of the ones present. An entry is a key/value pair or a
+// sub-trie. Keys whose hashes are equal in all 32 bits end up together
+// in a collision node at the bottom. Like the vectors, updates copy the
+// path to the changed entry only.
+
+const (
+	hamtBits = 5
+	hamtMask = 1<<hamtBits - 1
+	// hamtDepth is the shift at which the hash is used up.
+	hamtDepth = 35
+)
+
+type hamtEntry struct {
+	hash uint32
+	key  Top
+	val  Top
+	node *hamtNode // a sub-trie instead of key and val
+}
+
+type hamtNode struct {
+	bitmap  uint32
+	entries []hamtEntry // a collision node has no bitmap
+}
+
+type pmap struct {
+	count int
+	root  *hamtNode
+}
+
+var emptyPMap = &pmap{root: &hamtNode{}}
+
+func (n *hamtNode) index(bit uint32) int {
+	return bits.OnesCount32(n.bitmap & (bit - 1))
+}
+
+func (m *pmap) get(key Top) (Top, bool) {
+	hash := Hash(key)
+	n := m.root
+	for shift := uint(0); ; shift += hamtBits {
+		if shift >= hamtDepth {
+			for i := range n.entries {
+				if Eq(n.entries[i].key, key) {
+					return n.entries[i].val, true
+				}
+			}
+			return nil, false
+		}
+		bit := uint32(1) << ((hash >> shift) & hamtMask)
+		if n.bitmap&bit == 0 {
+			return nil, false
+		}
+		e := &n.entries[n.index(bit)]
+		if e.node == nil {
+			if e.hash == hash && Eq(e.key, key) {
+				return e.val, true
+			}
+			return nil, false
+		}
+		n = e.node
+	}
+}
+
+func (m *pmap) assoc(key Top, val Top) *pmap {
+	root, added := m.root.assoc(0, Hash(key), key, val)
+	count := m.count
+	if added {
+		count += 1
+	}
+	return &pmap{count, root}
+}
+
+func (m *pmap) dissoc(key Top) *pmap {
+	root, removed := m.root.dissoc(0, Hash(key), key)
+	if !removed {
+		return m
+	}
+	return &pmap{m.count - 1, root}
+}
+
+// each calls f for every entry until it returns false.
+func (m *pmap) each(f func(key Top, val Top) bool) {
+	m.root.each(f)
+}
+
+func (n *hamtNode) each(f func(key Top, val Top) bool) bool {
+	for _, e := range n.entries {
+		if e.node != nil {
+			if !e.node.each(f) {
+				return false
+			}
+		} else if !f(e.key, e.val) {
+			return false
+		}
+	}
+	return true
+}
+
+func (n *hamtNode) withEntry(i int, e hamtEntry) *hamtNode {
+	entries := make([]hamtEntry, len(n.entries))
+	copy(entries, n.entries)
+	entries[i] = e
+	return &hamtNode{n.bitmap, entries}
+}
+
+func (n *hamtNode) assoc(shift uint, hash uint32, key Top, val Top) (*hamtNode, bool) {
+	if shift >= hamtDepth {
+		for i, e := range n.entries {
+			if Eq(e.key, key) {
+				return n.withEntry(i, hamtEntry{hash, key, val, nil}), false
+			}
+		}
+		entries := make([]hamtEntry, len(n.entries), len(n.entries)+1)
+		copy(entries, n.entries)
+		return &hamtNode{0, append(entries, hamtEntry{hash, key, val, nil})}, true
+	}
+
+	bit := uint32(1) << ((hash >> shift) & hamtMask)
+	i := n.index(bit)
+	if n.bitmap&bit == 0 {
+		entries := make([]hamtEntry, len(n.entries)+1)
+		copy(entries, n.entries[:i])
+		entries[i] = hamtEntry{hash, key, val, nil}
+		copy(entries[i+1:], n.entries[i:])
+		return &hamtNode{n.bitmap | bit, entries}, true
+	}
+
+	e := n.entries[i]
+	if e.node != nil {
+		child, added := e.node.assoc(shift+hamtBits, hash, key, val)
+		return n.withEntry(i, hamtEntry{node: child}), added
+	}
+	if e.hash == hash && Eq(e.key, key) {
+		return n.withEntry(i, hamtEntry{hash, key, val, nil}), false
+	}
+	// Two keys share this slot: push both down into a sub-trie.
+	child, _ := (&hamtNode{}).assoc(shift+hamtBits, e.hash, e.key, e.val)
+	child, _ = child.assoc(shift+hamtBits, hash, key, val)
+	return n.withEntry(i, hamtEntry{node: child}), true
+}
+
+func (n *hamtNode) dissoc(shift uint, hash uint32, key Top) (*hamtNode, bool) {
+	if shift >= hamtDepth {
+		for i, e := range n.entries {
+			if Eq(e.key, key) {
+				return n.without(0, i), true
+			}
+		}
+		return n, false
+	}
+
+	bit := uint32(1) << ((hash >> shift) & hamtMask)
+	if n.bitmap&bit == 0 {
+		return n, false
+	}
+	i := n.index(bit)
+	e := n.entries[i]
+	if e.node == nil {
+		if e.hash == hash && Eq(e.key, key) {
+			return n.without(bit, i), true
+		}
+		return n, false
+	}
+	child, removed := e.node.dissoc(shift+hamtBits, hash, key)
+	if !removed {
+		return n, false
+	}
+	switch {
+	case len(child.entries) == 0:
+		return n.without(bit, i), true
+	case len(child.entries) == 1 && child.entries[0].node == nil:
+		// Pull a lone pair up so that the trie stays shallow.
+		return n.withEntry(i, child.entries[0]), true
+	default:
+		return n.withEntry(i, hamtEntry{node: child}), true
+	}
+}
+
+func (n *hamtNode) without(bit uint32, i int) *hamtNode {
+	entries := make([]hamtEntry, len(n.entries)-1)
+	copy(entries, n.entries[:i])
+	copy(entries[i:], n.entries[i+1:])
+	return &hamtNode{n.bitmap &^ bit, entries}
+}
@@ -0,0 +1,234 @@
+package types
+
+import (
+	"sync"
+)
+
+// Lazy sequences
+//
+// A LazySeq computes its elements only when they are needed, by calling
+// a function of no arguments the first time it is looked at. The
+// function returns a sequence, usually a Cons of the first element onto
+// another LazySeq for the rest, so a lazy sequence may be infinite. A
+// function that returns another LazySeq is not recursed into: Seq
+// realizes the chain in a loop, so skipping over many elements, as
+// filter does, takes no stack.
+//
+// First, Rest and Seq work on lists, vectors and lazy sequences alike.
+// GetSlice realizes a lazy sequence in full.
+
+// LazySeq is a sequence realized on demand by calling fn once.
+type LazySeq struct {
+	mu  sync.Mutex
+	fn  Top
+	val Top
+	err error
+}
+
+func NewLazySeq(fn Top) *LazySeq {
+	return &LazySeq{fn: fn}
+}
+
+// Cons is the sequence of First followed by the sequence More. cons
+// makes one when More is lazy, so that it stays lazy.
+type Cons struct {
+	First Top
+	More  Top
+}
+
+// IsLazy reports whether obj is a lazy sequence or a Cons onto one.
+func IsLazy(obj Top) bool {
+	switch obj.(type) {
+	case *LazySeq, Cons:
+		return true
+	}
+	return false
+}
+
+// Realized reports whether fn has been called.
+func (ls *LazySeq) Realized() bool {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	return ls.fn == nil
+}
+
+// step calls fn unless it has been called already, and returns what it
+// returned, which may be another lazy sequence.
+func (ls *LazySeq) step() (Top, error) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	if ls.fn != nil {
+		ls.val, ls.err = Apply(ls.fn, nil)
+		ls.fn = nil
+	}
+	return ls.val, ls.err
+}
+
+// Seq realizes ls as far as its first element and returns it as Seq
+// does.
+func (ls *LazySeq) Seq() (Top, error) {
+	x, e := ls.step()
+	for e == nil {
+		inner, ok := x.(*LazySeq)
+		if !ok {
+			break
+		}
+		x, e = inner.step()
+	}
+	if e != nil {
+		return nil, e
+	}
+	s, e := Seq(x)
+	if e == nil {
+		ls.mu.Lock()
+		ls.val = s
+		ls.mu.Unlock()
+	}
+	return s, e
+}
+
+// Seq returns the sequence x, realizing it if it is lazy, or nil if it
+// is empty. The result is a non-empty List, Vector or Cons.
+func Seq(x Top) (Top, error) {
+	switch s := x.(type) {
+	case nil:
+		return nil, nil
+	case List:
+		if len(s.Val) == 0 {
+			return nil, nil
+		}
+		return s, nil
+	case Vector:
+		if s.Len() == 0 {
+			return nil, nil
+		}
+		return s, nil
+	case Cons:
+		return s, nil
+	case *LazySeq:
+		return s.Seq()
+	default:
+		return nil, NewErrorf(TypeError, "", "expected a sequence, got %s", TypeName(x))
+	}
+}
+
+// First returns the first element of the sequence x, or nil if it is
+// empty.
+func First(x Top) (Top, error) {
+	s, e := Seq(x)
+	if e != nil {
+		return nil, e
+	}
+	switch s := s.(type) {
+	case List:
+		return s.Val[0], nil
+	case Vector:
+		return s.Nth(0), nil
+	case Cons:
+		return s.First, nil
+	}
+	return nil, nil
+}
+
+// Rest returns the sequence after the first element of x, which is the
+// empty list if there is none.
+func Rest(x Top) (Top, error) {
+	s, e := Seq(x)
+	if e != nil {
+		return nil, e
+	}
+	switch s := s.(type) {
+	case List:
+		return List{s.Val[1:], nil}, nil
+	case Vector:
+		return List{s.Slice()[1:], nil}, nil
+	case Cons:
+		if s.More == nil {
+			return List{}, nil
+		}
+		return s.More, nil
+	}
+	return List{}, nil
+}
+
+// Take returns the first n elements of the sequence x, realizing no more
+// of it than that and the one after, and reports whether there are more.
+func Take(x Top, n int) ([]Top, bool, error) {
+	var res []Top
+	for {
+		s, e := Seq(x)
+		if e != nil || s == nil {
+			return res, false, e
+		}
+		if len(res) == n {
+			return res, true, nil
+		}
+		first, _ := First(s)
+		res = append(res, first)
+		if x, e = Rest(s); e != nil {
+			return nil, false, e
+		}
+	}
+}
+
+// lazySlice realizes the lazy sequence x in full.
+func lazySlice(x Top) ([]Top, error) {
+	var res []Top
+	for {
+		s, e := Seq(x)
+		if e != nil {
+			return nil, e
+		}
+		switch s := s.(type) {
+		case nil:
+			if res == nil {
+				res = []Top{}
+			}
+			return res, nil
+		case Cons:
+			res = append(res, s.First)
+			x = s.More
+		default:
+			slc, _ := GetSlice(s)
+			return append(res, slc...), nil
+		}
+	}
+}
+
+// Delays
+
+// Delay is a value computed by calling fn the first time it is forced.
+// An error fn raises is kept and raised again by every Force.
+type Delay struct {
+	mu  sync.Mutex
+	fn  Top
+	val Top
+	err error
+}
+
+func NewDelay(fn Top) *Delay {
+	return &Delay{fn: fn}
+}
+
+func IsDelay(obj Top) bool {
+	_, ok := obj.(*Delay)
+	return ok
+}
+
+// Force computes the value of d unless it has been already, calling fn
+// back from c.
+func (d *Delay) Force(c Caller) (Top, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.fn != nil {
+		d.val, d.err = c.Apply(d.fn, nil)
+		d.fn = nil
+	}
+	return d.val, d.err
+}
+
+func (d *Delay) Realized() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.fn == nil
+}
@@ -0,0 +1,582 @@
+package types
+
+import (
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Numbers
+//
+// The numeric tower has four representations, from narrowest to widest:
+// int for fixnums, *big.Int for integers that do not fit in an int,
+// *big.Rat for exact fractions and float64 for inexact reals. Exact
+// results are always normalized to the narrowest representation that
+// holds them, so an integral value is never a *big.Int or *big.Rat
+// unless it has to be.
+
+func divisionByZero(op string) error {
+	return NewError(ArithmeticError, op, nil, "division by zero")
+}
+
+const (
+	rankInt = iota
+	rankBigInt
+	rankRat
+	rankFloat
+)
+
+func numRank(obj Top) (int, bool) {
+	switch obj.(type) {
+	case int:
+		return rankInt, true
+	case *big.Int:
+		return rankBigInt, true
+	case *big.Rat:
+		return rankRat, true
+	case float64:
+		return rankFloat, true
+	default:
+		return 0, false
+	}
+}
+
+func IsNumber(obj Top) bool {
+	_, ok := numRank(obj)
+	return ok
+}
+
+// IsInteger reports whether obj is an exact integer (int or *big.Int).
+func IsInteger(obj Top) bool {
+	r, ok := numRank(obj)
+	return ok && r <= rankBigInt
+}
+
+func IsExact(obj Top) bool {
+	r, ok := numRank(obj)
+	return ok && r != rankFloat
+}
+
+func IsFloat(obj Top) bool {
+	_, ok := obj.(float64)
+	return ok
+}
+
+// NormalizeBigInt returns i as an int when it fits, otherwise i itself.
+func NormalizeBigInt(i *big.Int) Top {
+	if i.IsInt64() {
+		v := i.Int64()
+		if int64(int(v)) == v {
+			return int(v)
+		}
+	}
+	return i
+}
+
+// NormalizeRat returns r as an integer when its denominator is 1.
+func NormalizeRat(r *big.Rat) Top {
+	if r.IsInt() {
+		return NormalizeBigInt(new(big.Int).Set(r.Num()))
+	}
+	return r
+}
+
+func toBigInt(obj Top) *big.Int {
+	switch n := obj.(type) {
+	case int:
+		return big.NewInt(int64(n))
+	case *big.Int:
+		return n
+	}
+	return nil
+}
+
+func toRat(obj Top) *big.Rat {
+	switch n := obj.(type) {
+	case int:
+		return new(big.Rat).SetInt64(int64(n))
+	case *big.Int:
+		return new(big.Rat).SetInt(n)
+	case *big.Rat:
+		return n
+	}
+	return nil
+}
+
+func ToFloat(obj Top) (float64, bool) {
+	switch n := obj.(type) {
+	case int:
+		return float64(n), true
+	case *big.Int:
+		f, _ := new(big.Float).SetInt(n).Float64()
+		return f, true
+	case *big.Rat:
+		f, _ := n.Float64()
+		return f, true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+// coerce returns the rank both a and b must be promoted to.
+func coerce(op string, a Top, b Top) (int, error) {
+	ra, ok := numRank(a)
+	if !ok {
+		return 0, WrongType(op, "number", a)
+	}
+	rb, ok := numRank(b)
+	if !ok {
+		return 0, WrongType(op, "number", b)
+	}
+	if ra > rb {
+		return ra, nil
+	}
+	return rb, nil
+}
+
+func NumAdd(a Top, b Top) (Top, error) {
+	rank, e := coerce("+", a, b)
+	if e != nil {
+		return nil, e
+	}
+	switch rank {
+	case rankInt:
+		x, y := a.(int), b.(int)
+		z := x + y
+		if (z > x) == (y > 0) {
+			return z, nil
+		}
+		return NormalizeBigInt(new(big.Int).Add(toBigInt(x), toBigInt(y))), nil
+	case rankBigInt:
+		return NormalizeBigInt(new(big.Int).Add(toBigInt(a), toBigInt(b))), nil
+	case rankRat:
+		return NormalizeRat(new(big.Rat).Add(toRat(a), toRat(b))), nil
+	default:
+		x, _ := ToFloat(a)
+		y, _ := ToFloat(b)
+		return x + y, nil
+	}
+}
+
+func NumSub(a Top, b Top) (Top, error) {
+	rank, e := coerce("-", a, b)
+	if e != nil {
+		return nil, e
+	}
+	switch rank {
+	case rankInt:
+		x, y := a.(int), b.(int)
+		z := x - y
+		if (z < x) == (y > 0) {
+			return z, nil
+		}
+		return NormalizeBigInt(new(big.Int).Sub(toBigInt(x), toBigInt(y))), nil
+	case rankBigInt:
+		return NormalizeBigInt(new(big.Int).Sub(toBigInt(a), toBigInt(b))), nil
+	case rankRat:
+		return NormalizeRat(new(big.Rat).Sub(toRat(a), toRat(b))), nil
+	default:
+		x, _ := ToFloat(a)
+		y, _ := ToFloat(b)
+		return x - y, nil
+	}
+}
+
+func NumMul(a Top, b Top) (Top, error) {
+	rank, e := coerce("*", a, b)
+	if e != nil {
+		return nil, e
+	}
+	switch rank {
+	case rankInt:
+		x, y := a.(int), b.(int)
+		if x == 0 || y == 0 {
+			return 0, nil
+		}
+		z := x * y
+		if z/y == x && !(x == -1 && y == math.MinInt) && !(y == -1 && x == math.MinInt) {
+			return z, nil
+		}
+		return NormalizeBigInt(new(big.Int).Mul(toBigInt(x), toBigInt(y))), nil
+	case rankBigInt:
+		return NormalizeBigInt(new(big.Int).Mul(toBigInt(a), toBigInt(b))), nil
+	case rankRat:
+		return NormalizeRat(new(big.Rat).Mul(toRat(a), toRat(b))), nil
+	default:
+		x, _ := ToFloat(a)
+		y, _ := ToFloat(b)
+		return x * y, nil
+	}
+}
+
+// NumDiv divides exactly: two exact operands give an exact, possibly
+// rational, result.
+func NumDiv(a Top, b Top) (Top, error) {
+	rank, e := coerce("/", a, b)
+	if e != nil {
+		return nil, e
+	}
+	if rank == rankFloat {
+		x, _ := ToFloat(a)
+		y, _ := ToFloat(b)
+		return x / y, nil
+	}
+	y := toRat(b)
+	if y.Sign() == 0 {
+		return nil, divisionByZero("/")
+	}
+	return NormalizeRat(new(big.Rat).Quo(toRat(a), y)), nil
+}
+
+// NumCompare returns -1, 0 or 1 as a is less than, equal to or greater
+// than b.
+func NumCompare(a Top, b Top) (int, error) {
+	rank, e := coerce("compare", a, b)
+	if e != nil {
+		return 0, e
+	}
+	switch rank {
+	case rankInt:
+		x, y := a.(int), b.(int)
+		if x < y {
+			return -1, nil
+		} else if x > y {
+			return 1, nil
+		}
+		return 0, nil
+	case rankBigInt:
+		return toBigInt(a).Cmp(toBigInt(b)), nil
+	case rankRat:
+		return toRat(a).Cmp(toRat(b)), nil
+	default:
+		x, _ := ToFloat(a)
+		y, _ := ToFloat(b)
+		if x < y {
+			return -1, nil
+		} else if x > y {
+			return 1, nil
+		}
+		return 0, nil
+	}
+}
+
+func NumNegate(a Top) (Top, error) {
+	return NumSub(0, a)
+}
+
+func NumAbs(a Top) (Top, error) {
+	if !IsNumber(a) {
+		return nil, WrongType("abs", "number", a)
+	}
+	c, _ := NumCompare(a, 0)
+	if c < 0 || (c == 0 && IsFloat(a)) {
+		if f, ok := a.(float64); ok {
+			return math.Abs(f), nil
+		}
+		return NumNegate(a)
+	}
+	return a, nil
+}
+
+// integerOp applies an integer division operator to two integers. Float
+// operands are accepted when they hold integral values, in which case
+// the result is a float too.
+func integerOp(op string, a Top, b Top,
+	fixnum func(x, y int) int,
+	bignum func(x, y *big.Int) *big.Int,
+	float func(x, y float64) float64) (Top, error) {
+	rank, e := coerce(op, a, b)
+	if e != nil {
+		return nil, e
+	}
+	if rank == rankFloat {
+		x, _ := ToFloat(a)
+		y, _ := ToFloat(b)
+		if x != math.Trunc(x) {
+			return nil, WrongType(op, "integer", a)
+		}
+		if y != math.Trunc(y) {
+			return nil, WrongType(op, "integer", b)
+		}
+		if y == 0 {
+			return nil, divisionByZero(op)
+		}
+		return float(x, y), nil
+	}
+	if rank == rankRat {
+		if !IsInteger(a) {
+			return nil, WrongType(op, "integer", a)
+		}
+		return nil, WrongType(op, "integer", b)
+	}
+	if c, _ := NumCompare(b, 0); c == 0 {
+		return nil, divisionByZero(op)
+	}
+	if rank == rankInt && !(a.(int) == math.MinInt && b.(int) == -1) {
+		return fixnum(a.(int), b.(int)), nil
+	}
+	return NormalizeBigInt(bignum(toBigInt(a), toBigInt(b))), nil
+}
+
+// NumQuotient truncates towards zero.
+func NumQuotient(a Top, b Top) (Top, error) {
+	return integerOp("quotient", a, b,
+		func(x, y int) int { return x / y },
+		func(x, y *big.Int) *big.Int { return new(big.Int).Quo(x, y) },
+		func(x, y float64) float64 { return math.Trunc(x / y) })
+}
+
+// NumRemainder has the sign of the dividend.
+func NumRemainder(a Top, b Top) (Top, error) {
+	return integerOp("remainder", a, b,
+		func(x, y int) int { return x % y },
+		func(x, y *big.Int) *big.Int { return new(big.Int).Rem(x, y) },
+		math.Mod)
+}
+
+// NumModulo has the sign of the divisor.
+func NumModulo(a Top, b Top) (Top, error) {
+	return integerOp("modulo", a, b,
+		func(x, y int) int {
+			m := x % y
+			if m != 0 && (m < 0) != (y < 0) {
+				m += y
+			}
+			return m
+		},
+		func(x, y *big.Int) *big.Int {
+			m := new(big.Int).Rem(x, y)
+			if m.Sign() != 0 && (m.Sign() < 0) != (y.Sign() < 0) {
+				m.Add(m, y)
+			}
+			return m
+		},
+		func(x, y float64) float64 {
+			m := math.Mod(x, y)
+			if m != 0 && (m < 0) != (y < 0) {
+				m += y
+			}
+			return m
+		})
+}
+
+// maxExptBits bounds the size of exact powers so that a typo such as
+// (expt 10 10000000000) fails instead of exhausting memory.
+const maxExptBits = 1 << 26
+
+// NumExpt raises base to power. An exact base with an exact integer
+// power gives an exact result; anything else is computed in floating
+// point.
+func NumExpt(base Top, power Top) (Top, error) {
+	if _, e := coerce("expt", base, power); e != nil {
+		return nil, e
+	}
+	if IsExact(base) && IsInteger(power) {
+		p := toBigInt(power)
+		neg := p.Sign() < 0
+		if neg {
+			p = new(big.Int).Neg(p)
+		}
+		b := toRat(base)
+		if neg && b.Sign() == 0 {
+			return nil, divisionByZero("expt")
+		}
+		bits := b.Num().BitLen() + b.Denom().BitLen()
+		if bits > 2 && (!p.IsInt64() || p.Int64() > maxExptBits/int64(bits)) {
+			return nil, NewError(ValueError, "expt", nil, "result too large")
+		}
+		num := new(big.Int).Exp(b.Num(), p, nil)
+		den := new(big.Int).Exp(b.Denom(), p, nil)
+		if neg {
+			num, den = den, num
+		}
+		return NormalizeRat(new(big.Rat).SetFrac(num, den)), nil
+	}
+	x, _ := ToFloat(base)
+	y, _ := ToFloat(power)
+	return math.Pow(x, y), nil
+}
+
+// NumSqrt returns an exact root for exact perfect squares and a float
+// otherwise.
+func NumSqrt(a Top) (Top, error) {
+	if !IsNumber(a) {
+		return nil, WrongType("sqrt", "number", a)
+	}
+	if c, _ := NumCompare(a, 0); c < 0 {
+		return nil, NewError(ValueError, "sqrt", nil, "negative argument")
+	}
+	if IsExact(a) {
+		r := toRat(a)
+		num := new(big.Int).Sqrt(r.Num())
+		den := new(big.Int).Sqrt(r.Denom())
+		root := new(big.Rat).SetFrac(num, den)
+		if new(big.Rat).Mul(root, root).Cmp(r) == 0 {
+			return NormalizeRat(root), nil
+		}
+	}
+	f, _ := ToFloat(a)
+	return math.Sqrt(f), nil
+}
+
+// rounding applies a rounding mode: exact numbers stay exact, floats
+// stay floats.
+func rounding(op string, a Top, float func(float64) float64,
+	rat func(num, den *big.Int) *big.Int) (Top, error) {
+	switch n := a.(type) {
+	case int, *big.Int:
+		return n, nil
+	case *big.Rat:
+		return NormalizeBigInt(rat(n.Num(), n.Denom())), nil
+	case float64:
+		return float(n), nil
+	default:
+		return nil, WrongType(op, "number", a)
+	}
+}
+
+// floorDiv relies on big.Int.Div being Euclidean, which for the positive
+// denominators of a big.Rat is floor division.
+func floorDiv(num, den *big.Int) *big.Int {
+	return new(big.Int).Div(num, den)
+}
+
+func NumFloor(a Top) (Top, error) {
+	return rounding("floor", a, math.Floor, floorDiv)
+}
+
+func NumCeiling(a Top) (Top, error) {
+	return rounding("ceiling", a, math.Ceil, func(num, den *big.Int) *big.Int {
+		return new(big.Int).Neg(floorDiv(new(big.Int).Neg(num), den))
+	})
+}
+
+func NumTruncate(a Top) (Top, error) {
+	return rounding("truncate", a, math.Trunc, func(num, den *big.Int) *big.Int {
+		return new(big.Int).Quo(num, den)
+	})
+}
+
+// NumRound rounds to the nearest integer, ties to even.
+func NumRound(a Top) (Top, error) {
+	return rounding("round", a, math.RoundToEven, func(num, den *big.Int) *big.Int {
+		twice := new(big.Int).Mul(num, big.NewInt(2))
+		twice.Add(twice, den)
+		den2 := new(big.Int).Mul(den, big.NewInt(2))
+		r := floorDiv(twice, den2)
+		if new(big.Int).Mod(twice, den2).Sign() == 0 && r.Bit(0) == 1 {
+			r.Sub(r, big.NewInt(1))
+		}
+		return r
+	})
+}
+
+func ToInexact(a Top) (Top, error) {
+	f, ok := ToFloat(a)
+	if !ok {
+		return nil, WrongType("exact->inexact", "number", a)
+	}
+	return f, nil
+}
+
+func ToExact(a Top) (Top, error) {
+	f, ok := a.(float64)
+	if !ok {
+		if IsNumber(a) {
+			return a, nil
+		}
+		return nil, WrongType("inexact->exact", "number", a)
+	}
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		return nil, NewError(ValueError, "inexact->exact", nil, "no exact representation of "+FormatFloat(f))
+	}
+	return NormalizeRat(new(big.Rat).SetFloat64(f)), nil
+}
+
+// ParseNumber parses a numeric literal: an integer, a fraction such as
+// 3/4, or a float such as 2.0, -3.14e159 or +inf.0. ok is false when
+// token does not look like a number at all.
+func ParseNumber(token string) (n Top, ok bool, err error) {
+	switch token {
+	case "+inf.0":
+		return math.Inf(1), true, nil
+	case "-inf.0":
+		return math.Inf(-1), true, nil
+	case "+nan.0", "-nan.0":
+		return math.NaN(), true, nil
+	}
+	digits := strings.TrimLeft(token, "+-")
+	if len(token)-len(digits) > 1 || digits == "" {
+		return nil, false, nil
+	}
+	if !(digits[0] >= '0' && digits[0] <= '9') &&
+		!(digits[0] == '.' && len(digits) > 1 && digits[1] >= '0' && digits[1] <= '9') {
+		return nil, false, nil
+	}
+	if isDigits(digits) {
+		if i, e := strconv.Atoi(token); e == nil {
+			return i, true, nil
+		}
+		i, ok := new(big.Int).SetString(token, 10)
+		if !ok {
+			return nil, true, NewError(ReadError, "", nil, "number parse error: "+token)
+		}
+		return NormalizeBigInt(i), true, nil
+	}
+	if slash := strings.IndexByte(digits, '/'); slash > 0 {
+		if !isDigits(digits[:slash]) || !isDigits(digits[slash+1:]) {
+			return nil, false, nil
+		}
+		r, ok := new(big.Rat).SetString(token)
+		if !ok {
+			return nil, true, NewError(ReadError, "", nil, "division by zero in "+token)
+		}
+		return NormalizeRat(r), true, nil
+	}
+	f, e := strconv.ParseFloat(token, 64)
+	if e != nil {
+		if ne, isNumErr := e.(*strconv.NumError); isNumErr && ne.Err == strconv.ErrRange {
+			return f, true, nil
+		}
+		return nil, false, nil
+	}
+	return f, true, nil
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// FormatFloat prints f so that it reads back as a float: integral values
+// keep a trailing ".0" and very large or small magnitudes use an
+// exponent without a '+' sign, e.g. -3.14e159.
+func FormatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+inf.0"
+	case math.IsInf(f, -1):
+		return "-inf.0"
+	case math.IsNaN(f):
+		return "+nan.0"
+	}
+	abs := math.Abs(f)
+	if abs != 0 && (abs >= 1e21 || abs < 1e-7) {
+		s := strconv.FormatFloat(f, 'e', -1, 64)
+		return strings.Replace(s, "e+", "e", 1)
+	}
+	s := strconv.FormatFloat(f, 'f', -1, 64)
+	if !strings.ContainsAny(s, ".") {
+		s += ".0"
+	}
+	return s
+}
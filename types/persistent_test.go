@@ -0,0 +1,276 @@
+package types
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+func TestVectorConjAndNth(t *testing.T) {
+	for _, n := range []int{0, 1, 32, 33, 64, 1056, 1057, 40000} {
+		v := NewVector()
+		for i := 0; i < n; i++ {
+			v = v.Conj(i)
+		}
+		built := make([]Top, n)
+		for i := range built {
+			built[i] = i
+		}
+		for _, vec := range []Vector{v, NewVector(built...)} {
+			if vec.Len() != n {
+				t.Fatalf("%d: expected length %d, actual %d", n, n, vec.Len())
+			}
+			for i := 0; i < n; i++ {
+				if vec.Nth(i) != i {
+					t.Fatalf("%d: element %d is %v", n, i, vec.Nth(i))
+				}
+			}
+			if s := vec.Slice(); len(s) != n || (n > 0 && s[n-1] != n-1) {
+				t.Fatalf("%d: bad slice of length %d", n, len(s))
+			}
+		}
+	}
+}
+
+func TestVectorIsPersistent(t *testing.T) {
+	v := NewVector()
+	versions := []Vector{v}
+	for i := 0; i < 2000; i++ {
+		v = v.Conj(i)
+		versions = append(versions, v)
+	}
+	changed := v
+	for i := 0; i < v.Len(); i += 7 {
+		changed = changed.Assoc(i, -i)
+	}
+	// Two conjs on the same vector must not see each other.
+	a, b := versions[100].Conj("a"), versions[100].Conj("b")
+	if a.Nth(100) != "a" || b.Nth(100) != "b" {
+		t.Errorf("conj shares the tail: %v %v", a.Nth(100), b.Nth(100))
+	}
+	for n, old := range versions {
+		if old.Len() != n {
+			t.Fatalf("version %d has length %d", n, old.Len())
+		}
+		for i := 0; i < n; i++ {
+			if old.Nth(i) != i {
+				t.Fatalf("version %d: element %d changed to %v", n, i, old.Nth(i))
+			}
+		}
+	}
+	for i := 0; i < changed.Len(); i++ {
+		expected := i
+		if i%7 == 0 {
+			expected = -i
+		}
+		if changed.Nth(i) != expected {
+			t.Fatalf("element %d: expected %v, actual %v", i, expected, changed.Nth(i))
+		}
+	}
+}
+
+func TestHashMapAssocDissoc(t *testing.T) {
+	const n = 5000
+	m := HashMap{}
+	versions := []HashMap{m}
+	for i := 0; i < n; i++ {
+		m = m.Assoc(fmt.Sprint(i), i)
+		versions = append(versions, m)
+	}
+	if m.Len() != n {
+		t.Fatalf("expected %d entries, actual %d", n, m.Len())
+	}
+	if m.Assoc("7", "seven").Len() != n {
+		t.Error("replacing a value changed the count")
+	}
+	for i := 0; i < n; i++ {
+		if val, ok := m.Get(fmt.Sprint(i)); !ok || val != i {
+			t.Fatalf("key %d: expected %d, actual %v, %v", i, i, val, ok)
+		}
+	}
+	if _, ok := m.Get("missing"); ok {
+		t.Error("found a missing key")
+	}
+	seen := 0
+	m.Each(func(k Top, v Top) bool {
+		seen++
+		return true
+	})
+	if seen != n {
+		t.Errorf("Each visited %d entries, expected %d", seen, n)
+	}
+
+	for i := 0; i < n; i += 2 {
+		m = m.Dissoc(fmt.Sprint(i))
+	}
+	if m.Len() != n/2 {
+		t.Fatalf("expected %d entries after dissoc, actual %d", n/2, m.Len())
+	}
+	for i := 0; i < n; i++ {
+		_, ok := m.Get(fmt.Sprint(i))
+		if ok != (i%2 == 1) {
+			t.Fatalf("key %d: present = %v", i, ok)
+		}
+	}
+	if m.Dissoc("missing").Len() != m.Len() {
+		t.Error("dissoc of a missing key changed the count")
+	}
+	old := versions[n/2]
+	for i := 0; i < n/2; i++ {
+		if val, ok := old.Get(fmt.Sprint(i)); !ok || val != i {
+			t.Fatalf("old version lost key %d", i)
+		}
+	}
+	if _, ok := old.Get(fmt.Sprint(n / 2)); ok {
+		t.Error("old version sees a later key")
+	}
+}
+
+func TestHashAgreesWithEq(t *testing.T) {
+	big1, _, _ := ParseNumber("123456789012345678901234567890")
+	big2, _, _ := ParseNumber("123456789012345678901234567890")
+	kw, _ := NewKeyword("a")
+	g := NewGensym("x")
+	pairs := [][2]Top{
+		{1, 1},
+		{math.Copysign(0, -1), 0.0},
+		{"a", "a"},
+		{kw, kw},
+		{Symbol{"x"}, Symbol{"x"}},
+		{g, g},
+		{big1, big2},
+		{NewList(1, "a"), NewVector(1, "a")},
+		{HashMap{}.Assoc(1, 2).Assoc("b", 3), HashMap{}.Assoc("b", 3).Assoc(1, 2)},
+		{NewVector(NewList(1)), NewList(NewVector(1))},
+	}
+	for _, p := range pairs {
+		if !Eq(p[0], p[1]) {
+			t.Errorf("%v and %v are not Eq", p[0], p[1])
+		} else if Hash(p[0]) != Hash(p[1]) {
+			t.Errorf("%v and %v are Eq but hash differently", p[0], p[1])
+		}
+	}
+	if Eq(g, Symbol{"x"}) || Eq(g, NewGensym("x")) {
+		t.Error("a gensym is Eq to another symbol of the same name")
+	}
+}
+
+func TestArbitraryKeys(t *testing.T) {
+	keys := []Top{nil, true, 1, 2.5, "s", Symbol{"s"}, NewVector(1, 2), HashMap{}.Assoc("k", 1), NewAtom(0)}
+	m := HashMap{}
+	for i, k := range keys {
+		m = m.Assoc(k, i)
+	}
+	if m.Len() != len(keys) {
+		t.Fatalf("expected %d entries, actual %d", len(keys), m.Len())
+	}
+	for i, k := range keys {
+		if val, ok := m.Get(k); !ok || val != i {
+			t.Errorf("key %v: expected %d, actual %v, %v", k, i, val, ok)
+		}
+	}
+	if val, _ := m.Get(NewList(1, 2)); val != 6 {
+		t.Errorf("a list should find the entry of an equal vector, got %v", val)
+	}
+	if _, ok := m.Get(NewAtom(0)); ok {
+		t.Error("a different atom found an entry")
+	}
+}
+
+func TestHashCollisions(t *testing.T) {
+	// Drive the nodes directly with a fixed hash so that every key
+	// collides.
+	const hash = 0xdeadbeef
+	n := &hamtNode{}
+	for i := 0; i < 3; i++ {
+		n, _ = n.assoc(0, hash, i, i*10)
+	}
+	m := &pmap{3, n}
+	found := 0
+	m.each(func(k Top, v Top) bool {
+		if v != k.(int)*10 {
+			t.Errorf("key %v has value %v", k, v)
+		}
+		found++
+		return true
+	})
+	if found != 3 {
+		t.Fatalf("expected 3 colliding entries, found %d", found)
+	}
+	n, removed := n.dissoc(0, hash, 1)
+	if !removed {
+		t.Fatal("colliding key was not removed")
+	}
+	n, added := n.assoc(0, hash, 2, "two")
+	if added {
+		t.Error("replacing a colliding key added an entry")
+	}
+	m = &pmap{2, n}
+	vals := map[Top]Top{}
+	m.each(func(k Top, v Top) bool {
+		vals[k] = v
+		return true
+	})
+	if len(vals) != 2 || vals[0] != 0 || vals[2] != "two" {
+		t.Errorf("unexpected entries %v", vals)
+	}
+}
+
+// The benchmarks compare building a collection one element at a time with
+// the persistent structures against copying, which is what conj and assoc
+// did before.
+
+const benchSize = 1000
+
+func BenchmarkVectorConj(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		v := NewVector()
+		for j := 0; j < benchSize; j++ {
+			v = v.Conj(j)
+		}
+	}
+}
+
+func BenchmarkVectorConjCopying(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		var v []Top
+		for j := 0; j < benchSize; j++ {
+			next := make([]Top, len(v), len(v)+1)
+			copy(next, v)
+			v = append(next, j)
+		}
+	}
+}
+
+func BenchmarkHashMapAssoc(b *testing.B) {
+	keys := make([]string, benchSize)
+	for j := range keys {
+		keys[j] = fmt.Sprint(j)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		m := HashMap{}
+		for j, k := range keys {
+			m = m.Assoc(k, j)
+		}
+	}
+}
+
+func BenchmarkHashMapAssocCopying(b *testing.B) {
+	keys := make([]string, benchSize)
+	for j := range keys {
+		keys[j] = fmt.Sprint(j)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		m := map[string]Top{}
+		for j, k := range keys {
+			next := make(map[string]Top, len(m)+1)
+			for k, v := range m {
+				next[k] = v
+			}
+			next[k] = j
+			m = next
+		}
+	}
+}
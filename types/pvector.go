@@ -0,0 +1,151 @@
+package types
+
+// Persistent vectors
+//
+// A bit-partitioned vector trie as in Clojure: the elements live in the
+// leaves of a tree with 32-way branching, and the last (up to) 32 of them
+// in a separate tail so that appending is cheap. Updates copy only the
+// path from the root to the changed leaf, so they take O(log32 n) and
+// every older version stays valid.
+
+const (
+	vecBits  = 5
+	vecWidth = 1 << vecBits
+	vecMask  = vecWidth - 1
+)
+
+type vecNode struct {
+	nodes []*vecNode // inner nodes
+	vals  []Top      // leaves
+}
+
+type pvector struct {
+	count int
+	shift uint
+	root  *vecNode
+	tail  []Top
+}
+
+var emptyVecNode = &vecNode{}
+
+func newPVector(a []Top) *pvector {
+	v := &pvector{shift: vecBits, root: emptyVecNode}
+	// Fill whole leaves at once, then the tail.
+	n := 0
+	for len(a)-n > vecWidth {
+		leaf := make([]Top, vecWidth)
+		copy(leaf, a[n:])
+		n += vecWidth
+		v = v.pushLeaf(leaf, n)
+	}
+	v.count = len(a)
+	v.tail = append([]Top(nil), a[n:]...)
+	return v
+}
+
+func (v *pvector) tailOffset() int {
+	if v.count < vecWidth {
+		return 0
+	}
+	return ((v.count - 1) >> vecBits) << vecBits
+}
+
+func (v *pvector) leafFor(i int) []Top {
+	if i >= v.tailOffset() {
+		return v.tail
+	}
+	node := v.root
+	for level := v.shift; level > 0; level -= vecBits {
+		node = node.nodes[(i>>level)&vecMask]
+	}
+	return node.vals
+}
+
+func (v *pvector) nth(i int) Top {
+	return v.leafFor(i)[i&vecMask]
+}
+
+func (v *pvector) conj(val Top) *pvector {
+	if v.count-v.tailOffset() < vecWidth {
+		tail := make([]Top, len(v.tail)+1)
+		copy(tail, v.tail)
+		tail[len(v.tail)] = val
+		return &pvector{v.count + 1, v.shift, v.root, tail}
+	}
+	res := v.pushLeaf(v.tail, v.count)
+	res.count = v.count + 1
+	res.tail = []Top{val}
+	return res
+}
+
+// pushLeaf moves a full leaf into the tree, for a vector whose first
+// count elements are in the tree and the leaf. The caller sets the count
+// and tail of the result.
+func (v *pvector) pushLeaf(leaf []Top, count int) *pvector {
+	node := &vecNode{vals: leaf}
+	if (count >> vecBits) > (1 << v.shift) {
+		// The root is full: grow the tree by a level.
+		root := &vecNode{nodes: []*vecNode{v.root, newVecPath(v.shift, node)}}
+		return &pvector{shift: v.shift + vecBits, root: root}
+	}
+	return &pvector{shift: v.shift, root: pushVecLeaf(count, v.shift, v.root, node)}
+}
+
+func newVecPath(level uint, node *vecNode) *vecNode {
+	if level == 0 {
+		return node
+	}
+	return &vecNode{nodes: []*vecNode{newVecPath(level-vecBits, node)}}
+}
+
+func pushVecLeaf(count int, level uint, parent *vecNode, leaf *vecNode) *vecNode {
+	i := ((count - 1) >> level) & vecMask
+	nodes := make([]*vecNode, len(parent.nodes), len(parent.nodes)+1)
+	copy(nodes, parent.nodes)
+	var child *vecNode
+	if level == vecBits {
+		child = leaf
+	} else if i < len(parent.nodes) {
+		child = pushVecLeaf(count, level-vecBits, parent.nodes[i], leaf)
+	} else {
+		child = newVecPath(level-vecBits, leaf)
+	}
+	if i < len(nodes) {
+		nodes[i] = child
+	} else {
+		nodes = append(nodes, child)
+	}
+	return &vecNode{nodes: nodes}
+}
+
+func (v *pvector) assoc(i int, val Top) *pvector {
+	if i >= v.tailOffset() {
+		tail := make([]Top, len(v.tail))
+		copy(tail, v.tail)
+		tail[i&vecMask] = val
+		return &pvector{v.count, v.shift, v.root, tail}
+	}
+	return &pvector{v.count, v.shift, assocVec(v.shift, v.root, i, val), v.tail}
+}
+
+func assocVec(level uint, node *vecNode, i int, val Top) *vecNode {
+	if level == 0 {
+		vals := make([]Top, len(node.vals))
+		copy(vals, node.vals)
+		vals[i&vecMask] = val
+		return &vecNode{vals: vals}
+	}
+	nodes := make([]*vecNode, len(node.nodes))
+	copy(nodes, node.nodes)
+	j := (i >> level) & vecMask
+	nodes[j] = assocVec(level-vecBits, node.nodes[j], i, val)
+	return &vecNode{nodes: nodes}
+}
+
+// appendTo appends the elements to a, leaf by leaf.
+func (v *pvector) appendTo(a []Top) []Top {
+	for i := 0; i < v.count; i += vecWidth {
+		a = append(a, v.leafFor(i)...)
+	}
+	return a
+}
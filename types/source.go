@@ -0,0 +1,146 @@
+package types
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Source positions
+//
+// The reader records where every list, vector and hash-map starts in the
+// form's Meta as {:file "f.lisp" :line 3 :column 5}, the same way
+// Clojure does. Errors pick those positions up as they unwind so that
+// uncaught errors can print a Lisp-level backtrace.
+
+type SourcePos struct {
+	File   string
+	Line   int
+	Column int
+}
+
+func (p SourcePos) String() string {
+	file := p.File
+	if file == "" {
+		file = "<string>"
+	}
+	return fmt.Sprintf("%s:%d:%d", file, p.Line, p.Column)
+}
+
+// PosMeta is the Meta value the reader attaches to a form read at p.
+func PosMeta(p SourcePos) Top {
+	return HashMap{}.
+		Assoc(errorKey("file"), p.File).
+		Assoc(errorKey("line"), p.Line).
+		Assoc(errorKey("column"), p.Column)
+}
+
+// FormPos returns the position a form was read at, if it is known.
+func FormPos(form Top) (SourcePos, bool) {
+	var meta Top
+	switch f := form.(type) {
+	case List:
+		meta = f.Meta
+	case Vector:
+		meta = f.Meta
+	case HashMap:
+		meta = f.Meta
+	default:
+		return SourcePos{}, false
+	}
+	hm, ok := meta.(HashMap)
+	if !ok {
+		return SourcePos{}, false
+	}
+	fileVal, _ := hm.Get(errorKey("file"))
+	lineVal, _ := hm.Get(errorKey("line"))
+	colVal, _ := hm.Get(errorKey("column"))
+	file, ok1 := fileVal.(string)
+	line, ok2 := lineVal.(int)
+	col, ok3 := colVal.(int)
+	if !ok1 || !ok2 || !ok3 {
+		return SourcePos{}, false
+	}
+	return SourcePos{file, line, col}, true
+}
+
+// Frame is one entry of an error's backtrace: the function the error
+// unwound through and where in its body it was at the time.
+type Frame struct {
+	Name string
+	Pos  *SourcePos
+}
+
+func (f Frame) String() string {
+	name := f.Name
+	if name == "" {
+		name = "<lambda>"
+	}
+	if f.Pos == nil {
+		return name
+	}
+	return name + " (" + f.Pos.String() + ")"
+}
+
+// Locate records where an error happened: the position of form, unless
+// a position inside the current function is already known.
+func Locate(e error, form Top) error {
+	lge, ok := e.(LGError)
+	if !ok || lge.Pos != nil {
+		return e
+	}
+	if pos, ok := FormPos(form); ok {
+		lge.Pos = &pos
+	}
+	return lge
+}
+
+// MaxTrace is how many frames an error's backtrace keeps. Beyond that,
+// as when unwinding out of a runaway recursion, frames are only counted.
+const MaxTrace = 100
+
+// PushFrame records that an error unwound out of the named function,
+// which was called by callForm. The call site becomes the position at
+// which the error happened in the caller.
+func PushFrame(e error, name string, callForm Top) error {
+	lge, ok := e.(LGError)
+	if !ok {
+		return e
+	}
+	if len(lge.Trace) < MaxTrace {
+		lge.Trace = append(lge.Trace[:len(lge.Trace):len(lge.Trace)], Frame{name, lge.Pos})
+	} else {
+		lge.Omitted += 1
+	}
+	lge.Pos = nil
+	if pos, ok := FormPos(callForm); ok {
+		lge.Pos = &pos
+	}
+	return lge
+}
+
+// Backtrace returns the frames an error unwound through, innermost
+// first, ending with the top level.
+func Backtrace(e error) []Frame {
+	lge, ok := e.(LGError)
+	if !ok {
+		return nil
+	}
+	if len(lge.Trace) == 0 && lge.Pos == nil {
+		return nil
+	}
+	return append(lge.Trace[:len(lge.Trace):len(lge.Trace)], Frame{"<toplevel>", lge.Pos})
+}
+
+// FormatBacktrace renders Backtrace(e) one "  at name (file:line:col)"
+// line per frame, noting how many were omitted before the top level.
+func FormatBacktrace(e error) string {
+	var b strings.Builder
+	frames := Backtrace(e)
+	for i, f := range frames {
+		if lge := e.(LGError); i == len(frames)-1 && lge.Omitted > 0 {
+			fmt.Fprintf(&b, "  ... %d more\n", lge.Omitted)
+		}
+		b.WriteString("  at " + f.String() + "\n")
+	}
+	return b.String()
+}
@@ -1,21 +1,143 @@
 package types
 
 import (
-	"errors"
 	"fmt"
+	"math"
+	"math/big"
 	"reflect"
-	"strings"
+	"sync"
+	"sync/atomic"
 )
 
 // Errors/Exceptions
 type LGError struct {
 	Obj Top
+	// Trace lists the functions the error unwound through, innermost
+	// first, and Pos is where it happened in the innermost one that has
+	// not been pushed onto Trace yet. Omitted counts the frames left
+	// out of a full Trace. See source.go.
+	Trace   []Frame
+	Omitted int
+	Pos     *SourcePos
 }
 
 func (e LGError) Error() string {
+	if msg, ok := ErrorMessage(e.Obj); ok {
+		return msg
+	}
 	return fmt.Sprintf("%#v", e.Obj)
 }
 
+// Kinds of the errors raised by the runtime itself, as opposed to values
+// thrown by user code.
+const (
+	ArityError         = "arity-error"
+	TypeError          = "type-error"
+	ValueError         = "value-error"
+	ArithmeticError    = "arithmetic-error"
+	UnboundError       = "unbound-symbol"
+	SyntaxError        = "syntax-error"
+	ReadError          = "read-error"
+	IOError            = "io-error"
+	GoError            = "go-error"
+	StackOverflowError = "stack-overflow"
+	InterruptError     = "interrupted"
+)
+
+func errorKey(name string) Keyword {
+	return InternKeyword(name)
+}
+
+// NewError builds a runtime error. Its value is a hash-map
+//
+//	{:kind :type-error :message "..." :builtin "+" :form (+ 1 "a")}
+//
+// so that catch* handlers can inspect what went wrong. builtin and form
+// are optional and left out of the map when empty.
+func NewError(kind string, builtin string, form Top, msg string) LGError {
+	hm := HashMap{}.Assoc(errorKey("kind"), errorKey(kind)).Assoc(errorKey("message"), msg)
+	if builtin != "" {
+		hm = hm.Assoc(errorKey("builtin"), builtin)
+	}
+	if form != nil {
+		hm = hm.Assoc(errorKey("form"), form)
+	}
+	return LGError{Obj: hm}
+}
+
+func NewErrorf(kind string, builtin string, format string, args ...interface{}) LGError {
+	return NewError(kind, builtin, nil, fmt.Sprintf(format, args...))
+}
+
+// ErrorField returns a field of a runtime error value.
+func ErrorField(obj Top, field string) (Top, bool) {
+	hm, ok := obj.(HashMap)
+	if !ok {
+		return nil, false
+	}
+	if _, ok := hm.Get(errorKey("kind")); !ok {
+		return nil, false
+	}
+	return hm.Get(errorKey(field))
+}
+
+// ErrorMessage renders a runtime error value as "builtin: message".
+func ErrorMessage(obj Top) (string, bool) {
+	msg, ok := ErrorField(obj, "message")
+	if !ok {
+		return "", false
+	}
+	if builtin, ok := ErrorField(obj, "builtin"); ok {
+		return fmt.Sprintf("%v: %v", builtin, msg), true
+	}
+	return fmt.Sprintf("%v", msg), true
+}
+
+// WithForm records the offending form on a runtime error that does not
+// carry one yet; other errors are returned unchanged.
+func WithForm(e error, form Top) error {
+	lge, ok := e.(LGError)
+	if !ok {
+		return e
+	}
+	if _, ok := ErrorField(lge.Obj, "kind"); !ok {
+		return e
+	}
+	if _, ok := ErrorField(lge.Obj, "form"); ok {
+		return e
+	}
+	lge.Obj = lge.Obj.(HashMap).Assoc(errorKey("form"), form)
+	return lge
+}
+
+// CheckArity returns an arity error unless min <= len(a) <= max. A
+// negative max means no upper bound.
+func CheckArity(name string, a []Top, min int, max int) error {
+	if len(a) >= min && (max < 0 || len(a) <= max) {
+		return nil
+	}
+	var want string
+	switch {
+	case min == max:
+		want = fmt.Sprintf("%d", min)
+	case max < 0:
+		want = fmt.Sprintf("at least %d", min)
+	default:
+		want = fmt.Sprintf("%d to %d", min, max)
+	}
+	plural := "s"
+	if want == "1" {
+		plural = ""
+	}
+	return NewErrorf(ArityError, name, "expected %s argument%s, got %d", want, plural, len(a))
+}
+
+// WrongType returns a type error for a builtin that got obj where it
+// expected a value of the described type.
+func WrongType(name string, expected string, obj Top) error {
+	return NewErrorf(TypeError, name, "expected %s, got %s", expected, TypeName(obj))
+}
+
 // General types
 type Top interface {
 }
@@ -50,13 +172,90 @@ func IsSymbol(obj Top) bool {
 	return ok
 }
 
+// Gensym is a symbol that a macro written in Go makes for a temporary
+// of its expansion. It prints as its name but equals no other symbol,
+// not even one the reader makes from that name, so the temporary cannot
+// capture the symbols of the forms the macro is given.
+type Gensym struct {
+	Val string
+	id  *identity
+}
+
+func NewGensym(name string) Gensym {
+	return Gensym{name, &identity{}}
+}
+
+func (g Gensym) String() string {
+	return g.Val
+}
+
+// Keywords
+
+// Keyword is a keyword such as :name. Keywords are interned: all
+// keywords with the same name share one pointer, so comparing them is a
+// pointer comparison.
+type Keyword struct {
+	k *keyword
+}
+
+type keyword struct {
+	name string
+}
+
+var keywords sync.Map // name -> *keyword
+
+// InternKeyword returns the keyword with the given name, which does not
+// include the colon.
+func InternKeyword(name string) Keyword {
+	if k, ok := keywords.Load(name); ok {
+		return Keyword{k.(*keyword)}
+	}
+	k, _ := keywords.LoadOrStore(name, &keyword{name})
+	return Keyword{k.(*keyword)}
+}
+
 func NewKeyword(s string) (Top, error) {
-	return "\u029e" + s, nil
+	return InternKeyword(s), nil
 }
 
 func IsKeyword(obj Top) bool {
-	s, ok := obj.(string)
-	return ok && strings.HasPrefix(s, "\u029e")
+	_, ok := obj.(Keyword)
+	return ok
+}
+
+func (k Keyword) Name() string {
+	if k.k == nil {
+		return ""
+	}
+	return k.k.name
+}
+
+func (k Keyword) String() string {
+	return ":" + k.Name()
+}
+
+// Lookup applies a keyword as a function: (:k m) gets :k from the
+// hash-map m, and (:k m default) returns default if it is missing.
+func (k Keyword) Lookup(a []Top) (Top, error) {
+	name := k.String()
+	if e := CheckArity(name, a, 1, 2); e != nil {
+		return nil, e
+	}
+	var def Top
+	if len(a) == 2 {
+		def = a[1]
+	}
+	if a[0] == nil {
+		return def, nil
+	}
+	hm, ok := a[0].(HashMap)
+	if !ok {
+		return nil, WrongType(name, "hash-map", a[0])
+	}
+	if val, ok := hm.Get(k); ok {
+		return val, nil
+	}
+	return def, nil
 }
 
 func IsString(obj Top) bool {
@@ -64,9 +263,24 @@ func IsString(obj Top) bool {
 	return ok
 }
 
+// Func is a function written in Go. Go functions cannot be compared, so
+// a Func is equal only to copies of itself, those of the NewFunc call
+// that made it.
 type Func struct {
 	Fn   func([]Top) (Top, error)
 	Meta Top
+	id   *identity
+}
+
+// identity tells apart the Funcs, Builtins and Gensyms made by different
+// calls of NewFunc, NewBuiltin and NewGensym. It is not empty, so that every one allocated
+// has an address of its own.
+type identity struct {
+	_ byte
+}
+
+func NewFunc(fn func([]Top) (Top, error), meta Top) Func {
+	return Func{fn, meta, &identity{}}
 }
 
 func IsFunc(obj Top) bool {
@@ -74,46 +288,143 @@ func IsFunc(obj Top) bool {
 	return ok
 }
 
-type MalFunc struct {
-	Eval    func(Top, EnvType) (Top, error)
-	Exp     Top
-	Env     EnvType
-	Params  Top
-	IsMacro bool
-	GenEnv  func(EnvType, Top, Top) (EnvType, error)
-	Meta    Top
+// Macro is a macro written in Go. Fn returns the expansion of a call
+// from the forms it was called with.
+type Macro struct {
+	Fn   func([]Top) (Top, error)
+	Meta Top
 }
 
-func MalFunc_Q(obj Top) bool {
-	_, ok := obj.(MalFunc)
-	return ok
+func (m Macro) Call(a []Top) (Top, error) {
+	return m.Fn(a)
+}
+
+func (m Macro) GetMacro() bool {
+	return true
+}
+
+func (m Macro) GetMeta() Top {
+	return m.Meta
+}
+
+func (m Macro) WithMeta(meta Top) Top {
+	return Macro{m.Fn, meta}
+}
+
+// Builtin is a builtin that is told where it is called from, so that
+// it can call functions back as calls made from there, as map does, and
+// give up waiting when that call is interrupted, as <! does. Called as
+// any other function, it is called from NoCaller.
+type Builtin struct {
+	Fn   func(c Caller, a []Top) (Top, error)
+	Meta Top
+	id   *identity
+}
+
+// NewBuiltin returns a Builtin calling fn. Like a Func, it is equal only
+// to copies of itself.
+func NewBuiltin(fn func(c Caller, a []Top) (Top, error), meta Top) Builtin {
+	return Builtin{fn, meta, &identity{}}
+}
+
+func (b Builtin) Call(a []Top) (Top, error) {
+	return b.Fn(NoCaller, a)
+}
+
+func (b Builtin) GetMacro() bool {
+	return false
+}
+
+func (b Builtin) GetMeta() Top {
+	return b.Meta
 }
 
-func (f MalFunc) SetMacro() Top {
-	f.IsMacro = true
-	return f
+func (b Builtin) WithMeta(meta Top) Top {
+	return NewBuiltin(b.Fn, meta)
 }
 
-func (f MalFunc) GetMacro() bool {
-	return f.IsMacro
+// Caller is the call a Builtin is called from.
+type Caller interface {
+	// Apply calls f with arguments a as if the caller called it, so that
+	// it nests in the caller and is interrupted with it.
+	Apply(f Top, a []Top) (Top, error)
+	// Stop returns a channel that is closed when the caller is
+	// interrupted. It may be nil.
+	Stop() <-chan struct{}
+	// Later returns the caller of functions called back once the call
+	// has returned, as those of lazy sequences are: they are interrupted
+	// with the caller but no longer nest in it.
+	Later() Caller
 }
 
-// Take either a MalFunc or regular function and apply it to the
+// NoCaller is the caller of a builtin called from Go: it calls functions
+// back with Apply and is never interrupted.
+var NoCaller Caller = noCaller{}
+
+type noCaller struct{}
+
+func (noCaller) Apply(f Top, a []Top) (Top, error) {
+	return Apply(f, a)
+}
+
+func (noCaller) Stop() <-chan struct{} {
+	return nil
+}
+
+func (noCaller) Later() Caller {
+	return NoCaller
+}
+
+// Stopped reports whether stop, as returned by Caller.Stop, is closed.
+func Stopped(stop <-chan struct{}) bool {
+	select {
+	case <-stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// Callable is a function defined in Lisp, such as the closures of
+// package interp.
+type Callable interface {
+	Call(a []Top) (Top, error)
+	GetMacro() bool
+	GetMeta() Top
+	WithMeta(m Top) Top
+}
+
+// TailCall is returned by a builtin that ends by calling Fn with Args,
+// as apply does, so that the caller makes the call in its place: Apply
+// does so in a loop, and an evaluator can make it a tail call.
+type TailCall struct {
+	Fn   Top
+	Args []Top
+}
+
+// Take either a Lisp or regular function and apply it to the
 // arguments
 func Apply(f Top, a []Top) (Top, error) {
-	switch f := f.(type) {
-	case MalFunc:
-		env, e := f.GenEnv(f.Env, f.Params, List{a, nil})
-		if e != nil {
-			return nil, e
+	for {
+		var res Top
+		var e error
+		switch f := f.(type) {
+		case Func:
+			res, e = f.Fn(a)
+		case func([]Top) (Top, error):
+			res, e = f(a)
+		case Keyword:
+			return f.Lookup(a)
+		case Callable:
+			return f.Call(a)
+		default:
+			return nil, NewErrorf(TypeError, "", "cannot call %s", TypeName(f))
 		}
-		return f.Eval(f.Exp, env)
-	case Func:
-		return f.Fn(a)
-	case func([]Top) (Top, error):
-		return f(a)
-	default:
-		return nil, errors.New("Invalid function to Apply")
+		tc, ok := res.(TailCall)
+		if e != nil || !ok {
+			return res, e
+		}
+		f, a = tc.Fn, tc.Args
 	}
 }
 
@@ -132,30 +443,86 @@ func IsList(obj Top) bool {
 }
 
 // Vectors
+
+// Vector is an immutable vector: Conj and Assoc return a new vector that
+// shares most of its structure with the old one (see pvector.go). The
+// zero value is the empty vector.
 type Vector struct {
-	Val  []Top
+	vec  *pvector
 	Meta Top
 }
 
+// NewVector returns a vector of the given elements.
+func NewVector(a ...Top) Vector {
+	return Vector{newPVector(a), nil}
+}
+
 func IsVector(obj Top) bool {
 	_, ok := obj.(Vector)
 	return ok
 }
 
+func (v Vector) Len() int {
+	if v.vec == nil {
+		return 0
+	}
+	return v.vec.count
+}
+
+// Nth returns the i-th element; i must be in range.
+func (v Vector) Nth(i int) Top {
+	return v.vec.nth(i)
+}
+
+func (v Vector) Conj(vals ...Top) Vector {
+	vec := v.vec
+	if vec == nil {
+		vec = newPVector(nil)
+	}
+	for _, val := range vals {
+		vec = vec.conj(val)
+	}
+	return Vector{vec, v.Meta}
+}
+
+// Assoc replaces the i-th element, or appends when i is the length; i
+// must be in range.
+func (v Vector) Assoc(i int, val Top) Vector {
+	if i == v.Len() {
+		return v.Conj(val)
+	}
+	return Vector{v.vec.assoc(i, val), v.Meta}
+}
+
+// Slice returns the elements in a new slice.
+func (v Vector) Slice() []Top {
+	if v.vec == nil {
+		return []Top{}
+	}
+	return v.vec.appendTo(make([]Top, 0, v.vec.count))
+}
+
 func GetSlice(seq Top) ([]Top, error) {
 	switch obj := seq.(type) {
 	case List:
 		return obj.Val, nil
 	case Vector:
-		return obj.Val, nil
+		return obj.Slice(), nil
+	case *LazySeq, Cons:
+		return lazySlice(obj)
 	default:
-		return nil, errors.New("GetSlice called on non-sequence")
+		return nil, NewErrorf(TypeError, "", "expected list or vector, got %s", TypeName(seq))
 	}
 }
 
 // Hash Maps
+
+// HashMap is an immutable hash map from any values to any values, with
+// keys compared by Eq and hashed by Hash. Assoc and Dissoc return a new map
+// that shares most of its structure with the old one (see hamt.go). The
+// zero value is the empty map.
 type HashMap struct {
-	Val  map[string]Top
+	m    *pmap
 	Meta Top
 }
 
@@ -165,17 +532,13 @@ func NewHashMap(seq Top) (Top, error) {
 		return nil, e
 	}
 	if len(lst)%2 == 1 {
-		return nil, errors.New("Odd number of arguments to NewHashMap")
+		return nil, NewError(ArityError, "hash-map", nil, "odd number of arguments")
 	}
-	m := map[string]Top{}
+	hm := HashMap{}
 	for i := 0; i < len(lst); i += 2 {
-		str, ok := lst[i].(string)
-		if !ok {
-			return nil, errors.New("expected hash-map key string")
-		}
-		m[str] = lst[i+1]
+		hm = hm.Assoc(lst[i], lst[i+1])
 	}
-	return HashMap{m, nil}, nil
+	return hm, nil
 }
 
 func IsHashMap(obj Top) bool {
@@ -183,29 +546,218 @@ func IsHashMap(obj Top) bool {
 	return ok
 }
 
+func (hm HashMap) pmap() *pmap {
+	if hm.m == nil {
+		return emptyPMap
+	}
+	return hm.m
+}
+
+func (hm HashMap) Len() int {
+	return hm.pmap().count
+}
+
+func (hm HashMap) Get(key Top) (Top, bool) {
+	return hm.pmap().get(key)
+}
+
+func (hm HashMap) Assoc(key Top, val Top) HashMap {
+	return HashMap{hm.pmap().assoc(key, val), hm.Meta}
+}
+
+func (hm HashMap) Dissoc(key Top) HashMap {
+	return HashMap{hm.pmap().dissoc(key), hm.Meta}
+}
+
+// Each calls f for every key and value, in no particular order, until f
+// returns false.
+func (hm HashMap) Each(f func(key Top, val Top) bool) {
+	hm.pmap().each(f)
+}
+
 // Atoms
+
+// Atom is a mutable reference that is safe to use from several
+// goroutines. Its value lives in an immutable box that is replaced with
+// compare-and-swap, so Swap can retry a change that lost a race instead
+// of overwriting it. Watches are called after every change.
 type Atom struct {
-	Val  Top
+	box  atomic.Value // *atomBox
 	Meta Top
+
+	mu      sync.Mutex // guards watches
+	watches []atomWatch
+}
+
+type atomBox struct {
+	val Top
+}
+
+type atomWatch struct {
+	key Top
+	fn  Top
 }
 
-func (a *Atom) Set(val Top) Top {
-	a.Val = val
+func NewAtom(val Top) *Atom {
+	a := &Atom{}
+	a.box.Store(&atomBox{val})
 	return a
 }
 
+func (a *Atom) load() *atomBox {
+	return a.box.Load().(*atomBox)
+}
+
+func (a *Atom) Deref() Top {
+	return a.load().val
+}
+
+// Set replaces the value of the atom and returns the new value. The
+// watches are called back from c, as are those of Swap and
+// CompareAndSet.
+func (a *Atom) Set(c Caller, val Top) (Top, error) {
+	old := a.box.Swap(&atomBox{val}).(*atomBox)
+	return val, a.notify(c, old.val, val)
+}
+
+// Swap replaces the value v of the atom with f(v). f may be called
+// several times when other goroutines change the atom meanwhile, so it
+// should be free of side effects.
+func (a *Atom) Swap(c Caller, f func(Top) (Top, error)) (Top, error) {
+	for {
+		old := a.load()
+		val, e := f(old.val)
+		if e != nil {
+			return nil, e
+		}
+		if a.box.CompareAndSwap(old, &atomBox{val}) {
+			return val, a.notify(c, old.val, val)
+		}
+	}
+}
+
+// CompareAndSet sets the atom to val if its value is identical to old,
+// as Clojure's compare-and-set! does, and reports whether it did. An
+// equal but distinct collection does not match.
+func (a *Atom) CompareAndSet(c Caller, old Top, val Top) (bool, error) {
+	for {
+		cur := a.load()
+		if !Identical(cur.val, old) {
+			return false, nil
+		}
+		if a.box.CompareAndSwap(cur, &atomBox{val}) {
+			return true, a.notify(c, cur.val, val)
+		}
+	}
+}
+
+// AddWatch registers fn to be called as (fn key atom old new) after every
+// change of the atom, replacing any watch with an equal key.
+func (a *Atom) AddWatch(key Top, fn Top) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.removeWatch(key)
+	a.watches = append(a.watches, atomWatch{key, fn})
+}
+
+func (a *Atom) RemoveWatch(key Top) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.removeWatch(key)
+}
+
+func (a *Atom) removeWatch(key Top) {
+	for i, w := range a.watches {
+		if Eq(w.key, key) {
+			// Copy, so that notify can go through the old slice
+			// without holding the lock.
+			a.watches = append(a.watches[:i:i], a.watches[i+1:]...)
+			return
+		}
+	}
+}
+
+func (a *Atom) notify(c Caller, old Top, val Top) error {
+	a.mu.Lock()
+	watches := a.watches
+	a.mu.Unlock()
+	for _, w := range watches {
+		if _, e := c.Apply(w.fn, []Top{w.key, a, old, val}); e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
 func IsAtom(obj Top) bool {
 	_, ok := obj.(*Atom)
 	return ok
 }
 
+// Go values
+
+// GoObject wraps a Go value that has no Lisp counterpart, such as a
+// struct or a channel, so that scripts can hold on to it and hand it
+// back to Go. Package bridge creates them and reads their fields.
+type GoObject struct {
+	Val  interface{}
+	Meta Top
+}
+
+func IsGoObject(obj Top) bool {
+	_, ok := obj.(GoObject)
+	return ok
+}
+
 // General functions
 
-func objType(obj Top) string {
-	if obj == nil {
+// TypeName names the Lisp type of obj, for error messages.
+func TypeName(obj Top) string {
+	switch tobj := obj.(type) {
+	case nil:
 		return "nil"
+	case bool:
+		return "boolean"
+	case int, *big.Int:
+		return "integer"
+	case *big.Rat:
+		return "rational"
+	case float64:
+		return "float"
+	case string:
+		return "string"
+	case Keyword:
+		return "keyword"
+	case Symbol, Gensym:
+		return "symbol"
+	case List:
+		return "list"
+	case Vector:
+		return "vector"
+	case *LazySeq, Cons:
+		return "lazy-seq"
+	case HashMap:
+		return "hash-map"
+	case Func, func([]Top) (Top, error):
+		return "function"
+	case Callable:
+		if tobj.GetMacro() {
+			return "macro"
+		}
+		return "function"
+	case *Atom:
+		return "atom"
+	case *Future:
+		return "future"
+	case *Delay:
+		return "delay"
+	case *Chan:
+		return "channel"
+	case GoObject:
+		return "go " + reflect.TypeOf(tobj.Val).String()
+	default:
+		return reflect.TypeOf(obj).String()
 	}
-	return reflect.TypeOf(obj).Name()
 }
 
 func IsSeq(seq Top) bool {
@@ -213,7 +765,32 @@ func IsSeq(seq Top) bool {
 		return false
 	}
 	return (reflect.TypeOf(seq).Name() == "List") ||
-		(reflect.TypeOf(seq).Name() == "Vector")
+		(reflect.TypeOf(seq).Name() == "Vector") || IsLazy(seq)
+}
+
+// Identical reports whether a and b are the same object: the same list,
+// vector or hash map rather than an equal one, or the same atom, closure
+// or other reference. Values with no identity of their own, such as
+// numbers, strings and keywords, are identical when they are equal.
+func Identical(a Top, b Top) bool {
+	switch x := a.(type) {
+	case List:
+		y, ok := b.(List)
+		return ok && len(x.Val) == len(y.Val) && (len(x.Val) == 0 || &x.Val[0] == &y.Val[0])
+	case Vector:
+		y, ok := b.(Vector)
+		return ok && x.vec == y.vec
+	case HashMap:
+		y, ok := b.(HashMap)
+		return ok && x.m == y.m
+	case *big.Int, *big.Rat:
+		// Bignums and ratios are numbers despite being pointers.
+		return Eq(a, b)
+	}
+	if t := reflect.TypeOf(a); t != nil && t.Kind() == reflect.Ptr && reflect.TypeOf(b) == t {
+		return a == b
+	}
+	return Eq(a, b)
 }
 
 func Eq(a Top, b Top) bool {
@@ -222,9 +799,19 @@ func Eq(a Top, b Top) bool {
 	if !((ota == otb) || (IsSeq(a) && IsSeq(b))) {
 		return false
 	}
+	if IsLazy(a) || IsLazy(b) {
+		// An error realizing either makes them unequal.
+		as, ae := GetSlice(a)
+		bs, be := GetSlice(b)
+		return ae == nil && be == nil && Eq(List{as, nil}, List{bs, nil})
+	}
 	switch a.(type) {
 	case Symbol:
 		return a.(Symbol).Val == b.(Symbol).Val
+	case *big.Int:
+		return a.(*big.Int).Cmp(b.(*big.Int)) == 0
+	case *big.Rat:
+		return a.(*big.Rat).Cmp(b.(*big.Rat)) == 0
 	case List:
 		as, _ := GetSlice(a)
 		bs, _ := GetSlice(b)
@@ -250,18 +837,122 @@ func Eq(a Top, b Top) bool {
 		}
 		return true
 	case HashMap:
-		am := a.(HashMap).Val
-		bm := b.(HashMap).Val
-		if len(am) != len(bm) {
+		am := a.(HashMap)
+		bm := b.(HashMap)
+		if am.Len() != bm.Len() {
 			return false
 		}
-		for k, v := range am {
-			if !Eq(v, bm[k]) {
-				return false
-			}
-		}
-		return true
+		eq := true
+		am.Each(func(k Top, v Top) bool {
+			bv, ok := bm.Get(k)
+			eq = ok && Eq(v, bv)
+			return eq
+		})
+		return eq
+	case Func:
+		id := a.(Func).id
+		return id != nil && id == b.(Func).id
+	case Builtin:
+		id := a.(Builtin).id
+		return id != nil && id == b.(Builtin).id
+	case GoObject:
+		av, bv := a.(GoObject).Val, b.(GoObject).Val
+		t := reflect.TypeOf(av)
+		return t == reflect.TypeOf(bv) && t != nil && t.Comparable() && av == bv
 	default:
+		// Values holding slices or functions cannot be compared with
+		// == and are only equal to nothing.
+		if ota != nil && !ota.Comparable() {
+			return false
+		}
 		return a == b
 	}
 }
+
+// Hash returns a hash of obj that agrees with Eq: values that are Eq hash
+// alike, so lists and vectors with the same elements do, and the hash of
+// a hash-map does not depend on the order of its entries.
+func Hash(obj Top) uint32 {
+	switch tobj := obj.(type) {
+	case nil:
+		return 0
+	case bool:
+		if tobj {
+			return 1231
+		}
+		return 1237
+	case int:
+		return mixHash(uint64(tobj))
+	case float64:
+		if tobj == 0 {
+			tobj = 0 // -0.0 is Eq to 0.0
+		}
+		return mixHash(math.Float64bits(tobj))
+	case string:
+		return hashString(tobj)
+	case Keyword:
+		return hashString(tobj.Name()) ^ 0x7f4a7c15
+	case Symbol:
+		return hashString(tobj.Val) ^ 0x9e3779b9
+	case Gensym:
+		return mixHash(uint64(reflect.ValueOf(tobj.id).Pointer()))
+	case *big.Int:
+		return hashString(tobj.String())
+	case *big.Rat:
+		return hashString(tobj.RatString())
+	case List:
+		var h uint32 = 1
+		for _, x := range tobj.Val {
+			h = 31*h + Hash(x)
+		}
+		return h
+	case Vector:
+		var h uint32 = 1
+		for i := 0; i < tobj.Len(); i += 1 {
+			h = 31*h + Hash(tobj.Nth(i))
+		}
+		return h
+	case *LazySeq, Cons:
+		slc, _ := GetSlice(tobj)
+		return Hash(List{slc, nil})
+	case HashMap:
+		var h uint32
+		tobj.Each(func(k Top, v Top) bool {
+			h += Hash(k) ^ mixHash(uint64(Hash(v)))
+			return true
+		})
+		return h
+	case Func:
+		return mixHash(uint64(reflect.ValueOf(tobj.id).Pointer()))
+	case Builtin:
+		return mixHash(uint64(reflect.ValueOf(tobj.id).Pointer()))
+	case GoObject:
+		return Hash(tobj.Val)
+	default:
+		// Pointers such as atoms are Eq only to themselves. Anything
+		// else falls back to its type, which is enough to agree with Eq.
+		v := reflect.ValueOf(obj)
+		if v.Kind() == reflect.Ptr {
+			return mixHash(uint64(v.Pointer()))
+		}
+		return hashString(v.Type().String())
+	}
+}
+
+// hashString is 32-bit FNV-1a.
+func hashString(s string) uint32 {
+	h := uint32(2166136261)
+	for i := 0; i < len(s); i += 1 {
+		h ^= uint32(s[i])
+		h *= 16777619
+	}
+	return h
+}
+
+// mixHash folds a 64-bit value into 32 well-mixed bits.
+func mixHash(x uint64) uint32 {
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	return uint32(x)
+}
@@ -0,0 +1,168 @@
+package types
+
+import (
+	"math/big"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func inc(val Top) (Top, error) {
+	return val.(int) + 1, nil
+}
+
+func TestAtomSwapIsAtomic(t *testing.T) {
+	a := NewAtom(0)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i += 1 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j += 1 {
+				a.Swap(NoCaller, inc)
+			}
+		}()
+	}
+	wg.Wait()
+	if a.Deref() != 8000 {
+		t.Errorf("expected 8000, actual: %v", a.Deref())
+	}
+}
+
+func TestAtomCompareAndSet(t *testing.T) {
+	old := List{[]Top{1}, nil}
+	a := NewAtom(old)
+	if ok, _ := a.CompareAndSet(NoCaller, List{[]Top{2}, nil}, 3); ok {
+		t.Error("compare-and-set succeeded with a different old value")
+	}
+	if ok, _ := a.CompareAndSet(NoCaller, List{[]Top{1}, nil}, 3); ok {
+		t.Error("compare-and-set succeeded with an equal but distinct old value")
+	}
+	if ok, _ := a.CompareAndSet(NoCaller, old, 3); !ok || a.Deref() != 3 {
+		t.Errorf("compare-and-set failed with the old value itself: %v", a.Deref())
+	}
+	if ok, _ := a.CompareAndSet(NoCaller, 3, 4); !ok || a.Deref() != 4 {
+		t.Errorf("compare-and-set failed with an equal number: %v", a.Deref())
+	}
+	// Ratios and bignums are pointers, but compared as numbers: each
+	// call of n makes a new one.
+	for _, n := range []func() Top{
+		func() Top { return big.NewRat(1, 2) },
+		func() Top { n, _ := new(big.Int).SetString("123456789012345678901234567890", 10); return n },
+	} {
+		a := NewAtom(n())
+		if ok, _ := a.CompareAndSet(NoCaller, n(), 5); !ok || a.Deref() != 5 {
+			t.Errorf("compare-and-set failed with an equal %T: %v", n(), a.Deref())
+		}
+	}
+}
+
+func TestAtomWatches(t *testing.T) {
+	a := NewAtom(0)
+	var calls [][]Top
+	watch := NewFunc(func(args []Top) (Top, error) {
+		calls = append(calls, args)
+		return nil, nil
+	}, nil)
+	a.AddWatch("w", watch)
+	a.AddWatch("w", watch)
+	a.Set(NoCaller, 1)
+	a.Swap(NoCaller, inc)
+	a.RemoveWatch("w")
+	a.Set(NoCaller, 5)
+	if len(calls) != 2 {
+		t.Fatalf("expected 2 watch calls, actual: %v", calls)
+	}
+	if calls[1][0] != "w" || calls[1][1] != a || calls[1][2] != 1 || calls[1][3] != 2 {
+		t.Errorf("unexpected watch arguments: %v", calls[1])
+	}
+}
+
+func TestAtomWatchError(t *testing.T) {
+	a := NewAtom(0)
+	a.AddWatch("w", NewFunc(func(args []Top) (Top, error) {
+		return nil, LGError{Obj: "bad"}
+	}, nil))
+	if _, e := a.Set(NoCaller, 1); e == nil {
+		t.Error("expected the watch error")
+	}
+	if a.Deref() != 1 {
+		t.Errorf("the change should stay, actual: %v", a.Deref())
+	}
+}
+
+func TestKeywordsAreInterned(t *testing.T) {
+	a, b := InternKeyword("a"), InternKeyword("a")
+	if a != b || a.k != b.k {
+		t.Error("keywords with the same name are distinct")
+	}
+	if a == InternKeyword("b") {
+		t.Error("keywords with different names are equal")
+	}
+	if s := "\u029ea"; IsKeyword(s) || a.Name() != "a" {
+		t.Error("a string that looks like the old encoding is a keyword")
+	}
+}
+
+func TestApplyMakesTailCalls(t *testing.T) {
+	var f Func
+	f = NewFunc(func(args []Top) (Top, error) {
+		if n := args[0].(int); n > 0 {
+			return TailCall{f, []Top{n - 1}}, nil
+		}
+		return "done", nil
+	}, nil)
+	if res, e := Apply(f, []Top{3}); e != nil || res != "done" {
+		t.Errorf("expected done, actual: %v, %v", res, e)
+	}
+}
+
+func TestBacktraceIsBounded(t *testing.T) {
+	var e error = NewError(ValueError, "", nil, "deep")
+	for i := 0; i < MaxTrace+10; i += 1 {
+		e = PushFrame(e, "f", nil)
+	}
+	if n := len(Backtrace(e)); n != MaxTrace+1 {
+		t.Errorf("expected %d frames, actual: %d", MaxTrace+1, n)
+	}
+	if s := FormatBacktrace(e); !strings.Contains(s, "  ... 10 more\n  at <toplevel>") {
+		t.Errorf("omitted frames not reported:\n%s", s[len(s)-60:])
+	}
+}
+
+// countdown is a lazy sequence that returns another n times before
+// returning the list (done).
+func countdown(n int) *LazySeq {
+	return NewLazySeq(func(a []Top) (Top, error) {
+		if n == 0 {
+			return List{[]Top{"done"}, nil}, nil
+		}
+		return countdown(n - 1), nil
+	})
+}
+
+func TestLazySeqRealizesChainsInALoop(t *testing.T) {
+	x, err := First(countdown(1000000))
+	if err != nil || x != "done" {
+		t.Errorf("expected done, actual: %v, %v", x, err)
+	}
+}
+
+func TestLazySeqCallsItsFunctionOnce(t *testing.T) {
+	calls := 0
+	ls := NewLazySeq(func(a []Top) (Top, error) {
+		calls += 1
+		return Cons{1, nil}, nil
+	})
+	for i := 0; i < 3; i += 1 {
+		if s, _ := GetSlice(ls); len(s) != 1 {
+			t.Fatalf("expected one element, actual: %v", s)
+		}
+	}
+	if calls != 1 || !ls.Realized() {
+		t.Errorf("expected one call, actual: %d", calls)
+	}
+	if !Eq(ls, List{[]Top{1}, nil}) || Hash(ls) != Hash(NewVector(1)) {
+		t.Error("a lazy seq is not equal to the list of its elements")
+	}
+}